@@ -0,0 +1,157 @@
+package actor
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrStopped  = errors.New("actor stopped")
+	ErrClosed   = errors.New("actor system closed")
+	ErrTimeout  = errors.New("actor ask timeout")
+	ErrNotFound = errors.New("actor not found")
+	ErrExists   = errors.New("actor already exists")
+)
+
+// Actor owns the state of one entity (player, guild, room...).
+// All the methods and handlers of an actor are called one by one.
+type Actor interface {
+	// register the message handlers here
+	OnStart(ctx *Context)
+	// called on Stop, passivation and before a restart
+	OnStop(ctx *Context)
+}
+
+// Context is valid from OnStart to OnStop
+type Context struct {
+	ref        *Ref
+	functions  map[interface{}]interface{}
+	passivate  bool
+	stopCalled bool
+}
+
+func (ctx *Context) Key() interface{} {
+	return ctx.ref.key
+}
+
+func (ctx *Context) Self() *Ref {
+	return ctx.ref
+}
+
+func (ctx *Context) System() *System {
+	return ctx.ref.system
+}
+
+// f must be one of:
+// func(args []interface{})
+// func(args []interface{}) interface{}
+func (ctx *Context) Register(id interface{}, f interface{}) {
+	switch f.(type) {
+	case func([]interface{}):
+	case func([]interface{}) interface{}:
+	default:
+		panic(fmt.Sprintf("function id %v: definition of function is invalid", id))
+	}
+
+	if _, ok := ctx.functions[id]; ok {
+		panic(fmt.Sprintf("function id %v: already registered", id))
+	}
+
+	ctx.functions[id] = f
+}
+
+// Passivate stops the actor once the current message is done,
+// the next message sent to its Ref starts it again
+func (ctx *Context) Passivate() {
+	ctx.passivate = true
+}
+
+// Stop stops the actor once the current message is done
+func (ctx *Context) Stop() {
+	ctx.stopCalled = true
+}
+
+// Ref is the goroutine safe handle of an actor
+type Ref struct {
+	key        interface{}
+	system     *System
+	producer   func() Actor
+	mailbox    mailbox
+	state      int
+	scheduled  bool
+	lastActive time.Time
+
+	// owned by the worker processing the mailbox
+	actor Actor
+	ctx   *Context
+}
+
+func (r *Ref) Key() interface{} {
+	return r.key
+}
+
+// Tell posts a message without waiting for it
+func (r *Ref) Tell(id interface{}, args ...interface{}) error {
+	return r.system.post(r, &message{kind: kindMsg, id: id, args: args})
+}
+
+// Ask posts a message and waits for the return value of its handler, at
+// most System.AskTimeout
+func (r *Ref) Ask(id interface{}, args ...interface{}) (interface{}, error) {
+	chanRet := make(chan *retInfo, 1)
+	err := r.system.post(r, &message{kind: kindMsg, id: id, args: args, chanRet: chanRet})
+	if err != nil {
+		return nil, err
+	}
+
+	t := time.NewTimer(r.system.AskTimeout)
+	defer t.Stop()
+	select {
+	case ri := <-chanRet:
+		return ri.ret, ri.err
+	case <-t.C:
+		return nil, ErrTimeout
+	}
+}
+
+// Stop stops the actor after the messages already posted
+func (r *Ref) Stop() error {
+	return r.system.post(r, &message{kind: kindStop})
+}
+
+const (
+	kindMsg = iota
+	kindStart
+	kindStop
+	kindPassivate
+)
+
+type message struct {
+	kind    int
+	id      interface{}
+	args    []interface{}
+	chanRet chan *retInfo
+}
+
+type retInfo struct {
+	ret interface{}
+	err error
+}
+
+type mailbox []*message
+
+func (m *mailbox) push(msg *message) {
+	*m = append(*m, msg)
+}
+
+func (m *mailbox) pop() *message {
+	msg := (*m)[0]
+	(*m)[0] = nil
+	*m = (*m)[1:]
+	return msg
+}
+
+func (m *mailbox) len() int {
+	return len(*m)
+}
@@ -0,0 +1,212 @@
+package actor_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/CreFire/leaf/actor"
+)
+
+type Player struct {
+	gold int
+}
+
+func (p *Player) OnStart(ctx *actor.Context) {
+	fmt.Println("start", ctx.Key())
+
+	ctx.Register("AddGold", func(args []interface{}) {
+		p.gold += args[0].(int)
+	})
+	ctx.Register("Gold", func(args []interface{}) interface{} {
+		return p.gold
+	})
+	ctx.Register("Logout", func(args []interface{}) {
+		ctx.Passivate()
+	})
+	ctx.Register("Bug", func(args []interface{}) {
+		panic("bug")
+	})
+}
+
+func (p *Player) OnStop(ctx *actor.Context) {
+	fmt.Println("stop", ctx.Key())
+}
+
+func Example() {
+	s := &actor.System{
+		Workers:    4,
+		Supervisor: actor.SupervisorRestart,
+		Activate: func(key interface{}) actor.Actor {
+			return new(Player)
+		},
+	}
+	s.Init()
+
+	// messages of one actor are handled in order
+	for i := 0; i < 100; i++ {
+		s.Tell(1001, "AddGold", 1)
+	}
+	gold, err := s.Ask(1001, "Gold")
+	fmt.Println(gold, err)
+
+	// passivate, the next message starts it again
+	s.Tell(1001, "Logout")
+	for s.NumActor() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	gold, err = s.Ask(1001, "Gold")
+	fmt.Println(gold, err)
+
+	// restart on panic
+	s.Tell(1001, "AddGold", 1)
+	_, err = s.Ask(1001, "Bug")
+	fmt.Println(err != nil)
+	gold, err = s.Ask(1001, "Gold")
+	fmt.Println(gold, err)
+
+	s.Close()
+
+	// Output:
+	// start 1001
+	// 100 <nil>
+	// stop 1001
+	// start 1001
+	// 0 <nil>
+	// stop 1001
+	// start 1001
+	// true
+	// 0 <nil>
+	// stop 1001
+}
+
+// Counter sends its count on stopped when it stops
+type Counter struct {
+	n       int
+	stopped chan int
+}
+
+func (c *Counter) OnStart(ctx *actor.Context) {
+	ctx.Register("Add", func(args []interface{}) {
+		c.n++
+	})
+	ctx.Register("Get", func(args []interface{}) interface{} {
+		return c.n
+	})
+	ctx.Register("Bug", func(args []interface{}) {
+		panic("bug")
+	})
+	ctx.Register("AskSelf", func(args []interface{}) {
+		_, err := ctx.Self().Ask("Get")
+		args[0].(chan error) <- err
+	})
+}
+
+func (c *Counter) OnStop(ctx *actor.Context) {
+	c.stopped <- c.n
+}
+
+func ExampleRef_Stop() {
+	s := &actor.System{Workers: 2}
+	s.Init()
+	defer s.Close()
+
+	stopped := make(chan int, 4)
+	r, _ := s.Spawn("c", func() actor.Actor {
+		return &Counter{stopped: stopped}
+	})
+
+	// the messages posted before Stop are handled
+	r.Tell("Add")
+	r.Stop()
+	fmt.Println(<-stopped)
+	fmt.Println(r.Tell("Add"), s.Get("c") == nil)
+
+	// Output:
+	// 1
+	// actor stopped true
+}
+
+func ExampleSupervisor_stop() {
+	s := &actor.System{Workers: 2, Supervisor: actor.SupervisorStop}
+	s.Init()
+	defer s.Close()
+
+	stopped := make(chan int, 4)
+	r, _ := s.Spawn("c", func() actor.Actor {
+		return &Counter{stopped: stopped}
+	})
+
+	r.Tell("Add")
+	_, err := r.Ask("Bug")
+	fmt.Println(err != nil, <-stopped)
+	_, err = r.Ask("Get")
+	fmt.Println(err)
+
+	// Output:
+	// true 1
+	// actor stopped
+}
+
+func ExampleSupervisor_restart() {
+	s := &actor.System{Workers: 2, Supervisor: actor.SupervisorRestart}
+	s.Init()
+	defer s.Close()
+
+	stopped := make(chan int, 4)
+	r, _ := s.Spawn("c", func() actor.Actor {
+		return &Counter{stopped: stopped}
+	})
+
+	// a new actor from the producer, with a new state
+	r.Tell("Add")
+	_, err := r.Ask("Bug")
+	fmt.Println(err != nil, <-stopped)
+	fmt.Println(r.Ask("Get"))
+
+	// Output:
+	// true 1
+	// 0 <nil>
+}
+
+func ExampleSystem_passivateAfter() {
+	stopped := make(chan int, 4)
+	s := &actor.System{
+		Workers:        2,
+		PassivateAfter: 10 * time.Millisecond,
+		Activate: func(key interface{}) actor.Actor {
+			return &Counter{stopped: stopped}
+		},
+	}
+	s.Init()
+	defer s.Close()
+
+	// passivated once idle, the next message activates it again
+	s.Tell("c", "Add")
+	fmt.Println(<-stopped, s.NumActor())
+	n, err := s.Ask("c", "Get")
+	fmt.Println(n, err, s.NumActor())
+
+	// Output:
+	// 1 0
+	// 0 <nil> 1
+}
+
+func ExampleRef_Ask_self() {
+	s := &actor.System{Workers: 1, AskTimeout: 10 * time.Millisecond}
+	s.Init()
+	defer s.Close()
+
+	r, _ := s.Spawn("c", func() actor.Actor {
+		return &Counter{stopped: make(chan int, 4)}
+	})
+
+	// an actor asking itself times out instead of blocking its worker
+	errc := make(chan error, 1)
+	r.Tell("AskSelf", errc)
+	fmt.Println(<-errc)
+	fmt.Println(r.Ask("Get"))
+
+	// Output:
+	// actor ask timeout
+	// 0 <nil>
+}
@@ -0,0 +1,409 @@
+package actor
+
+import (
+	"container/list"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/CreFire/leaf/conf"
+	"github.com/CreFire/leaf/network/cstruct"
+	log "github.com/sirupsen/logrus"
+)
+
+// Supervisor decides what happens to an actor whose handler panics
+type Supervisor int
+
+const (
+	// SupervisorResume keeps the actor and its state
+	SupervisorResume Supervisor = iota
+	// SupervisorRestart calls OnStop and starts a new actor from its producer
+	SupervisorRestart
+	// SupervisorStop stops the actor
+	SupervisorStop
+)
+
+const (
+	stateRunning = iota
+	statePassivated
+	stateStopped
+)
+
+// System processes the mailboxes of its actors on Workers goroutines
+type System struct {
+	Workers        int
+	PassivateAfter time.Duration
+	// 0 means 5 seconds, an actor asking itself or two actors asking each
+	// other block their workers until the timeout
+	AskTimeout time.Duration
+	Supervisor Supervisor
+	// Activate creates the actor of a key on first Tell/Ask, may be nil
+	Activate func(key interface{}) Actor
+
+	mutex     sync.Mutex
+	cond      *sync.Cond
+	refs      map[interface{}]*Ref
+	ready     *list.List
+	closeFlag bool
+	closeSig  chan bool
+	wg        sync.WaitGroup
+}
+
+func (s *System) Init() {
+	if s.Workers <= 0 {
+		s.Workers = runtime.NumCPU()
+	}
+	if s.AskTimeout <= 0 {
+		s.AskTimeout = 5 * time.Second
+	}
+
+	s.cond = sync.NewCond(&s.mutex)
+	s.refs = make(map[interface{}]*Ref)
+	s.ready = list.New()
+	s.closeSig = make(chan bool)
+
+	s.wg.Add(s.Workers)
+	for i := 0; i < s.Workers; i++ {
+		go s.work()
+	}
+	if s.PassivateAfter > 0 {
+		s.wg.Add(1)
+		go s.sweep()
+	}
+}
+
+// Close stops all actors and waits for their mailboxes to drain
+func (s *System) Close() {
+	s.mutex.Lock()
+	if s.closeFlag {
+		s.mutex.Unlock()
+		return
+	}
+	s.closeFlag = true
+	for _, r := range s.refs {
+		s.pushLocked(r, &message{kind: kindStop})
+	}
+	s.cond.Broadcast()
+	s.mutex.Unlock()
+
+	close(s.closeSig)
+	s.wg.Wait()
+}
+
+// goroutine safe
+func (s *System) Spawn(key interface{}, producer func() Actor) (*Ref, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closeFlag {
+		return nil, ErrClosed
+	}
+	if _, ok := s.refs[key]; ok {
+		return nil, ErrExists
+	}
+	return s.spawnLocked(key, producer), nil
+}
+
+// goroutine safe
+func (s *System) Get(key interface{}) *Ref {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.refs[key]
+}
+
+// goroutine safe
+func (s *System) NumActor() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.refs)
+}
+
+// Tell sends to the actor of key, activating it if needed
+// goroutine safe
+func (s *System) Tell(key interface{}, id interface{}, args ...interface{}) error {
+	r, err := s.ref(key)
+	if err != nil {
+		return err
+	}
+	return r.Tell(id, args...)
+}
+
+// goroutine safe
+func (s *System) Ask(key interface{}, id interface{}, args ...interface{}) (interface{}, error) {
+	r, err := s.ref(key)
+	if err != nil {
+		return nil, err
+	}
+	return r.Ask(id, args...)
+}
+
+// goroutine safe
+func (s *System) Stop(key interface{}) error {
+	r := s.Get(key)
+	if r == nil {
+		return ErrNotFound
+	}
+	return r.Stop()
+}
+
+// MsgHandler routes gate messages to the actor returned by keyOf instead of
+// a module's ChanRPC, the actor handles them with the id msg.MsgId and the
+// args (msg, agent), as a chanrpc router would.
+//
+// msg.Processor.SetHandler(mainCmdID, subCmdID, system.MsgHandler(keyOf))
+func (s *System) MsgHandler(keyOf func(msg *cstruct.RecvMsg, agent interface{}) interface{}) cstruct.MsgHandler {
+	return func(args []interface{}) {
+		msg := args[0].(*cstruct.RecvMsg)
+		agent := args[1]
+
+		key := keyOf(msg, agent)
+		if key == nil {
+			log.Debugf("message %v: no actor", msg.MsgId)
+			return
+		}
+		if err := s.Tell(key, msg.MsgId, msg, agent); err != nil {
+			log.Debugf("message %v: actor %v: %v", msg.MsgId, key, err)
+		}
+	}
+}
+
+func (s *System) ref(key interface{}) (*Ref, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if r, ok := s.refs[key]; ok {
+		return r, nil
+	}
+	if s.closeFlag {
+		return nil, ErrClosed
+	}
+	if s.Activate == nil {
+		return nil, ErrNotFound
+	}
+	return s.spawnLocked(key, func() Actor {
+		return s.Activate(key)
+	}), nil
+}
+
+func (s *System) spawnLocked(key interface{}, producer func() Actor) *Ref {
+	r := new(Ref)
+	r.key = key
+	r.system = s
+	r.producer = producer
+	r.state = stateRunning
+	s.refs[key] = r
+	s.pushLocked(r, &message{kind: kindStart})
+	return r
+}
+
+func (s *System) post(r *Ref, m *message) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closeFlag {
+		return ErrClosed
+	}
+
+	switch r.state {
+	case stateStopped:
+		return ErrStopped
+	case statePassivated:
+		if cur, ok := s.refs[r.key]; ok && cur != r {
+			return ErrStopped
+		}
+		r.state = stateRunning
+		s.refs[r.key] = r
+		s.pushLocked(r, &message{kind: kindStart})
+	}
+
+	s.pushLocked(r, m)
+	return nil
+}
+
+func (s *System) pushLocked(r *Ref, m *message) {
+	r.mailbox.push(m)
+	if !r.scheduled {
+		r.scheduled = true
+		s.ready.PushBack(r)
+		s.cond.Signal()
+	}
+}
+
+func (s *System) work() {
+	defer s.wg.Done()
+
+	for {
+		s.mutex.Lock()
+		for s.ready.Len() == 0 && !s.closeFlag {
+			s.cond.Wait()
+		}
+		if s.ready.Len() == 0 {
+			s.mutex.Unlock()
+			return
+		}
+		r := s.ready.Remove(s.ready.Front()).(*Ref)
+		m := r.mailbox.pop()
+		s.mutex.Unlock()
+
+		s.process(r, m)
+
+		s.mutex.Lock()
+		r.lastActive = time.Now()
+		if r.mailbox.len() > 0 {
+			s.ready.PushBack(r)
+			s.cond.Signal()
+		} else {
+			r.scheduled = false
+		}
+		s.mutex.Unlock()
+	}
+}
+
+func (s *System) sweep() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.PassivateAfter / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closeSig:
+			return
+		case now := <-ticker.C:
+			s.mutex.Lock()
+			for _, r := range s.refs {
+				if r.state == stateRunning && !r.scheduled && now.Sub(r.lastActive) >= s.PassivateAfter {
+					s.pushLocked(r, &message{kind: kindPassivate})
+				}
+			}
+			s.mutex.Unlock()
+		}
+	}
+}
+
+func (s *System) process(r *Ref, m *message) {
+	switch m.kind {
+	case kindStart:
+		s.start(r)
+	case kindStop:
+		s.stop(r, stateStopped)
+	case kindPassivate:
+		s.stop(r, statePassivated)
+	case kindMsg:
+		s.exec(r, m)
+	}
+}
+
+func (s *System) start(r *Ref) {
+	if r.actor != nil {
+		return
+	}
+
+	r.actor = r.producer()
+	r.ctx = &Context{ref: r, functions: make(map[interface{}]interface{})}
+	if err := s.safeCall(r, r.actor.OnStart); err != nil {
+		log.Errorf("actor %v start: %v", r.key, err)
+		s.stop(r, stateStopped)
+	}
+}
+
+// passivation is skipped if the actor has got new messages
+func (s *System) stop(r *Ref, state int) {
+	s.mutex.Lock()
+	if state == statePassivated && (r.state != stateRunning || r.mailbox.len() > 0) {
+		s.mutex.Unlock()
+		return
+	}
+	if r.state != stateStopped {
+		r.state = state
+	}
+	if s.refs[r.key] == r {
+		delete(s.refs, r.key)
+	}
+	s.mutex.Unlock()
+
+	if r.actor == nil {
+		return
+	}
+	if err := s.safeCall(r, r.actor.OnStop); err != nil {
+		log.Errorf("actor %v stop: %v", r.key, err)
+	}
+	r.actor = nil
+	r.ctx = nil
+}
+
+func (s *System) exec(r *Ref, m *message) {
+	if r.actor == nil {
+		s.ret(m, &retInfo{err: ErrStopped})
+		return
+	}
+
+	f := r.ctx.functions[m.id]
+	if f == nil {
+		s.ret(m, &retInfo{err: fmt.Errorf("function id %v: function not registered", m.id)})
+		return
+	}
+
+	var ri retInfo
+	err := s.safeCall(r, func(*Context) {
+		switch f := f.(type) {
+		case func([]interface{}):
+			f(m.args)
+		case func([]interface{}) interface{}:
+			ri.ret = f(m.args)
+		}
+	})
+	if err != nil {
+		log.Errorf("actor %v: %v", r.key, err)
+		s.supervise(r)
+		s.ret(m, &retInfo{err: err})
+		return
+	}
+	s.ret(m, &ri)
+
+	ctx := r.ctx
+	if ctx.stopCalled {
+		s.stop(r, stateStopped)
+	} else if ctx.passivate {
+		ctx.passivate = false
+		s.stop(r, statePassivated)
+	}
+}
+
+func (s *System) supervise(r *Ref) {
+	switch s.Supervisor {
+	case SupervisorRestart:
+		if err := s.safeCall(r, r.actor.OnStop); err != nil {
+			log.Errorf("actor %v stop: %v", r.key, err)
+		}
+		r.actor = nil
+		s.start(r)
+	case SupervisorStop:
+		s.stop(r, stateStopped)
+	}
+}
+
+func (s *System) ret(m *message, ri *retInfo) {
+	if m.chanRet != nil {
+		m.chanRet <- ri
+	}
+}
+
+func (s *System) safeCall(r *Ref, f func(*Context)) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if conf.LenStackBuf > 0 {
+				buf := make([]byte, conf.LenStackBuf)
+				l := runtime.Stack(buf, false)
+				err = fmt.Errorf("%v: %s", r, buf[:l])
+			} else {
+				err = fmt.Errorf("%v", r)
+			}
+		}
+	}()
+
+	f(r.ctx)
+	return
+}
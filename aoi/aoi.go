@@ -0,0 +1,174 @@
+package aoi
+
+import (
+	"errors"
+
+	"github.com/CreFire/leaf/gate"
+	"github.com/CreFire/leaf/network/cstruct"
+)
+
+var (
+	ErrEntityExists   = errors.New("entity already exists")
+	ErrEntityNotFound = errors.New("entity not found")
+)
+
+type Entity struct {
+	ID    interface{}
+	X, Y  float32
+	Agent gate.Agent // nil for npcs
+	Data  interface{}
+
+	// grid
+	cell  *cell
+	index int
+	// cross list
+	xnode, ynode *node
+}
+
+// Listener gets the visibility changes, visibility is symmetric so every
+// change is reported to both sides
+type Listener interface {
+	// watcher sees target from now on
+	OnEnter(watcher *Entity, target *Entity)
+	// watcher does not see target any more
+	OnLeave(watcher *Entity, target *Entity)
+	// target moved within the sight of watcher
+	OnMove(watcher *Entity, target *Entity)
+}
+
+// Broadcaster is implemented by gate.Gate
+type Broadcaster interface {
+	Broadcast(agents []gate.Agent, recv *cstruct.RecvMsg, mainCmdID uint16, subCmdID uint16, msg interface{}) error
+}
+
+type index interface {
+	add(e *Entity)
+	remove(e *Entity)
+	// move updates the position of e, it returns false if the neighbors of
+	// e can not have changed
+	move(e *Entity, x, y float32) bool
+	near(e *Entity, f func(o *Entity))
+}
+
+// Space is an area of interest, use NewGrid or NewCrossList to create one
+// one space per goroutine (goroutine not safe)
+type Space struct {
+	Listener Listener
+	index    index
+	entities map[interface{}]*Entity
+}
+
+func newSpace(i index) *Space {
+	s := new(Space)
+	s.index = i
+	s.entities = make(map[interface{}]*Entity)
+	return s
+}
+
+func (s *Space) Get(id interface{}) *Entity {
+	return s.entities[id]
+}
+
+func (s *Space) NumEntity() int {
+	return len(s.entities)
+}
+
+func (s *Space) Enter(e *Entity) error {
+	if _, ok := s.entities[e.ID]; ok {
+		return ErrEntityExists
+	}
+
+	s.entities[e.ID] = e
+	s.index.add(e)
+	if s.Listener != nil {
+		s.index.near(e, func(o *Entity) {
+			s.Listener.OnEnter(o, e)
+			s.Listener.OnEnter(e, o)
+		})
+	}
+	return nil
+}
+
+func (s *Space) Leave(id interface{}) error {
+	e, ok := s.entities[id]
+	if !ok {
+		return ErrEntityNotFound
+	}
+
+	if s.Listener != nil {
+		s.index.near(e, func(o *Entity) {
+			s.Listener.OnLeave(o, e)
+			s.Listener.OnLeave(e, o)
+		})
+	}
+	s.index.remove(e)
+	delete(s.entities, id)
+	return nil
+}
+
+func (s *Space) Move(id interface{}, x, y float32) error {
+	e, ok := s.entities[id]
+	if !ok {
+		return ErrEntityNotFound
+	}
+
+	if s.Listener == nil {
+		s.index.move(e, x, y)
+		return nil
+	}
+
+	var before []*Entity
+	s.index.near(e, func(o *Entity) {
+		before = append(before, o)
+	})
+	if !s.index.move(e, x, y) {
+		for _, o := range before {
+			s.Listener.OnMove(o, e)
+		}
+		return nil
+	}
+
+	seen := make(map[*Entity]bool, len(before))
+	for _, o := range before {
+		seen[o] = false
+	}
+	s.index.near(e, func(o *Entity) {
+		if _, ok := seen[o]; ok {
+			seen[o] = true
+			s.Listener.OnMove(o, e)
+			return
+		}
+		s.Listener.OnEnter(o, e)
+		s.Listener.OnEnter(e, o)
+	})
+	for _, o := range before {
+		if !seen[o] {
+			s.Listener.OnLeave(o, e)
+			s.Listener.OnLeave(e, o)
+		}
+	}
+	return nil
+}
+
+// Neighbors visits the entities e sees, e excluded
+func (s *Space) Neighbors(e *Entity, f func(o *Entity)) {
+	s.index.near(e, f)
+}
+
+// Watchers returns the agents which see e
+func (s *Space) Watchers(e *Entity) []gate.Agent {
+	var agents []gate.Agent
+	s.index.near(e, func(o *Entity) {
+		if o.Agent != nil {
+			agents = append(agents, o.Agent)
+		}
+	})
+	return agents
+}
+
+// Broadcast sends msg to the watchers of e, msg is marshaled once by b
+//
+// space.Broadcast(gate, e, 2, 1, &Move{...})
+func (s *Space) Broadcast(b Broadcaster, e *Entity, mainCmdID uint16, subCmdID uint16, msg interface{}) error {
+	return b.Broadcast(s.Watchers(e), cstruct.DefaultRecvMsg, mainCmdID, subCmdID, msg)
+}
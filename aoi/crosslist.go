@@ -0,0 +1,151 @@
+package aoi
+
+import (
+	"fmt"
+)
+
+type node struct {
+	e          *Entity
+	prev, next *node
+}
+
+// list sorted by one coordinate
+type axis struct {
+	head  *node
+	coord func(e *Entity) float32
+}
+
+func (a *axis) insert(n *node) {
+	v := a.coord(n.e)
+	var prev *node
+	next := a.head
+	for next != nil && a.coord(next.e) < v {
+		prev, next = next, next.next
+	}
+	a.link(n, prev, next)
+}
+
+func (a *axis) link(n, prev, next *node) {
+	n.prev, n.next = prev, next
+	if prev != nil {
+		prev.next = n
+	} else {
+		a.head = n
+	}
+	if next != nil {
+		next.prev = n
+	}
+}
+
+func (a *axis) unlink(n *node) {
+	if n.prev != nil {
+		n.prev.next = n.next
+	} else {
+		a.head = n.next
+	}
+	if n.next != nil {
+		n.next.prev = n.prev
+	}
+	n.prev, n.next = nil, nil
+}
+
+// fix moves n to its sorted position, starting from where it is, so a short
+// move is a short walk
+func (a *axis) fix(n *node) {
+	v := a.coord(n.e)
+	prev, next := n.prev, n.next
+	switch {
+	case prev != nil && a.coord(prev.e) > v:
+		a.unlink(n)
+		for prev.prev != nil && a.coord(prev.prev.e) > v {
+			prev = prev.prev
+		}
+		a.link(n, prev.prev, prev)
+	case next != nil && a.coord(next.e) < v:
+		a.unlink(n)
+		for next.next != nil && a.coord(next.next.e) < v {
+			next = next.next
+		}
+		a.link(n, next, next.next)
+	}
+}
+
+// window returns the entities within r of n on the axis
+func (a *axis) window(n *node, r float32) []*Entity {
+	v := a.coord(n.e)
+	var entities []*Entity
+	for p := n.prev; p != nil && v-a.coord(p.e) <= r; p = p.prev {
+		entities = append(entities, p.e)
+	}
+	for p := n.next; p != nil && a.coord(p.e)-v <= r; p = p.next {
+		entities = append(entities, p.e)
+	}
+	return entities
+}
+
+type crossList struct {
+	radius float32
+	x, y   axis
+}
+
+// NewCrossList creates a space of two lists sorted by x and by y, an
+// entity sees the entities within radius on both axes. It fits sparse and
+// unbounded maps better than NewGrid.
+func NewCrossList(radius float32) *Space {
+	if radius <= 0 {
+		panic(fmt.Sprintf("invalid radius %v", radius))
+	}
+
+	l := new(crossList)
+	l.radius = radius
+	l.x.coord = func(e *Entity) float32 { return e.X }
+	l.y.coord = func(e *Entity) float32 { return e.Y }
+	return newSpace(l)
+}
+
+func (l *crossList) add(e *Entity) {
+	e.xnode = &node{e: e}
+	e.ynode = &node{e: e}
+	l.x.insert(e.xnode)
+	l.y.insert(e.ynode)
+}
+
+func (l *crossList) remove(e *Entity) {
+	l.x.unlink(e.xnode)
+	l.y.unlink(e.ynode)
+	e.xnode = nil
+	e.ynode = nil
+}
+
+func (l *crossList) move(e *Entity, x, y float32) bool {
+	e.X, e.Y = x, y
+	l.x.fix(e.xnode)
+	l.y.fix(e.ynode)
+	return true
+}
+
+func (l *crossList) near(e *Entity, f func(o *Entity)) {
+	// filter the shorter window by the other axis
+	xs := l.x.window(e.xnode, l.radius)
+	ys := l.y.window(e.ynode, l.radius)
+	if len(xs) <= len(ys) {
+		for _, o := range xs {
+			if abs(o.Y-e.Y) <= l.radius {
+				f(o)
+			}
+		}
+	} else {
+		for _, o := range ys {
+			if abs(o.X-e.X) <= l.radius {
+				f(o)
+			}
+		}
+	}
+}
+
+func abs(v float32) float32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,97 @@
+package aoi_test
+
+import (
+	"fmt"
+
+	"github.com/CreFire/leaf/aoi"
+	"github.com/CreFire/leaf/gate"
+	"github.com/CreFire/leaf/network/cstruct"
+)
+
+type listener struct{}
+
+func (l *listener) OnEnter(watcher *aoi.Entity, target *aoi.Entity) {
+	fmt.Println(watcher.ID, "sees", target.ID)
+}
+
+func (l *listener) OnLeave(watcher *aoi.Entity, target *aoi.Entity) {
+	fmt.Println(watcher.ID, "loses", target.ID)
+}
+
+func (l *listener) OnMove(watcher *aoi.Entity, target *aoi.Entity) {
+	fmt.Println(watcher.ID, "sees", target.ID, "move to", target.X, target.Y)
+}
+
+// agent is only named, its methods are not called
+type agent struct {
+	gate.Agent
+	name string
+}
+
+// encodes once like gate.Gate
+type broadcaster struct{}
+
+func (b *broadcaster) Broadcast(agents []gate.Agent, recv *cstruct.RecvMsg, mainCmdID uint16, subCmdID uint16, msg interface{}) error {
+	for _, a := range agents {
+		fmt.Printf("%v <- %v\n", a.(*agent).name, msg)
+	}
+	return nil
+}
+
+func ExampleNewGrid() {
+	space := aoi.NewGrid(0, 0, 100, 100, 10)
+	space.Listener = new(listener)
+
+	space.Enter(&aoi.Entity{ID: "a", X: 5, Y: 5, Agent: &agent{name: "a"}})
+	space.Enter(&aoi.Entity{ID: "b", X: 15, Y: 5, Agent: &agent{name: "b"}})
+	space.Enter(&aoi.Entity{ID: "c", X: 55, Y: 55, Agent: &agent{name: "c"}})
+
+	// same cell
+	space.Move("b", 16, 6)
+
+	// across cells
+	space.Move("b", 45, 50)
+
+	b := space.Get("b")
+	space.Broadcast(new(broadcaster), b, 2, 1, "b moves")
+
+	space.Leave("c")
+	fmt.Println(space.NumEntity())
+
+	// Output:
+	// a sees b
+	// b sees a
+	// a sees b move to 16 6
+	// c sees b
+	// b sees c
+	// a loses b
+	// b loses a
+	// c <- b moves
+	// b loses c
+	// c loses b
+	// 2
+}
+
+func ExampleNewCrossList() {
+	space := aoi.NewCrossList(10)
+	space.Listener = new(listener)
+
+	space.Enter(&aoi.Entity{ID: "a", X: 0, Y: 0})
+	space.Enter(&aoi.Entity{ID: "b", X: 100, Y: 0})
+	space.Enter(&aoi.Entity{ID: "c", X: 5, Y: 50})
+
+	space.Move("b", 8, 5)
+	space.Move("c", 5, 12)
+
+	space.Neighbors(space.Get("b"), func(o *aoi.Entity) {
+		fmt.Println("b near", o.ID)
+	})
+
+	// Output:
+	// a sees b
+	// b sees a
+	// b sees c
+	// c sees b
+	// b near c
+	// b near a
+}
@@ -0,0 +1,100 @@
+package aoi
+
+import (
+	"fmt"
+)
+
+type cell struct {
+	x, y     int
+	entities []*Entity
+}
+
+type grid struct {
+	minX, minY float32
+	cellSize   float32
+	cols, rows int
+	cells      []cell
+}
+
+// NewGrid creates a nine-grid space, an entity sees the entities in its
+// cell and the 8 cells around. The positions out of the bounds are clamped
+// to the border cells.
+func NewGrid(minX, minY, maxX, maxY, cellSize float32) *Space {
+	if cellSize <= 0 || maxX <= minX || maxY <= minY {
+		panic(fmt.Sprintf("invalid grid %v,%v %v,%v cell size %v", minX, minY, maxX, maxY, cellSize))
+	}
+
+	g := new(grid)
+	g.minX = minX
+	g.minY = minY
+	g.cellSize = cellSize
+	g.cols = int((maxX-minX)/cellSize) + 1
+	g.rows = int((maxY-minY)/cellSize) + 1
+	g.cells = make([]cell, g.cols*g.rows)
+	for i := range g.cells {
+		g.cells[i].x = i % g.cols
+		g.cells[i].y = i / g.cols
+	}
+	return newSpace(g)
+}
+
+func (g *grid) cellOf(x, y float32) *cell {
+	cx := int((x - g.minX) / g.cellSize)
+	cy := int((y - g.minY) / g.cellSize)
+	if cx < 0 {
+		cx = 0
+	} else if cx >= g.cols {
+		cx = g.cols - 1
+	}
+	if cy < 0 {
+		cy = 0
+	} else if cy >= g.rows {
+		cy = g.rows - 1
+	}
+	return &g.cells[cy*g.cols+cx]
+}
+
+func (g *grid) add(e *Entity) {
+	c := g.cellOf(e.X, e.Y)
+	e.cell = c
+	e.index = len(c.entities)
+	c.entities = append(c.entities, e)
+}
+
+func (g *grid) remove(e *Entity) {
+	c := e.cell
+	last := c.entities[len(c.entities)-1]
+	c.entities[e.index] = last
+	last.index = e.index
+	c.entities[len(c.entities)-1] = nil
+	c.entities = c.entities[:len(c.entities)-1]
+	e.cell = nil
+}
+
+func (g *grid) move(e *Entity, x, y float32) bool {
+	e.X, e.Y = x, y
+	if g.cellOf(x, y) == e.cell {
+		return false
+	}
+	g.remove(e)
+	g.add(e)
+	return true
+}
+
+func (g *grid) near(e *Entity, f func(o *Entity)) {
+	for y := e.cell.y - 1; y <= e.cell.y+1; y++ {
+		if y < 0 || y >= g.rows {
+			continue
+		}
+		for x := e.cell.x - 1; x <= e.cell.x+1; x++ {
+			if x < 0 || x >= g.cols {
+				continue
+			}
+			for _, o := range g.cells[y*g.cols+x].entities {
+				if o != e {
+					f(o)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Command capreplay replays the capture files of a gate, which are written
+// with Gate.CaptureDir.
+//
+// The In frames of every agent are sent to a running server in a connection
+// of its own:
+//
+//	capreplay -addr 127.0.0.1:3563 capture/*.cap
+//
+// Without -addr the records are printed, the frames are dumped with the
+// schema if -schema is set:
+//
+//	capreplay -schema schema.json capture/*.cap
+//
+// -speed 1 keeps the relative timing of the records, 0 replays them as fast
+// as possible.
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/CreFire/leaf/network/capture"
+	"github.com/CreFire/leaf/network/cstruct"
+	"github.com/CreFire/leaf/util/cstruct-go/schema"
+)
+
+func main() {
+	addr := flag.String("addr", "", "server address, print the records if empty")
+	lenMsgLen := flag.Int("len", 2, "size of the length prefix")
+	maxMsgLen := flag.Int("maxlen", 4096, "max frame size")
+	bigEndian := flag.Bool("be", false, "big endian length and id")
+	speed := flag.Float64("speed", 1, "replay speed, 0 as fast as possible")
+	schemaFile := flag.String("schema", "", "schema to dump the frames")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: capreplay [flags] file.cap...")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	r, err := capture.OpenFiles(flag.Args()...)
+	if err != nil {
+		fail(err)
+	}
+	defer r.Close()
+
+	if *addr != "" {
+		c := &capture.Client{
+			Addr:         *addr,
+			LenMsgLen:    *lenMsgLen,
+			MaxMsgLen:    int32(*maxMsgLen),
+			LittleEndian: !*bigEndian,
+		}
+		if err := c.Replay(r, *speed); err != nil {
+			fail(err)
+		}
+		return
+	}
+
+	var s *schema.Schema
+	if *schemaFile != "" {
+		if s, err = schema.Read(*schemaFile); err != nil {
+			fail(err)
+		}
+	}
+	var first int64
+	err = capture.Replay(r, *speed, func(rec *capture.Record) error {
+		if first == 0 {
+			first = rec.Time
+		}
+		fmt.Printf("%v agent %v %v %v bytes\n", time.Duration(rec.Time-first), rec.ID, rec.Dir, len(rec.Data))
+		switch {
+		case rec.Dir == capture.Open:
+			fmt.Println(string(rec.Data))
+		case rec.Dir == capture.Close:
+		case rec.Dir == capture.Negotiate && len(rec.Data) > 0:
+			fmt.Printf("codec %q magic %#x\n", rec.Data[1:], rec.Data[0])
+		case s != nil:
+			fmt.Println(cstruct.DumpSchema(s, rec.Data, 0, !*bigEndian))
+		default:
+			fmt.Println(hex.EncodeToString(rec.Data))
+		}
+		return nil
+	})
+	if err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "capreplay: %v\n", err)
+	os.Exit(1)
+}
@@ -0,0 +1,55 @@
+// Command cstructcompat compares the schema of the cstruct messages with a
+// baseline and reports the breaking changes, e.g. reordered, removed or
+// inserted fields, type changes and fields added without a ver tag.
+//
+// The schemas are written by the server with Processor.Schema, the baseline
+// is the one of the released clients:
+//
+//	cstructcompat baseline.json schema.json
+//
+// It exits with 1 if there are breaking changes, -v prints the compatible
+// changes too.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/CreFire/leaf/util/cstruct-go/schema"
+)
+
+func main() {
+	verbose := flag.Bool("v", false, "print the compatible changes too")
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: cstructcompat [-v] baseline.json schema.json")
+		os.Exit(2)
+	}
+
+	base, err := schema.Read(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cstructcompat: %v\n", err)
+		os.Exit(2)
+	}
+	s, err := schema.Read(flag.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cstructcompat: %v\n", err)
+		os.Exit(2)
+	}
+
+	breaking := 0
+	for _, c := range schema.Compare(base, s) {
+		if c.Breaking {
+			breaking++
+		} else if !*verbose {
+			continue
+		}
+		fmt.Println(c)
+	}
+	if breaking > 0 {
+		fmt.Fprintf(os.Stderr, "cstructcompat: %v breaking changes\n", breaking)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,60 @@
+// Command cstructdump dumps a cstruct frame as JSON with the schema exported
+// by the server, e.g. a bad packet reported by a client:
+//
+//	cstructdump -schema schema.json "1200 00 0100 0200 ..."
+//	cstructdump -schema schema.json -len 0 -f body.bin
+//
+// The frame is the hex of the arguments or the stdin, or the bytes of -f.
+// The fields decoded before an error are dumped with the error offset.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/CreFire/leaf/network/cstruct"
+	"github.com/CreFire/leaf/util/cstruct-go/schema"
+)
+
+func main() {
+	schemaFile := flag.String("schema", "schema.json", "schema written by the server")
+	lenMsgLen := flag.Int("len", 2, "size of the length prefix, 0 if none")
+	bigEndian := flag.Bool("be", false, "big endian length and id")
+	file := flag.String("f", "", "binary frame file")
+	flag.Parse()
+
+	s, err := schema.Read(*schemaFile)
+	if err != nil {
+		fail(err)
+	}
+
+	var data []byte
+	switch {
+	case *file != "":
+		data, err = os.ReadFile(*file)
+	case flag.NArg() > 0:
+		data, err = cstruct.ParseHex(strings.Join(flag.Args(), " "))
+	default:
+		var b []byte
+		if b, err = io.ReadAll(os.Stdin); err == nil {
+			data, err = cstruct.ParseHex(string(b))
+		}
+	}
+	if err != nil {
+		fail(err)
+	}
+
+	d := cstruct.DumpSchema(s, data, *lenMsgLen, !*bigEndian)
+	fmt.Println(d)
+	if d.Error != "" {
+		os.Exit(1)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "cstructdump: %v\n", err)
+	os.Exit(2)
+}
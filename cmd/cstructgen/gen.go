@@ -0,0 +1,665 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type kind int
+
+const (
+	kindBasic kind = iota
+	kindStruct
+	kindPtr
+	kindSlice
+	kindArray
+	kindMap
+)
+
+type typ struct {
+	kind kind
+	// the type in the source, for make, new and the conversions
+	src string
+	// the underlying type of a basic kind, e.g. int32
+	basic string
+	elem  *typ
+	key   *typ
+}
+
+var basics = map[string]string{
+	"bool": "bool", "string": "string",
+	"int": "int", "int8": "int8", "int16": "int16", "int32": "int32", "int64": "int64",
+	"uint": "uint", "uint8": "uint8", "uint16": "uint16", "uint32": "uint32", "uint64": "uint64",
+	"float32": "float32", "float64": "float64",
+	"byte": "uint8", "rune": "int32",
+}
+
+// the encoding of a field, the expressions of its LenMode and varint
+type encoding struct {
+	len    string
+	varint string
+}
+
+type field struct {
+	name string
+	typ  *typ
+	enc  encoding
+	// the ver tag, -1 for none
+	ver   int
+	isVer bool
+}
+
+type structDef struct {
+	name   string
+	fields []*field
+	hasVer bool
+	// a field has a ver tag
+	versioned bool
+}
+
+type generator struct {
+	pkg       string
+	specs     map[string]ast.Expr
+	structs   map[string]*structDef
+	order     []*structDef
+	resolving map[string]bool
+
+	b   bytes.Buffer
+	tmp int
+}
+
+func generate(files []string, names []string) ([]byte, error) {
+	g := &generator{
+		specs:     make(map[string]ast.Expr),
+		structs:   make(map[string]*structDef),
+		resolving: make(map[string]bool),
+	}
+
+	fset := token.NewFileSet()
+	var all []string
+	for _, name := range files {
+		f, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		if g.pkg != "" && g.pkg != f.Name.Name {
+			return nil, fmt.Errorf("%v: package %v, expected %v", name, f.Name.Name, g.pkg)
+		}
+		g.pkg = f.Name.Name
+		for _, decl := range f.Decls {
+			d, ok := decl.(*ast.GenDecl)
+			if !ok || d.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range d.Specs {
+				ts := spec.(*ast.TypeSpec)
+				if ts.TypeParams != nil {
+					continue
+				}
+				g.specs[ts.Name.Name] = ts.Type
+				if _, ok := ts.Type.(*ast.StructType); ok {
+					all = append(all, ts.Name.Name)
+				}
+			}
+		}
+	}
+
+	if len(names) == 0 {
+		names = all
+	}
+	for _, name := range names {
+		if _, ok := g.specs[name].(*ast.StructType); !ok {
+			return nil, fmt.Errorf("struct %v not found", name)
+		}
+		if err := g.addStruct(name); err != nil {
+			return nil, err
+		}
+	}
+	if len(g.order) == 0 {
+		return nil, errors.New("no struct")
+	}
+	return g.source()
+}
+
+func (g *generator) addStruct(name string) error {
+	if _, ok := g.structs[name]; ok {
+		return nil
+	}
+	s := &structDef{name: name}
+	g.structs[name] = s
+	g.order = append(g.order, s)
+
+	for _, f := range g.specs[name].(*ast.StructType).Fields.List {
+		var tag reflect.StructTag
+		if f.Tag != nil {
+			v, _ := strconv.Unquote(f.Tag.Value)
+			tag = reflect.StructTag(v)
+		}
+		t, err := g.resolve(f.Type)
+		if err != nil {
+			return fmt.Errorf("%v: %v", name, err)
+		}
+
+		fieldNames := make([]string, len(f.Names))
+		for i, n := range f.Names {
+			fieldNames[i] = n.Name
+		}
+		if len(f.Names) == 0 { // embedded
+			e := f.Type
+			if star, ok := e.(*ast.StarExpr); ok {
+				e = star.X
+			}
+			id, ok := e.(*ast.Ident)
+			if !ok {
+				return fmt.Errorf("%v: unsupported embedded field %v", name, types.ExprString(f.Type))
+			}
+			fieldNames = []string{id.Name}
+		}
+
+		for _, fieldName := range fieldNames {
+			if fieldName == "_" {
+				return fmt.Errorf("%v: blank field", name)
+			}
+			fd := &field{name: fieldName, typ: t, ver: -1}
+			if fd.enc, err = parseEncoding(tag); err != nil {
+				return fmt.Errorf("%v.%v: %v", name, fieldName, err)
+			}
+			if v, ok := tag.Lookup("ver"); ok {
+				if fd.ver, err = strconv.Atoi(v); err != nil || fd.ver < 0 {
+					return fmt.Errorf("%v.%v: invalid ver %v", name, fieldName, v)
+				}
+				if !s.hasVer {
+					return fmt.Errorf("%v.%v: ver tag before the Ver field", name, fieldName)
+				}
+				s.versioned = true
+			} else if fieldName == "Ver" && !s.hasVer {
+				switch t.basic {
+				case "int8", "int16", "int32", "uint8", "uint16", "uint32":
+				default:
+					return fmt.Errorf("%v.Ver: invalid type %v", name, t.src)
+				}
+				fd.isVer = true
+				s.hasVer = true
+			}
+			s.fields = append(s.fields, fd)
+		}
+	}
+	return nil
+}
+
+func parseEncoding(tag reflect.StructTag) (encoding, error) {
+	e := encoding{len: "cstruct.OptionLenMode", varint: "cstruct.OptionVarint"}
+	opts, ok := tag.Lookup("cstruct")
+	if !ok {
+		return e, nil
+	}
+	for _, opt := range strings.Split(opts, ",") {
+		switch strings.TrimSpace(opt) {
+		case "len16":
+			e.len = "cstruct.Len16"
+		case "len32":
+			e.len = "cstruct.Len32"
+		case "varlen":
+			e.len = "cstruct.LenVarint"
+		case "varint":
+			e.varint = "true"
+		case "fixed":
+			e.varint = "false"
+		case "":
+		default:
+			return e, fmt.Errorf("unknown cstruct tag option %v", opt)
+		}
+	}
+	return e, nil
+}
+
+func (g *generator) resolve(e ast.Expr) (*typ, error) {
+	switch e := e.(type) {
+	case *ast.Ident:
+		if b, ok := basics[e.Name]; ok {
+			return &typ{kind: kindBasic, src: e.Name, basic: b}, nil
+		}
+		spec, ok := g.specs[e.Name]
+		if !ok {
+			return nil, fmt.Errorf("type %v not found", e.Name)
+		}
+		if _, ok := spec.(*ast.StructType); ok {
+			if err := g.addStruct(e.Name); err != nil {
+				return nil, err
+			}
+			return &typ{kind: kindStruct, src: e.Name}, nil
+		}
+		// a named type, e.g. type ItemID uint32
+		if g.resolving[e.Name] {
+			return nil, fmt.Errorf("recursive type %v", e.Name)
+		}
+		g.resolving[e.Name] = true
+		defer delete(g.resolving, e.Name)
+		t, err := g.resolve(spec)
+		if err != nil {
+			return nil, err
+		}
+		named := *t
+		named.src = e.Name
+		return &named, nil
+	case *ast.StarExpr:
+		elem, err := g.resolve(e.X)
+		if err != nil {
+			return nil, err
+		}
+		return &typ{kind: kindPtr, src: "*" + elem.src, elem: elem}, nil
+	case *ast.ArrayType:
+		elem, err := g.resolve(e.Elt)
+		if err != nil {
+			return nil, err
+		}
+		if e.Len == nil {
+			return &typ{kind: kindSlice, src: "[]" + elem.src, elem: elem}, nil
+		}
+		return &typ{kind: kindArray, src: "[" + types.ExprString(e.Len) + "]" + elem.src, elem: elem}, nil
+	case *ast.MapType:
+		key, err := g.resolve(e.Key)
+		if err != nil {
+			return nil, err
+		}
+		if key.kind != kindBasic || key.basic == "bool" {
+			return nil, fmt.Errorf("unsupported map key %v", key.src)
+		}
+		elem, err := g.resolve(e.Value)
+		if err != nil {
+			return nil, err
+		}
+		return &typ{kind: kindMap, src: "map[" + key.src + "]" + elem.src, key: key, elem: elem}, nil
+	case *ast.ParenExpr:
+		return g.resolve(e.X)
+	}
+	return nil, fmt.Errorf("unsupported type %v", types.ExprString(e))
+}
+
+func (g *generator) p(format string, args ...interface{}) {
+	fmt.Fprintf(&g.b, format, args...)
+	g.b.WriteByte('\n')
+}
+
+func (g *generator) v(prefix string) string {
+	g.tmp++
+	return prefix + strconv.Itoa(g.tmp)
+}
+
+func (g *generator) source() ([]byte, error) {
+	var body bytes.Buffer
+	for _, s := range g.order {
+		g.b.Reset()
+		g.tmp = 0
+		g.sizeMethod(s)
+		g.marshalMethod(s)
+		g.unmarshalMethod(s)
+		body.Write(g.b.Bytes())
+	}
+
+	g.b.Reset()
+	g.p("// Code generated by cstructgen. DO NOT EDIT.")
+	g.p("")
+	g.p("package %v", g.pkg)
+	g.p("")
+	g.p(`import "github.com/CreFire/leaf/util/cstruct-go"`)
+	g.p("")
+	g.b.Write(body.Bytes())
+
+	src, err := format.Source(g.b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("%v\n%s", err, g.b.Bytes())
+	}
+	return src, nil
+}
+
+func (g *generator) sizeMethod(s *structDef) {
+	g.p("func (m *%v) SizeCStruct() int {", s.name)
+	g.p("n := 0")
+	for _, f := range s.fields {
+		g.size("m."+f.name, f.typ, f.enc, true)
+	}
+	g.p("return n")
+	g.p("}")
+	g.p("")
+}
+
+func (g *generator) marshalMethod(s *structDef) {
+	g.p("func (m *%v) MarshalCStruct(o *cstruct.Buffer) error {", s.name)
+	if s.versioned {
+		g.p("ver := -2")
+	}
+	for _, f := range s.fields {
+		if f.ver >= 0 {
+			g.p("if %v > ver {", f.ver)
+			g.p(`return &cstruct.FieldVerError{Field: %q, Ver: %v, StructVer: ver}`, f.name, f.ver)
+			g.p("}")
+		}
+		g.enc("m."+f.name, f.typ, f.enc, true)
+		if f.isVer && s.versioned {
+			g.p("ver = int(m.%v)", f.name)
+		}
+	}
+	g.p("return nil")
+	g.p("}")
+	g.p("")
+}
+
+func (g *generator) unmarshalMethod(s *structDef) {
+	g.p("func (m *%v) UnmarshalCStruct(o *cstruct.Buffer) error {", s.name)
+	if s.versioned {
+		g.p("ver := -2")
+	}
+	for _, f := range s.fields {
+		// the fields newer than the data are left
+		if f.ver >= 0 {
+			g.p("if %v <= ver {", f.ver)
+		}
+		g.dec("m."+f.name, f.typ, f.enc, true)
+		if f.ver >= 0 {
+			g.p("}")
+		}
+		if f.isVer && s.versioned {
+			g.p("ver = int(m.%v)", f.name)
+		}
+	}
+	g.p("return nil")
+	g.p("}")
+	g.p("")
+}
+
+func intSize(basic string) string {
+	switch basic {
+	case "int8", "uint8":
+		return "1"
+	case "int16", "uint16":
+		return "2"
+	case "int32", "uint32":
+		return "4"
+	case "int64", "uint64":
+		return "8"
+	}
+	return "cstruct.OptionIntSize"
+}
+
+func isBytes(t *typ) bool {
+	return t.kind == kindSlice && t.elem.kind == kindBasic && (t.elem.src == "byte" || t.elem.src == "uint8")
+}
+
+// a []*struct field honors OptionSliceIgnoreNil
+func isStructPtrs(t *typ, top bool) bool {
+	return top && t.kind == kindSlice && t.elem.kind == kindPtr && t.elem.elem.kind == kindStruct
+}
+
+func (g *generator) check(call string) {
+	g.p("if err := %v; err != nil {", call)
+	g.p("return err")
+	g.p("}")
+}
+
+func (g *generator) errCheck() {
+	g.p("if err != nil {")
+	g.p("return err")
+	g.p("}")
+}
+
+// enc writes x, which is addressable
+func (g *generator) enc(x string, t *typ, e encoding, top bool) {
+	switch t.kind {
+	case kindBasic:
+		switch b := t.basic; b {
+		case "bool":
+			g.p("o.WriteBool(bool(%v))", x)
+		case "float32", "float64":
+			g.p("o.Write%v(%v(%v))", strings.Title(b), b, x)
+		case "string":
+			g.check(fmt.Sprintf("o.WriteString(string(%v), %v)", x, e.len))
+		case "int", "int8", "int16", "int32", "int64":
+			g.check(fmt.Sprintf("o.WriteInt(int64(%v), %v, %v)", x, intSize(b), e.varint))
+		default:
+			g.check(fmt.Sprintf("o.WriteUint(uint64(%v), %v, %v)", x, intSize(b), e.varint))
+		}
+	case kindStruct:
+		g.check(x + ".MarshalCStruct(o)")
+	case kindPtr:
+		g.p("if %v == nil {", x)
+		g.p("o.WriteUint8(0)")
+		g.p("} else {")
+		g.p("o.WriteUint8(1)")
+		if t.elem.kind == kindStruct {
+			g.check(x + ".MarshalCStruct(o)")
+		} else {
+			g.enc("(*"+x+")", t.elem, e, false)
+		}
+		g.p("}")
+	case kindSlice:
+		if isBytes(t) {
+			g.check(fmt.Sprintf("o.WriteBytes(%v, %v)", x, e.len))
+			return
+		}
+		if isStructPtrs(t, top) {
+			c, v := g.v("c"), g.v("v")
+			g.p("if cstruct.IgnoreNil(%v, %v) {", e.len, e.varint)
+			g.p("%v := 0", c)
+			g.p("for _, %v := range %v {", v, x)
+			g.p("if %v != nil {", v)
+			g.p("%v++", c)
+			g.p("}")
+			g.p("}")
+			g.check(fmt.Sprintf("o.WriteLen(%v, %v)", c, e.len))
+			g.p("for _, %v := range %v {", v, x)
+			g.p("if %v != nil {", v)
+			g.check(v + ".MarshalCStruct(o)")
+			g.p("}")
+			g.p("}")
+			g.p("} else {")
+			g.enc(x, t, e, false)
+			g.p("}")
+			return
+		}
+		g.check(fmt.Sprintf("o.WriteLen(len(%v), %v)", x, e.len))
+		i := g.v("i")
+		g.p("for %v := range %v {", i, x)
+		g.enc(x+"["+i+"]", t.elem, e, false)
+		g.p("}")
+	case kindArray:
+		i := g.v("i")
+		g.p("for %v := range %v {", i, x)
+		g.enc(x+"["+i+"]", t.elem, e, false)
+		g.p("}")
+	case kindMap:
+		g.check(fmt.Sprintf("o.WriteLen(len(%v), %v)", x, e.len))
+		k, v, buf := g.v("k"), g.v("v"), g.v("buf")
+		// the keys of the small maps on the stack
+		g.p("var %v [8]%v", buf, t.key.src)
+		g.p("for _, %v := range cstruct.AppendSortedKeys(%v[:0], %v) {", k, buf, x)
+		g.enc(k, t.key, e, false)
+		g.p("%v := %v[%v]", v, x, k)
+		g.enc(v, t.elem, e, false)
+		g.p("}")
+	}
+}
+
+// dec reads into x, which is addressable
+func (g *generator) dec(x string, t *typ, e encoding, top bool) {
+	switch t.kind {
+	case kindBasic:
+		v := g.v("v")
+		switch b := t.basic; b {
+		case "bool":
+			g.p("%v, err := o.ReadBool()", v)
+		case "float32", "float64":
+			g.p("%v, err := o.Read%v()", v, strings.Title(b))
+		case "string":
+			g.p("%v, err := o.ReadString(%v)", v, e.len)
+		case "int", "int8", "int16", "int32", "int64":
+			g.p("%v, err := o.ReadInt(%v, %v)", v, intSize(b), e.varint)
+		default:
+			g.p("%v, err := o.ReadUint(%v, %v)", v, intSize(b), e.varint)
+		}
+		g.errCheck()
+		g.p("%v = %v(%v)", x, t.src, v)
+	case kindStruct:
+		g.check(x + ".UnmarshalCStruct(o)")
+	case kindPtr:
+		f := g.v("f")
+		g.p("%v, err := o.ReadUint8()", f)
+		g.errCheck()
+		g.p("if %v == 0 {", f)
+		g.p("%v = nil", x)
+		g.p("} else {")
+		g.p("%v = new(%v)", x, t.elem.src)
+		if t.elem.kind == kindStruct {
+			g.check(x + ".UnmarshalCStruct(o)")
+		} else {
+			g.dec("(*"+x+")", t.elem, e, false)
+		}
+		g.p("}")
+	case kindSlice:
+		if isBytes(t) {
+			v := g.v("v")
+			g.p("%v, err := o.ReadBytes(%v)", v, e.len)
+			g.errCheck()
+			g.p("%v = %v(%v)", x, t.src, v)
+			return
+		}
+		if isStructPtrs(t, top) {
+			n, i := g.v("n"), g.v("i")
+			g.p("if cstruct.IgnoreNil(%v, %v) {", e.len, e.varint)
+			g.p("%v, err := o.ReadLen(%v)", n, e.len)
+			g.errCheck()
+			g.p("%v = make(%v, %v)", x, t.src, n)
+			g.p("for %v := range %v {", i, x)
+			g.p("%v[%v] = new(%v)", x, i, t.elem.elem.src)
+			g.check(x + "[" + i + "].UnmarshalCStruct(o)")
+			g.p("}")
+			g.p("} else {")
+			g.dec(x, t, e, false)
+			g.p("}")
+			return
+		}
+		n, i := g.v("n"), g.v("i")
+		g.p("%v, err := o.ReadLen(%v)", n, e.len)
+		g.errCheck()
+		g.p("%v = make(%v, %v)", x, t.src, n)
+		g.p("for %v := range %v {", i, x)
+		g.dec(x+"["+i+"]", t.elem, e, false)
+		g.p("}")
+	case kindArray:
+		i := g.v("i")
+		g.p("for %v := range %v {", i, x)
+		g.dec(x+"["+i+"]", t.elem, e, false)
+		g.p("}")
+	case kindMap:
+		n, i, k, v := g.v("n"), g.v("i"), g.v("k"), g.v("v")
+		g.p("%v, err := o.ReadLen(%v)", n, e.len)
+		g.errCheck()
+		g.p("%v = make(%v, %v)", x, t.src, n)
+		g.p("for %v := 0; %v < %v; %v++ {", i, i, n, i)
+		g.p("var %v %v", k, t.key.src)
+		g.dec(k, t.key, e, false)
+		g.p("var %v %v", v, t.elem.src)
+		g.dec(v, t.elem, e, false)
+		g.p("%v[%v] = %v", x, k, v)
+		g.p("}")
+	}
+}
+
+// fixedSize is the size of a basic type not depending on the encoding, 0
+// if it does
+func fixedSize(t *typ) int {
+	if t.kind != kindBasic {
+		return 0
+	}
+	switch t.basic {
+	case "bool", "int8", "uint8":
+		return 1
+	case "float32":
+		return 4
+	case "float64":
+		return 8
+	}
+	return 0
+}
+
+// size adds the size of x to n
+func (g *generator) size(x string, t *typ, e encoding, top bool) {
+	if n := fixedSize(t); n == 1 {
+		g.p("n++")
+		return
+	} else if n > 0 {
+		g.p("n += %v", n)
+		return
+	}
+	switch t.kind {
+	case kindBasic:
+		switch b := t.basic; b {
+		case "string":
+			g.p("n += cstruct.LenSize(len(%v), %v) + len(%v)", x, e.len, x)
+		case "int", "int16", "int32", "int64":
+			g.p("n += cstruct.SizeInt(int64(%v), %v, %v)", x, intSize(b), e.varint)
+		default:
+			g.p("n += cstruct.SizeUint(uint64(%v), %v, %v)", x, intSize(b), e.varint)
+		}
+	case kindStruct:
+		g.p("n += %v.SizeCStruct()", x)
+	case kindPtr:
+		g.p("n++")
+		g.p("if %v != nil {", x)
+		if t.elem.kind == kindStruct {
+			g.p("n += %v.SizeCStruct()", x)
+		} else {
+			g.size("(*"+x+")", t.elem, e, false)
+		}
+		g.p("}")
+	case kindSlice:
+		if isStructPtrs(t, top) {
+			v := g.v("v")
+			g.p("if cstruct.IgnoreNil(%v, %v) {", e.len, e.varint)
+			g.p("n += 2")
+			g.p("for _, %v := range %v {", v, x)
+			g.p("if %v != nil {", v)
+			g.p("n += %v.SizeCStruct()", v)
+			g.p("}")
+			g.p("}")
+			g.p("} else {")
+			g.size(x, t, e, false)
+			g.p("}")
+			return
+		}
+		g.p("n += cstruct.LenSize(len(%v), %v)", x, e.len)
+		g.sizeElems(x, t.elem, e)
+	case kindArray:
+		g.sizeElems(x, t.elem, e)
+	case kindMap:
+		k, v := g.v("k"), g.v("v")
+		g.p("n += cstruct.LenSize(len(%v), %v)", x, e.len)
+		g.p("for %v, %v := range %v {", k, v, x)
+		g.size(k, t.key, e, false)
+		g.size(v, t.elem, e, false)
+		g.p("}")
+	}
+}
+
+func (g *generator) sizeElems(x string, elem *typ, e encoding) {
+	if n := fixedSize(elem); n == 1 {
+		g.p("n += len(%v)", x)
+		return
+	} else if n > 0 {
+		g.p("n += %v * len(%v)", n, x)
+		return
+	}
+	i := g.v("i")
+	g.p("for %v := range %v {", i, x)
+	g.size(x+"["+i+"]", elem, e, false)
+	g.p("}")
+}
@@ -0,0 +1,52 @@
+// Command cstructgen generates the cstruct.Marshaler methods of Go structs,
+// which encode in the layout of the reflection of util/cstruct-go without
+// reflection.
+//
+// Usage:
+//
+//	cstructgen [-type Move,Attack] [-o msg_cstruct.go] msg.go ...
+//
+// The files must be of the same package. Without -type the methods of every
+// struct in the files are generated, the structs used by the fields of the
+// generated structs are generated too. The Ver field and the ver and
+// cstruct tags are respected, the options of the encoding (OptionIntSize,
+// OptionLenMode, OptionVarint, OptionSliceIgnoreNil) are read at run time.
+// The methods must be generated again after a struct is changed.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func main() {
+	types := flag.String("type", "", "comma separated struct names, empty for all")
+	out := flag.String("o", "", "output file, default the first file with the suffix _cstruct.go")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var names []string
+	if *types != "" {
+		names = strings.Split(*types, ",")
+	}
+	src, err := generate(flag.Args(), names)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cstructgen: %v\n", err)
+		os.Exit(1)
+	}
+
+	dst := *out
+	if dst == "" {
+		dst = strings.TrimSuffix(flag.Arg(0), ".go") + "_cstruct.go"
+	}
+	if err := os.WriteFile(dst, src, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "cstructgen: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,66 @@
+// Command cstructschema generates the C# and TypeScript encoders and
+// decoders of cstruct messages from a schema exported by the server.
+//
+// The schema is written by the server, e.g. at startup:
+//
+//	s, err := processor.Schema()
+//	...
+//	err = s.Write("schema.json")
+//
+// Usage:
+//
+//	cstructschema -lang cs -namespace Game.Msg -o Messages.cs schema.json
+//	cstructschema -lang ts -o messages.ts schema.json
+//
+// The generated code is byte compatible with cstruct-go in the options of
+// the export, it must be generated again after the messages are changed.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/CreFire/leaf/util/cstruct-go/schema"
+)
+
+func main() {
+	lang := flag.String("lang", "ts", "output language, cs or ts")
+	namespace := flag.String("namespace", "", "namespace of the C# code")
+	out := flag.String("o", "", "output file, default stdout")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	s, err := schema.Read(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cstructschema: %v\n", err)
+		os.Exit(1)
+	}
+
+	var src []byte
+	switch *lang {
+	case "cs":
+		src, err = schema.CSharp(s, *namespace)
+	case "ts":
+		src, err = schema.TypeScript(s)
+	default:
+		err = fmt.Errorf("unknown language %v", *lang)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cstructschema: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *out == "" {
+		os.Stdout.Write(src)
+		return
+	}
+	if err := os.WriteFile(*out, src, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "cstructschema: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"go/token"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/CreFire/leaf/recordfile"
+)
+
+type generator struct {
+	pkg        string
+	snapshot   bool
+	headerRows int
+	nameRow    int
+	typeRow    int
+	commentRow int
+	sheet      string
+}
+
+type column struct {
+	name    string
+	field   string
+	typ     string
+	tag     string
+	comment string
+}
+
+type index struct {
+	name   string
+	fields []*column
+	multi  bool
+	sorted bool
+}
+
+// check checks that the name, type and comment rows are header rows
+func (g *generator) check() error {
+	if g.headerRows <= 0 {
+		return fmt.Errorf("header %v: must be positive", g.headerRows)
+	}
+	if g.nameRow < 0 || g.nameRow >= g.headerRows {
+		return fmt.Errorf("namerow %v: not a header row", g.nameRow)
+	}
+	if g.typeRow < 0 || g.typeRow >= g.headerRows {
+		return fmt.Errorf("typerow %v: not a header row", g.typeRow)
+	}
+	if g.typeRow == g.nameRow {
+		return fmt.Errorf("typerow %v: same as namerow", g.typeRow)
+	}
+	if g.commentRow < -1 || g.commentRow >= g.headerRows {
+		return fmt.Errorf("commentrow %v: not a header row", g.commentRow)
+	}
+	return nil
+}
+
+func (g *generator) generate(path string) ([]byte, error) {
+	if err := g.check(); err != nil {
+		return nil, err
+	}
+	cells, err := recordfile.ReadCells(path, g.sheet)
+	if err != nil {
+		return nil, err
+	}
+	if len(cells) < g.headerRows {
+		return nil, errors.New("header not found")
+	}
+	row := func(r, i int) string {
+		if r < 0 || r >= len(cells) || i >= len(cells[r]) {
+			return ""
+		}
+		return strings.TrimSpace(cells[r][i])
+	}
+
+	var cols []*column
+	fields := make(map[string]bool)
+	for i := range cells[g.nameRow] {
+		name := row(g.nameRow, i)
+		if name == "" || strings.HasPrefix(name, string(recordfile.Comment)) {
+			continue
+		}
+		c := &column{name: name, field: goName(name), comment: row(g.commentRow, i)}
+		if fields[c.field] {
+			return nil, fmt.Errorf("duplicate field %v", c.field)
+		}
+		fields[c.field] = true
+
+		typ := strings.Fields(row(g.typeRow, i))
+		if len(typ) == 0 {
+			c.typ = "string"
+		} else {
+			c.typ = goType(typ[0])
+			c.tag = strings.Join(typ[1:], ",")
+		}
+		cols = append(cols, c)
+	}
+	if len(cols) == 0 {
+		return nil, errors.New("no column")
+	}
+
+	indexes, err := parseIndexes(cols)
+	if err != nil {
+		return nil, err
+	}
+
+	file := filepath.Base(path)
+	table := goName(strings.TrimSuffix(file, filepath.Ext(file)))
+	return g.source(file, table, cols, indexes)
+}
+
+// parseIndexes follows the index options of recordfile
+func parseIndexes(cols []*column) ([]*index, error) {
+	var indexes []*index
+	byName := make(map[string]*index)
+	for _, c := range cols {
+		var last *index
+		for _, opt := range strings.Split(c.tag, ",") {
+			name, value := opt, ""
+			if i := strings.Index(opt, "="); i >= 0 {
+				name, value = opt[:i], opt[i+1:]
+			}
+			switch name {
+			case "index":
+				if value == "" {
+					value = c.field
+				}
+				last = byName[value]
+				if last == nil {
+					last = &index{name: value}
+					byName[value] = last
+					indexes = append(indexes, last)
+				}
+				last.fields = append(last.fields, c)
+			case "multi", "sorted":
+				if last == nil {
+					return nil, fmt.Errorf("column %v: %v without index", c.name, opt)
+				}
+				if name == "multi" {
+					last.multi = true
+				} else {
+					last.sorted = true
+				}
+			}
+		}
+	}
+	return indexes, nil
+}
+
+func (g *generator) source(file string, table string, cols []*column, indexes []*index) ([]byte, error) {
+	var b bytes.Buffer
+	p := func(format string, args ...interface{}) {
+		fmt.Fprintf(&b, format, args...)
+		b.WriteByte('\n')
+	}
+
+	p("// Code generated by leafgen from %v. DO NOT EDIT.", file)
+	p("")
+	p("package %v", g.pkg)
+	p("")
+	p(`import "github.com/CreFire/leaf/recordfile"`)
+	p("")
+
+	// row
+	p("type %v struct {", table)
+	for _, c := range cols {
+		if c.comment != "" && !strings.EqualFold(c.comment, c.name) {
+			p("// %v", c.comment)
+		}
+		tags := []string{}
+		if !strings.EqualFold(c.name, c.field) {
+			tags = append(tags, fmt.Sprintf("col:%q", c.name))
+		}
+		if c.tag != "" {
+			tags = append(tags, fmt.Sprintf("rf:%q", c.tag))
+		}
+		if len(tags) > 0 {
+			p("%v %v `%v`", c.field, c.typ, strings.Join(tags, " "))
+		} else {
+			p("%v %v", c.field, c.typ)
+		}
+	}
+	p("}")
+	p("")
+
+	// table and loader
+	p("type %vTable struct {", table)
+	p("*recordfile.Table[%v]", table)
+	p("}")
+	p("")
+	p("// Load%v reads %v", table, file)
+	p("func Load%v(path string) (*%vTable, error) {", table, table)
+	p("t, err := recordfile.LoadWith[%v](path, setup%v)", table, table)
+	p("if err != nil {")
+	p("return nil, err")
+	p("}")
+	p("return &%vTable{t}, nil", table)
+	p("}")
+	p("")
+	if g.snapshot {
+		p("// Load%vSnapshot reads %v from the snapshot if it is up to date,", table, file)
+		p("// otherwise it reads path and rewrites the snapshot")
+		p("func Load%vSnapshot(path string, snapshot string) (*%vTable, error) {", table, table)
+		p("rf, err := recordfile.New(%v{})", table)
+		p("if err != nil {")
+		p("return nil, err")
+		p("}")
+		p("setup%v(rf)", table)
+		p("if err := rf.ReadWithSnapshot(path, snapshot); err != nil {")
+		p("return nil, err")
+		p("}")
+		p("t, err := recordfile.NewTable[%v](rf)", table)
+		p("if err != nil {")
+		p("return nil, err")
+		p("}")
+		p("return &%vTable{t}, nil", table)
+		p("}")
+		p("")
+	}
+	p("func setup%v(rf *recordfile.RecordFile) {", table)
+	p("rf.MapByName = true")
+	p("rf.HeaderRows = %v", g.headerRows)
+	p("rf.NameRow = %v", g.nameRow)
+	if g.sheet != "" {
+		p("rf.Sheet = %q", g.sheet)
+	}
+	p("}")
+	p("")
+
+	// index accessors
+	for _, i := range indexes {
+		var params, args []string
+		for _, c := range i.fields {
+			name := paramName(c.field)
+			params = append(params, name+" "+c.typ)
+			args = append(args, name)
+		}
+		method := "By" + goName(i.name)
+		if i.multi {
+			p("func (t *%vTable) %v(%v) []*%v {", table, method, strings.Join(params, ", "), table)
+			p("return t.LookupAll(%q, %v)", i.name, strings.Join(args, ", "))
+		} else {
+			p("func (t *%vTable) %v(%v) *%v {", table, method, strings.Join(params, ", "), table)
+			p("return t.Lookup(%q, %v)", i.name, strings.Join(args, ", "))
+		}
+		p("}")
+		p("")
+		if i.sorted && len(i.fields) == 1 {
+			typ := i.fields[0].typ
+			p("// Range%v visits the rows with from <= %v <= to in order", goName(i.name), i.fields[0].field)
+			p("func (t *%vTable) Range%v(from %v, to %v, f func(r *%v) bool) {", table, goName(i.name), typ, typ, table)
+			p("t.Range(%q, from, to, f)", i.name)
+			p("}")
+			p("")
+		}
+	}
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("%v\n%s", err, b.Bytes())
+	}
+	return src, nil
+}
+
+var initialisms = map[string]string{
+	"id":  "ID",
+	"hp":  "HP",
+	"mp":  "MP",
+	"ui":  "UI",
+	"url": "URL",
+	"exp": "EXP",
+}
+
+// goName turns item_type or itemType into ItemType
+func goName(s string) string {
+	var b strings.Builder
+	for _, w := range strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if v, ok := initialisms[strings.ToLower(w)]; ok {
+			b.WriteString(v)
+			continue
+		}
+		r := []rune(w)
+		r[0] = unicode.ToUpper(r[0])
+		b.WriteString(string(r))
+	}
+	name := b.String()
+	if name == "" || !unicode.IsLetter([]rune(name)[0]) {
+		name = "F" + name
+	}
+	return name
+}
+
+func paramName(field string) string {
+	if v, ok := initialisms[strings.ToLower(field)]; ok && v == field {
+		return strings.ToLower(field)
+	}
+	r := []rune(field)
+	r[0] = unicode.ToLower(r[0])
+	name := string(r)
+	if token.IsKeyword(name) {
+		name += "_"
+	}
+	return name
+}
+
+var typeAliases = map[string]string{
+	"float":  "float64",
+	"double": "float64",
+	"long":   "int64",
+	"text":   "string",
+}
+
+func goType(s string) string {
+	if t, ok := typeAliases[s]; ok {
+		return t
+	}
+	return s
+}
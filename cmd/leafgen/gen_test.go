@@ -0,0 +1,164 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGoType(t *testing.T) {
+	tests := []struct {
+		typ  string
+		want string
+	}{
+		{"int", "int"},
+		{"float", "float64"},
+		{"double", "float64"},
+		{"long", "int64"},
+		{"text", "string"},
+		{"[]int32", "[]int32"},
+		{"map[string]int", "map[string]int"},
+	}
+	for _, tt := range tests {
+		if got := goType(tt.typ); got != tt.want {
+			t.Errorf("goType(%q) = %q, want %q", tt.typ, got, tt.want)
+		}
+	}
+}
+
+func TestGoName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"id", "ID"},
+		{"item_type", "ItemType"},
+		{"itemType", "ItemType"},
+		{"max hp", "MaxHP"},
+		{"2nd", "F2nd"},
+	}
+	for _, tt := range tests {
+		if got := goName(tt.name); got != tt.want {
+			t.Errorf("goName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		name    string
+		g       generator
+		data    string
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "names types comments",
+			g:    generator{headerRows: 3, nameRow: 0, typeRow: 1, commentRow: 2},
+			data: "id\tname\tweight\n" +
+				"int index\tstring required\tfloat\n" +
+				"item id\titem name\t\n" +
+				"1\tsword\t1.5\n",
+			want: []string{
+				"// item id ID int `rf:\"index\"`",
+				"// item name Name string `rf:\"required\"`",
+				"Weight float64 }",
+				"rf.HeaderRows = 3",
+				"func (t *ItemTable) ByID(id int) *Item",
+			},
+		},
+		{
+			name: "no comment row",
+			g:    generator{headerRows: 2, nameRow: 0, typeRow: 1, commentRow: -1},
+			data: "id\tname\n" +
+				"int index\tstring\n" +
+				"1\tsword\n",
+			want:    []string{"ID int `rf:\"index\"` Name string }", "rf.HeaderRows = 2"},
+			notWant: []string{"// 1", "// sword"},
+		},
+		{
+			name: "types before names",
+			g:    generator{headerRows: 2, nameRow: 1, typeRow: 0, commentRow: -1},
+			data: "int index\tlong\n" +
+				"id\tcount\n",
+			want: []string{"ID int `rf:\"index\"` Count int64 }", "rf.NameRow = 1"},
+		},
+		{
+			name: "empty types and comments",
+			g:    generator{headerRows: 3, nameRow: 0, typeRow: 1, commentRow: 2},
+			data: "id\tname\tnote\n" +
+				"int index\t\t\n" +
+				"item id\t\t\n",
+			want: []string{"Name string Note string }"},
+		},
+		{
+			name: "skipped columns",
+			g:    generator{headerRows: 2, nameRow: 0, typeRow: 1, commentRow: -1},
+			data: "id\t#memo\t\tname\n" +
+				"int index\tstring\tstring\tstring\n",
+			want:    []string{"ID int `rf:\"index\"` Name string }"},
+			notWant: []string{"Memo"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.g.pkg = "gamedata"
+			path := filepath.Join(t.TempDir(), "item.txt")
+			if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			src, err := tt.g.generate(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := strings.Join(strings.Fields(string(src)), " ")
+			for _, w := range tt.want {
+				if !strings.Contains(s, w) {
+					t.Errorf("missing %q in\n%s", w, src)
+				}
+			}
+			for _, w := range tt.notWant {
+				if strings.Contains(s, w) {
+					t.Errorf("unexpected %q in\n%s", w, src)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateError(t *testing.T) {
+	const data = "id\tname\n" +
+		"int index\tstring\n" +
+		"item id\titem name\n"
+	tests := []struct {
+		name string
+		g    generator
+		data string
+		err  string
+	}{
+		{"zero header", generator{headerRows: 0, nameRow: 0, typeRow: 1, commentRow: -1}, data, "header 0"},
+		{"comment row is data", generator{headerRows: 2, nameRow: 0, typeRow: 1, commentRow: 2}, data, "commentrow 2"},
+		{"comment row below -1", generator{headerRows: 3, nameRow: 0, typeRow: 1, commentRow: -2}, data, "commentrow -2"},
+		{"name row is data", generator{headerRows: 2, nameRow: 2, typeRow: 1, commentRow: -1}, data, "namerow 2"},
+		{"negative type row", generator{headerRows: 3, nameRow: 0, typeRow: -1, commentRow: 2}, data, "typerow -1"},
+		{"type row is name row", generator{headerRows: 3, nameRow: 1, typeRow: 1, commentRow: 2}, data, "typerow 1"},
+		{"short sheet", generator{headerRows: 3, nameRow: 0, typeRow: 1, commentRow: 2}, "id\n", "header not found"},
+		{"no column", generator{headerRows: 2, nameRow: 0, typeRow: 1, commentRow: -1}, "\t#id\nint\tint\n", "no column"},
+		{"duplicate field", generator{headerRows: 2, nameRow: 0, typeRow: 1, commentRow: -1}, "id\tID\nint\tint\n", "duplicate field ID"},
+		{"multi without index", generator{headerRows: 2, nameRow: 0, typeRow: 1, commentRow: -1}, "id\nint multi\n", "multi without index"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.g.pkg = "gamedata"
+			path := filepath.Join(t.TempDir(), "item.txt")
+			if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := tt.g.generate(path)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+// Command leafgen generates Go code of recordfile tables from their headers.
+//
+// The header of a table is HeaderRows rows, the names, the types and the
+// comments of the columns, e.g. -header 2 -commentrow -1 for no comment
+// row. A type is a Go type followed by the rf tag options separated by
+// spaces, e.g. "int index" or "[]int ref=item.ID".
+// The columns with empty names or names starting with # are skipped.
+//
+//	id         name             quality                 drops
+//	int index  string required  string enum=white|blue  []int ref=item.ID
+//	item id    item name        quality                 drop items
+//
+// Usage:
+//
+//	leafgen -pkg gamedata -out gamedata [-snapshot] item.txt drop.xlsx
+//
+// For every file it writes a .go file with the row struct, a table type
+// with typed index accessors and a loader, with -snapshot also a loader
+// reading through a cstruct snapshot, see RecordFile.ReadWithSnapshot.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	pkg := flag.String("pkg", "gamedata", "package name")
+	out := flag.String("out", ".", "output directory")
+	snapshot := flag.Bool("snapshot", false, "generate the snapshot loaders")
+	headerRows := flag.Int("header", 3, "number of header rows")
+	nameRow := flag.Int("namerow", 0, "row of the column names")
+	typeRow := flag.Int("typerow", 1, "row of the column types")
+	commentRow := flag.Int("commentrow", 2, "row of the column comments, -1 for none")
+	sheet := flag.String("sheet", "", "sheet of the .xlsx files, empty for the first")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	g := &generator{
+		pkg:        *pkg,
+		snapshot:   *snapshot,
+		headerRows: *headerRows,
+		nameRow:    *nameRow,
+		typeRow:    *typeRow,
+		commentRow: *commentRow,
+		sheet:      *sheet,
+	}
+	if err := g.check(); err != nil {
+		fmt.Fprintf(os.Stderr, "leafgen: %v\n", err)
+		os.Exit(2)
+	}
+	for _, path := range flag.Args() {
+		src, err := g.generate(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "leafgen: %v: %v\n", path, err)
+			os.Exit(1)
+		}
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		dst := filepath.Join(*out, strings.ToLower(name)+".go")
+		if err := os.WriteFile(dst, src, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "leafgen: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
@@ -76,6 +76,42 @@ func Register(name string, help string, f interface{}, server *chanrpc.Server) {
 	commands = append(commands, c)
 }
 
+type funcCommand struct {
+	_name string
+	_help string
+	f     func(args []string) string
+}
+
+func (c *funcCommand) name() string {
+	return c._name
+}
+
+func (c *funcCommand) help() string {
+	return c._help
+}
+
+func (c *funcCommand) run(args []string) string {
+	return c.f(args)
+}
+
+// RegisterFunc registers a command run in the console goroutine, f must be
+// goroutine safe, e.g. cstruct.Processor.DumpCommand
+// you must call the function before calling console.Init
+// goroutine not safe
+func RegisterFunc(name string, help string, f func(args []string) string) {
+	for _, c := range commands {
+		if c.name() == name {
+			log.Fatalf("command %v is already registered", name)
+		}
+	}
+
+	c := new(funcCommand)
+	c._name = name
+	c._help = help
+	c.f = f
+	commands = append(commands, c)
+}
+
 // help
 type CommandHelp struct{}
 
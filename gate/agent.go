@@ -1,11 +1,12 @@
 package gate
 
 import (
+	"github.com/CreFire/leaf/network/cstruct"
 	"net"
 )
 
 type Agent interface {
-	WriteMsg(msg interface{})
+	WriteMsg(recv *cstruct.RecvMsg, mainCmdID uint16, subCmdID uint16, msg interface{})
 	LocalAddr() net.Addr
 	RemoteAddr() net.Addr
 	Close()
@@ -0,0 +1,81 @@
+package gate
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/CreFire/leaf/network"
+	"github.com/CreFire/leaf/network/cstruct"
+	log "github.com/sirupsen/logrus"
+)
+
+// RawWriter is implemented by the agents of Gate
+type RawWriter interface {
+	WriteRaw(data ...[]byte) error
+}
+
+// Broadcast marshals msg once for every processor of the agents and writes
+// it to all agents, the agents which are not RawWriter get it by WriteMsg.
+// Nothing is written if msg fails to marshal.
+func (gate *Gate) Broadcast(agents []Agent, recv *cstruct.RecvMsg, mainCmdID uint16, subCmdID uint16, msg interface{}) error {
+	if len(agents) == 0 {
+		return nil
+	}
+
+	// the agents of a few codecs at most
+	var marshaled []marshaledMsg
+	for _, a := range agents {
+		if _, ok := a.(RawWriter); !ok {
+			continue
+		}
+		p := gate.processorOfAgent(a)
+		if p == nil {
+			return errors.New("processor not set")
+		}
+		if findMarshaled(marshaled, p) != nil {
+			continue
+		}
+		data, err := p.Marshal(recv, mainCmdID, subCmdID, msg)
+		if err != nil {
+			log.Errorf("marshal message %v error: %v", reflect.TypeOf(msg), err)
+			return err
+		}
+		marshaled = append(marshaled, marshaledMsg{p, data})
+	}
+
+	for _, a := range agents {
+		w, ok := a.(RawWriter)
+		if !ok {
+			a.WriteMsg(recv, mainCmdID, subCmdID, msg)
+			continue
+		}
+		data := findMarshaled(marshaled, gate.processorOfAgent(a))
+		if err := w.WriteRaw(data...); err != nil {
+			log.Errorf("write message [%d,%d] to %v error: %v", mainCmdID, subCmdID, a.RemoteAddr(), err)
+		}
+	}
+	return nil
+}
+
+// processorOfAgent returns the negotiated processor of the agents of Gate,
+// Processor of the others
+func (gate *Gate) processorOfAgent(a Agent) network.Processor {
+	if pa, ok := a.(interface{ Processor() network.Processor }); ok {
+		return pa.Processor()
+	}
+	return gate.Processor
+}
+
+type marshaledMsg struct {
+	p    network.Processor
+	data [][]byte
+}
+
+func findMarshaled(marshaled []marshaledMsg, p network.Processor) [][]byte {
+	for i := range marshaled {
+		if marshaled[i].p == p {
+			return marshaled[i].data
+		}
+	}
+	return nil
+}
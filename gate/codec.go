@@ -0,0 +1,106 @@
+package gate
+
+import (
+	"sync/atomic"
+
+	"github.com/CreFire/leaf/network"
+	log "github.com/sirupsen/logrus"
+)
+
+// Codec is a processor which the clients select on connecting: the
+// websocket clients by the subprotocol Name in the handshake, and the
+// clients of the listeners by a first frame of the single Magic byte if
+// Magic is not 0. The agents which select none use the processor of the
+// listener, and the messages written before the first frame too.
+type Codec = network.Codec
+
+// the processor of an agent, changed by the negotiation
+type processorValue struct {
+	v atomic.Value
+}
+
+type processorBox struct {
+	p network.Processor
+}
+
+func (pv *processorValue) load() network.Processor {
+	b, _ := pv.v.Load().(processorBox)
+	return b.p
+}
+
+func (pv *processorValue) store(p network.Processor) {
+	pv.v.Store(processorBox{p})
+}
+
+func (gate *Gate) checkCodecs() {
+	names := make(map[string]bool)
+	magics := make(map[byte]bool)
+	for _, c := range gate.Codecs {
+		if c.Processor == nil {
+			log.Fatalf("codec %q without a processor", c.Name)
+		}
+		if c.Name != "" {
+			if names[c.Name] {
+				log.Fatalf("codec %q is already registered", c.Name)
+			}
+			names[c.Name] = true
+		}
+		if c.Magic != 0 {
+			if magics[c.Magic] {
+				log.Fatalf("codec magic %#x is already registered", c.Magic)
+			}
+			magics[c.Magic] = true
+		}
+	}
+}
+
+func (gate *Gate) subprotocols() []string {
+	var names []string
+	for _, c := range gate.Codecs {
+		if c.Name != "" {
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}
+
+// codecByName returns the codec of the subprotocol, nil if not found
+func (gate *Gate) codecByName(name string) *Codec {
+	if name == "" {
+		return nil
+	}
+	for i := range gate.Codecs {
+		if gate.Codecs[i].Name == name {
+			return &gate.Codecs[i]
+		}
+	}
+	return nil
+}
+
+// codecByMagic returns the codec of the magic byte, nil if not found
+func (gate *Gate) codecByMagic(magic byte) *Codec {
+	for i := range gate.Codecs {
+		if gate.Codecs[i].Magic != 0 && gate.Codecs[i].Magic == magic {
+			return &gate.Codecs[i]
+		}
+	}
+	return nil
+}
+
+// negotiable reports whether the first frame may select a codec
+func (gate *Gate) negotiable() bool {
+	for _, c := range gate.Codecs {
+		if c.Magic != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// processorOf returns the processor of the listener, Processor if nil
+func (gate *Gate) processorOf(p network.Processor) network.Processor {
+	if p != nil {
+		return p
+	}
+	return gate.Processor
+}
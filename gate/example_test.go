@@ -0,0 +1,150 @@
+package gate_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/CreFire/leaf/gate"
+	"github.com/CreFire/leaf/network"
+	"github.com/CreFire/leaf/network/cstruct"
+	"github.com/CreFire/leaf/network/json"
+	"github.com/gorilla/websocket"
+)
+
+type Hello struct {
+	Name string
+}
+
+func Example_codecs() {
+	jp := json.NewProcessor()
+	jp.Register(1, 1, &Hello{})
+	cp := cstruct.NewProcessor()
+	cp.Register(1, 1, &Hello{})
+	names := map[network.Processor]string{jp: "json", cp: "cstruct"}
+	handler := func(args []interface{}) {
+		msg := args[0].(*network.Envelope)
+		a := args[1].(gate.Agent)
+		p := a.(interface{ Processor() network.Processor }).Processor()
+		a.WriteMsg(msg, 1, 1, &Hello{Name: names[p] + " " + msg.Msg.(*Hello).Name})
+	}
+	jp.SetHandler(1, 1, handler)
+	cp.SetHandler(1, 1, handler)
+
+	g := &gate.Gate{
+		MaxConnNum:      10,
+		PendingWriteNum: 10,
+		MaxMsgLen:       4096,
+		WSAddr:          "127.0.0.1:37654",
+		HTTPTimeout:     time.Second,
+		WSProcessor:     jp,
+		TCPAddr:         "127.0.0.1:37563",
+		LenMsgLen:       2,
+		LittleEndian:    true,
+		TCPProcessor:    cp,
+		Codecs: []gate.Codec{
+			{Name: "json", Magic: 'J', Processor: jp},
+			{Name: "cstruct", Magic: 'C', Processor: cp},
+		},
+	}
+	closeSig := make(chan bool)
+	done := make(chan struct{})
+	go func() {
+		g.Run(closeSig)
+		close(done)
+	}()
+	defer func() {
+		close(closeSig)
+		<-done
+	}()
+
+	// a tcp agent which selects the json codec by the magic frame
+	reply, err := tcpExchange(g.TCPAddr, []byte{'J'}, []byte(`{"mainCmdId":1,"subCmdId":1,"body":{"Name":"tcp"}}`))
+	fmt.Printf("%s %v\n", reply, err)
+
+	// a websocket agent which selects the cstruct codec by the subprotocol
+	data, _ := cp.Marshal(cstruct.DefaultRecvMsg, 1, 1, &Hello{Name: "ws"})
+	var frame []byte
+	for _, b := range data {
+		frame = append(frame, b...)
+	}
+	reply, err = wsExchange(g.WSAddr, "cstruct", frame)
+	if err == nil {
+		msg, err2 := cp.Unmarshal(reply)
+		fmt.Println(msg.Msg.(*Hello).Name, err2)
+	} else {
+		fmt.Println(err)
+	}
+
+	// an unknown magic closes the agent
+	_, err = tcpExchange(g.TCPAddr, []byte{'X'}, frame)
+	fmt.Println("closed:", err != nil)
+
+	// Output:
+	// {"mainCmdId":1,"subCmdId":1,"body":{"Name":"json tcp"}} <nil>
+	// cstruct ws <nil>
+	// closed: true
+}
+
+// dial retries until the gate listens
+func dial(f func() error) error {
+	var err error
+	for i := 0; i < 50; i++ {
+		if err = f(); err == nil {
+			return nil
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return err
+}
+
+// tcpExchange writes the frames with a 2 bytes length and reads a frame
+func tcpExchange(addr string, frames ...[]byte) ([]byte, error) {
+	var conn net.Conn
+	err := dial(func() (err error) {
+		conn, err = net.Dial("tcp", addr)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	for _, f := range frames {
+		b := make([]byte, 2, 2+len(f))
+		binary.LittleEndian.PutUint16(b, uint16(len(f)))
+		if _, err := conn.Write(append(b, f...)); err != nil {
+			return nil, err
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var l [2]byte
+	if _, err := io.ReadFull(conn, l[:]); err != nil {
+		return nil, err
+	}
+	b := make([]byte, binary.LittleEndian.Uint16(l[:]))
+	_, err = io.ReadFull(conn, b)
+	return b, err
+}
+
+func wsExchange(addr string, subprotocol string, frame []byte) ([]byte, error) {
+	d := websocket.Dialer{Subprotocols: []string{subprotocol}}
+	var conn *websocket.Conn
+	err := dial(func() (err error) {
+		conn, _, err = d.Dial("ws://"+addr, nil)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := conn.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, b, err := conn.ReadMessage()
+	return b, err
+}
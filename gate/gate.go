@@ -3,6 +3,7 @@ package gate
 import (
 	"github.com/CreFire/leaf/chanrpc"
 	"github.com/CreFire/leaf/network"
+	"github.com/CreFire/leaf/network/capture"
 	"github.com/CreFire/leaf/network/cstruct"
 	log "github.com/sirupsen/logrus"
 	"net"
@@ -18,6 +19,12 @@ type Gate struct {
 	Processor       network.Processor
 	AgentChanRPC    *chanrpc.Server
 
+	// the processors of the listeners, Processor if nil
+	WSProcessor  network.Processor
+	TCPProcessor network.Processor
+	// the processors which the clients select on connecting, see Codec
+	Codecs []Codec
+
 	// websocket
 	WSAddr      string
 	HTTPTimeout time.Duration
@@ -28,9 +35,28 @@ type Gate struct {
 	TCPAddr      string
 	LenMsgLen    int
 	LittleEndian bool
+
+	// capture, the frames of the agents are written to the files in
+	// CaptureDir if not empty, a new file every CaptureMaxSize bytes, the
+	// oldest removed beyond CaptureMaxFiles files
+	CaptureDir      string
+	CaptureMaxSize  int64
+	CaptureMaxFiles int
+	recorder        *capture.Recorder
 }
 
 func (gate *Gate) Run(closeSig chan bool) {
+	if gate.CaptureDir != "" {
+		r, err := capture.NewRecorder(gate.CaptureDir, gate.CaptureMaxSize, gate.CaptureMaxFiles)
+		if err != nil {
+			log.Errorf("capture error: %v", err)
+		} else {
+			gate.recorder = r
+		}
+	}
+
+	gate.checkCodecs()
+
 	var wsServer *network.WSServer
 	if gate.WSAddr != "" {
 		wsServer = new(network.WSServer)
@@ -41,12 +67,9 @@ func (gate *Gate) Run(closeSig chan bool) {
 		wsServer.HTTPTimeout = gate.HTTPTimeout
 		wsServer.CertFile = gate.CertFile
 		wsServer.KeyFile = gate.KeyFile
+		wsServer.Subprotocols = gate.subprotocols()
 		wsServer.NewAgent = func(conn *network.WSConn) network.Agent {
-			a := &agent{conn: conn, gate: gate}
-			if gate.AgentChanRPC != nil {
-				gate.AgentChanRPC.Go("NewAgent", a)
-			}
-			return a
+			return gate.newAgent(conn, gate.processorOf(gate.WSProcessor), gate.codecByName(conn.Subprotocol()))
 		}
 	}
 
@@ -60,11 +83,7 @@ func (gate *Gate) Run(closeSig chan bool) {
 		tcpServer.MaxMsgLen = gate.MaxMsgLen
 		tcpServer.LittleEndian = gate.LittleEndian
 		tcpServer.NewAgent = func(conn *network.TCPConn) network.Agent {
-			a := &agent{conn: conn, gate: gate}
-			if gate.AgentChanRPC != nil {
-				gate.AgentChanRPC.Go("NewAgent", a)
-			}
-			return a
+			return gate.newAgent(conn, gate.processorOf(gate.TCPProcessor), nil)
 		}
 	}
 
@@ -81,33 +100,103 @@ func (gate *Gate) Run(closeSig chan bool) {
 	if tcpServer != nil {
 		tcpServer.Close()
 	}
+	if gate.recorder != nil {
+		gate.recorder.Close()
+	}
 }
 
 func (gate *Gate) OnDestroy() {}
 
+// codec is the one selected by the handshake, nil if none
+func (gate *Gate) newAgent(conn network.Conn, p network.Processor, codec *Codec) *agent {
+	a := &agent{conn: conn, gate: gate, recorder: gate.recorder}
+	a.processor.store(p)
+	if a.recorder != nil {
+		a.captureID = a.recorder.NewID()
+		a.capture(capture.Open, []byte(conn.RemoteAddr().String()))
+	}
+	if codec != nil {
+		a.negotiated = true
+		a.processor.store(codec.Processor)
+		a.capture(capture.Negotiate, []byte{0}, []byte(codec.Name))
+	}
+	if gate.AgentChanRPC != nil {
+		gate.AgentChanRPC.Go("NewAgent", a)
+	}
+	return a
+}
+
 type agent struct {
-	conn     network.Conn
-	gate     *Gate
-	userData interface{}
+	conn      network.Conn
+	gate      *Gate
+	userData  interface{}
+	recorder  *capture.Recorder
+	captureID uint32
+	processor processorValue
+	// the first frame is read, only of Run
+	negotiated bool
+}
+
+// Processor returns the processor of the agent, the negotiated one if any
+func (a *agent) Processor() network.Processor {
+	return a.processor.load()
+}
+
+// negotiate reports whether data is the magic frame of a codec, ok is false
+// if it is a magic frame of no codec
+func (a *agent) negotiate(data []byte) (magic bool, ok bool) {
+	if a.negotiated {
+		return false, true
+	}
+	a.negotiated = true
+	if len(data) != 1 || !a.gate.negotiable() {
+		return false, true
+	}
+	c := a.gate.codecByMagic(data[0])
+	if c == nil {
+		return true, false
+	}
+	a.processor.store(c.Processor)
+	a.capture(capture.Negotiate, data, []byte(c.Name))
+	return true, true
+}
+
+func (a *agent) capture(dir capture.Dir, data ...[]byte) {
+	if a.recorder == nil {
+		return
+	}
+	if err := a.recorder.Write(a.captureID, dir, data...); err != nil {
+		log.Errorf("capture error: %v", err)
+	}
 }
 
 func (a *agent) Run() {
 	for {
 		data, err := a.conn.ReadMsg()
 		if err != nil {
-			log.Debug("read message: %v", err)
+			log.Debugf("read message: %v", err)
 			break
 		}
 
-		if a.gate.Processor != nil {
-			msg, err2 := a.gate.Processor.Unmarshal(data)
+		// the magic frame is captured as the Negotiate record
+		if magic, ok := a.negotiate(data); !ok {
+			a.capture(capture.In, data)
+			log.Debugf("unknown codec magic %#x", data[0])
+			break
+		} else if magic {
+			continue
+		}
+		a.capture(capture.In, data)
+
+		if p := a.processor.load(); p != nil {
+			msg, err2 := p.Unmarshal(data)
 			if err2 != nil {
-				log.Debug("unmarshal message error: %v", err)
+				log.Debugf("unmarshal message error: %v", err)
 				break
 			}
-			err = a.gate.Processor.Route(msg, a)
+			err = p.Route(msg, a)
 			if err != nil {
-				log.Debug("route message error: %v", err)
+				log.Debugf("route message error: %v", err)
 				break
 			}
 		}
@@ -115,29 +204,66 @@ func (a *agent) Run() {
 }
 
 func (a *agent) OnClose() {
+	a.capture(capture.Close)
 	if a.gate.AgentChanRPC != nil {
 		err := a.gate.AgentChanRPC.Call0("CloseAgent", a)
 		if err != nil {
-			log.Error("chanrpc error: %v", err)
+			log.Errorf("chanrpc error: %v", err)
 		}
 	}
 }
 
 func (a *agent) WriteMsg(recv *cstruct.RecvMsg, mainCmdID uint16, subCmdID uint16, msg interface{}) {
-	if a.gate.Processor != nil {
-		data, err := a.gate.Processor.Marshal(recv, mainCmdID, subCmdID, msg)
+	if p := a.processor.load(); p != nil {
+		// one pooled buffer of the len, header and body
+		if ok, err := a.writeAppend(p, recv, mainCmdID, subCmdID, msg); ok {
+			log.Debugf("sendMsg : [%d,%d] [%d,%d] id[%d]", recv.MsgType, recv.RpcCallId, mainCmdID, subCmdID, cstruct.MakeDWORD(mainCmdID, subCmdID))
+			if err != nil {
+				log.Errorf("write message : [%d,%d] [%d,%d] id[%d] %v error: %v", recv.MsgType, recv.RpcCallId, mainCmdID, subCmdID, cstruct.MakeDWORD(mainCmdID, subCmdID), reflect.TypeOf(msg), err)
+			}
+			return
+		}
+
+		data, err := p.Marshal(recv, mainCmdID, subCmdID, msg)
 		if err != nil {
-			log.Error("marshal message %v error: %v", reflect.TypeOf(msg), err)
+			log.Errorf("marshal message %v error: %v", reflect.TypeOf(msg), err)
 			return
 		}
 		err = a.conn.WriteMsg(data...)
-		log.Debug("sendMsg : [%d,%d] [%d,%d] id[%d]", recv.MsgType, recv.RpcCallId, mainCmdID, subCmdID, cstruct.MakeDWORD(mainCmdID, subCmdID))
+		a.capture(capture.Out, data...)
+		log.Debugf("sendMsg : [%d,%d] [%d,%d] id[%d]", recv.MsgType, recv.RpcCallId, mainCmdID, subCmdID, cstruct.MakeDWORD(mainCmdID, subCmdID))
 		if err != nil {
-			log.Error("write message : [%d,%d] [%d,%d] id[%d] %v error: %v", recv.MsgType, recv.RpcCallId, mainCmdID, subCmdID, cstruct.MakeDWORD(mainCmdID, subCmdID), reflect.TypeOf(msg), err)
+			log.Errorf("write message : [%d,%d] [%d,%d] id[%d] %v error: %v", recv.MsgType, recv.RpcCallId, mainCmdID, subCmdID, cstruct.MakeDWORD(mainCmdID, subCmdID), reflect.TypeOf(msg), err)
 		}
 	}
 }
 
+// ok is false if the processor is not a network.AppendProcessor or the conn
+// is not a network.AppendConn
+func (a *agent) writeAppend(processor network.Processor, recv *cstruct.RecvMsg, mainCmdID uint16, subCmdID uint16, msg interface{}) (bool, error) {
+	p, ok := processor.(network.AppendProcessor)
+	if !ok {
+		return false, nil
+	}
+	c, ok := a.conn.(network.AppendConn)
+	if !ok {
+		return false, nil
+	}
+	return true, c.WriteMsgAppend(func(dst []byte) ([]byte, error) {
+		b, err := p.AppendMarshal(dst, recv, mainCmdID, subCmdID, msg)
+		if err == nil {
+			a.capture(capture.Out, b[len(dst):])
+		}
+		return b, err
+	})
+}
+
+// WriteRaw writes an already marshaled message
+func (a *agent) WriteRaw(data ...[]byte) error {
+	a.capture(capture.Out, data...)
+	return a.conn.WriteMsg(data...)
+}
+
 func (a *agent) LocalAddr() net.Addr {
 	return a.conn.LocalAddr()
 }
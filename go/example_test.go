@@ -3,6 +3,7 @@ package g_test
 import (
 	"fmt"
 	g "github.com/CreFire/leaf/go"
+	"sync"
 	"time"
 )
 
@@ -68,3 +69,71 @@ func ExampleLinearContext() {
 	// 1
 	// 2
 }
+
+func ExamplePool() {
+	d := g.New(10)
+
+	// a single worker with room for one pending call
+	p := d.NewPool("db", 1, 1)
+
+	block := make(chan struct{})
+	p.Go(func() {
+		<-block
+	}, nil)
+	for p.Stats().Running == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	err := p.Go(func() {
+		fmt.Println("queued")
+	}, func() {
+		fmt.Println("callback")
+	})
+	fmt.Println(err)
+
+	err = p.Go(func() {}, nil)
+	fmt.Println(err)
+
+	close(block)
+	d.Close()
+
+	s := p.Stats()
+	fmt.Println(s.Completed, s.Rejected)
+
+	// Output:
+	// <nil>
+	// pool queue full
+	// queued
+	// callback
+	// 2 1
+}
+
+func ExampleKeyedContext() {
+	d := g.New(10)
+	c := d.NewKeyedContext(4)
+
+	var mutex sync.Mutex
+	var orders = map[string][]int{}
+	for i := 0; i < 3; i++ {
+		for _, key := range []string{"player1", "player2"} {
+			i, key := i, key
+			c.Go(key, func() {
+				time.Sleep(time.Millisecond * time.Duration(3-i))
+				mutex.Lock()
+				orders[key] = append(orders[key], i)
+				mutex.Unlock()
+			}, nil)
+		}
+	}
+
+	d.Close()
+
+	fmt.Println(orders["player1"])
+	fmt.Println(orders["player2"])
+	fmt.Println(c.NumKeys())
+
+	// Output:
+	// [0 1 2]
+	// [0 1 2]
+	// 0
+}
@@ -15,6 +15,8 @@ import (
 type Go struct {
 	ChanCb    chan func()
 	pendingGo int
+	pools     map[string]*Pool
+	keyed     []*KeyedContext
 }
 
 type LinearGo struct {
@@ -79,6 +81,13 @@ func (g *Go) Close() {
 	for g.pendingGo > 0 {
 		g.Cb(<-g.ChanCb)
 	}
+
+	for _, p := range g.pools {
+		p.close()
+	}
+	for _, c := range g.keyed {
+		c.close()
+	}
 }
 
 func (g *Go) Idle() bool {
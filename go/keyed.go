@@ -0,0 +1,129 @@
+package g
+
+import (
+	"container/list"
+	"runtime"
+	"sync"
+
+	"github.com/CreFire/leaf/conf"
+	log "github.com/sirupsen/logrus"
+)
+
+// KeyedContext runs the calls of the same key one by one in order,
+// calls of different keys run in parallel on a fixed number of workers.
+// A key is forgotten as soon as it has nothing left to run.
+type KeyedContext struct {
+	g         *Go
+	mutex     sync.Mutex
+	cond      *sync.Cond
+	keys      map[interface{}]*keyedQueue
+	ready     *list.List
+	closeFlag bool
+	wg        sync.WaitGroup
+}
+
+type keyedQueue struct {
+	key      interface{}
+	linearGo *list.List
+}
+
+func (g *Go) NewKeyedContext(workers int) *KeyedContext {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	c := new(KeyedContext)
+	c.g = g
+	c.cond = sync.NewCond(&c.mutex)
+	c.keys = make(map[interface{}]*keyedQueue)
+	c.ready = list.New()
+	c.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go c.work()
+	}
+
+	g.keyed = append(g.keyed, c)
+	return c
+}
+
+func (c *KeyedContext) Go(key interface{}, f func(), cb func()) {
+	c.g.pendingGo++
+
+	c.mutex.Lock()
+	q, ok := c.keys[key]
+	if !ok {
+		q = &keyedQueue{key: key, linearGo: list.New()}
+		c.keys[key] = q
+		c.ready.PushBack(q)
+		c.cond.Signal()
+	}
+	q.linearGo.PushBack(&LinearGo{f: f, cb: cb})
+	c.mutex.Unlock()
+}
+
+// goroutine safe
+func (c *KeyedContext) NumKeys() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.keys)
+}
+
+func (c *KeyedContext) work() {
+	defer c.wg.Done()
+
+	for {
+		c.mutex.Lock()
+		for c.ready.Len() == 0 && !c.closeFlag {
+			c.cond.Wait()
+		}
+		if c.ready.Len() == 0 {
+			c.mutex.Unlock()
+			return
+		}
+		q := c.ready.Remove(c.ready.Front()).(*keyedQueue)
+		e := q.linearGo.Remove(q.linearGo.Front()).(*LinearGo)
+		c.mutex.Unlock()
+
+		c.exec(e)
+
+		c.mutex.Lock()
+		if q.linearGo.Len() == 0 {
+			delete(c.keys, q.key)
+		} else {
+			c.ready.PushBack(q)
+			c.cond.Signal()
+		}
+		c.mutex.Unlock()
+	}
+}
+
+func (c *KeyedContext) exec(e *LinearGo) {
+	defer func() {
+		c.g.ChanCb <- e.cb
+		if r := recover(); r != nil {
+			if conf.LenStackBuf > 0 {
+				buf := make([]byte, conf.LenStackBuf)
+				l := runtime.Stack(buf, false)
+				log.Errorf("%v: %s", r, buf[:l])
+			} else {
+				log.Errorf("%v", r)
+			}
+		}
+	}()
+
+	e.f()
+}
+
+// close must be called when nothing is pending
+func (c *KeyedContext) close() {
+	c.mutex.Lock()
+	if c.closeFlag {
+		c.mutex.Unlock()
+		return
+	}
+	c.closeFlag = true
+	c.cond.Broadcast()
+	c.mutex.Unlock()
+
+	c.wg.Wait()
+}
@@ -0,0 +1,156 @@
+package g
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/CreFire/leaf/conf"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrPoolFull   = errors.New("pool queue full")
+	ErrPoolClosed = errors.New("pool closed")
+)
+
+// Pool runs f on a fixed number of workers, pending calls wait in a
+// bounded queue. cb is still executed by the owner of Go via ChanCb.
+type Pool struct {
+	name      string
+	workers   int
+	g         *Go
+	queue     chan *LinearGo
+	closeFlag bool
+	wg        sync.WaitGroup
+
+	// metrics
+	running   int64
+	completed int64
+	rejected  int64
+	panics    int64
+}
+
+type PoolStats struct {
+	Name      string
+	Workers   int
+	QueueLen  int
+	Queued    int
+	Running   int64
+	Completed int64
+	Rejected  int64
+	Panics    int64
+}
+
+func (g *Go) NewPool(name string, workers int, queueLen int) *Pool {
+	if _, ok := g.pools[name]; ok {
+		panic("pool " + name + ": already exists")
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if queueLen < 0 {
+		queueLen = 0
+	}
+
+	p := new(Pool)
+	p.name = name
+	p.workers = workers
+	p.g = g
+	p.queue = make(chan *LinearGo, queueLen)
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+
+	if g.pools == nil {
+		g.pools = make(map[string]*Pool)
+	}
+	g.pools[name] = p
+	return p
+}
+
+func (g *Go) Pool(name string) *Pool {
+	return g.pools[name]
+}
+
+func (g *Go) RangePools(f func(p *Pool)) {
+	for _, p := range g.pools {
+		f(p)
+	}
+}
+
+func (p *Pool) Name() string {
+	return p.name
+}
+
+// Go returns ErrPoolFull without queueing f when the queue is full,
+// cb is not called in that case
+func (p *Pool) Go(f func(), cb func()) error {
+	if p.closeFlag {
+		return ErrPoolClosed
+	}
+
+	select {
+	case p.queue <- &LinearGo{f: f, cb: cb}:
+		p.g.pendingGo++
+		return nil
+	default:
+		atomic.AddInt64(&p.rejected, 1)
+		return ErrPoolFull
+	}
+}
+
+// goroutine safe
+func (p *Pool) Stats() PoolStats {
+	return PoolStats{
+		Name:      p.name,
+		Workers:   p.workers,
+		QueueLen:  cap(p.queue),
+		Queued:    len(p.queue),
+		Running:   atomic.LoadInt64(&p.running),
+		Completed: atomic.LoadInt64(&p.completed),
+		Rejected:  atomic.LoadInt64(&p.rejected),
+		Panics:    atomic.LoadInt64(&p.panics),
+	}
+}
+
+func (p *Pool) work() {
+	defer p.wg.Done()
+
+	for e := range p.queue {
+		p.exec(e)
+	}
+}
+
+func (p *Pool) exec(e *LinearGo) {
+	atomic.AddInt64(&p.running, 1)
+	defer func() {
+		atomic.AddInt64(&p.running, -1)
+		atomic.AddInt64(&p.completed, 1)
+		p.g.ChanCb <- e.cb
+		if r := recover(); r != nil {
+			atomic.AddInt64(&p.panics, 1)
+			if conf.LenStackBuf > 0 {
+				buf := make([]byte, conf.LenStackBuf)
+				l := runtime.Stack(buf, false)
+				log.Errorf("pool %v: %v: %s", p.name, r, buf[:l])
+			} else {
+				log.Errorf("pool %v: %v", p.name, r)
+			}
+		}
+	}()
+
+	e.f()
+}
+
+// close must be called when nothing is pending
+func (p *Pool) close() {
+	if p.closeFlag {
+		return
+	}
+	p.closeFlag = true
+	close(p.queue)
+	p.wg.Wait()
+}
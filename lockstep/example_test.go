@@ -0,0 +1,109 @@
+package lockstep_test
+
+import (
+	"fmt"
+
+	"github.com/CreFire/leaf/gate"
+	"github.com/CreFire/leaf/lockstep"
+	"github.com/CreFire/leaf/network/cstruct"
+	"github.com/CreFire/leaf/room"
+	"github.com/CreFire/leaf/timer"
+)
+
+// agent implements only WriteMsg, the other methods are not called
+type agent struct {
+	gate.Agent
+	name string
+}
+
+func (a *agent) WriteMsg(recv *cstruct.RecvMsg, mainCmdID uint16, subCmdID uint16, msg interface{}) {
+	switch msg := msg.(type) {
+	case *lockstep.FrameMsg:
+		fmt.Printf("%v <- frame %v inputs %v\n", a.name, msg.Frame, len(msg.Inputs))
+	case *lockstep.FramesMsg:
+		fmt.Printf("%v <- frames %v-%v\n", a.name, msg.Frames[0].Frame, msg.Frames[len(msg.Frames)-1].Frame)
+	}
+}
+
+func Example() {
+	d := timer.NewDispatcher(10)
+	mgr := room.NewManager(d)
+	mgr.Handle(10, 1, lockstep.HandleInput)
+
+	match := &lockstep.Match{MainCmdID: 10, SubCmdFrame: 2, SubCmdFrames: 3}
+	r, _ := mgr.Create("match-1", 30, match)
+
+	a := &agent{name: "a"}
+	mgr.Join(r.ID(), uint32(1), a, nil)
+
+	// frame 1
+	mgr.Route([]interface{}{
+		&cstruct.RecvMsg{MsgId: cstruct.MakeDWORD(10, 1), Msg: &lockstep.InputMsg{Data: []byte("jump")}},
+		a,
+	})
+	(<-d.ChanTimer).Cb()
+
+	// frame 2
+	(<-d.ChanTimer).Cb()
+
+	// a late joiner catches up
+	b := &agent{name: "b"}
+	mgr.Join(r.ID(), uint32(2), b, nil)
+	(<-d.ChanTimer).Cb()
+	(<-d.ChanTimer).Cb()
+
+	fmt.Println(len(match.Frames(0, 0)[0].Inputs))
+	mgr.Destroy(r.ID())
+
+	// Output:
+	// a <- frame 1 inputs 1
+	// a <- frame 2 inputs 0
+	// a <- frame 3 inputs 0
+	// b <- frames 1-3
+	// a <- frame 4 inputs 0
+	// b <- frame 4 inputs 0
+	// 1
+}
+
+func ExampleMatch_maxLag() {
+	d := timer.NewDispatcher(10)
+	mgr := room.NewManager(d)
+	mgr.Handle(10, 1, lockstep.HandleInput)
+
+	match := &lockstep.Match{MainCmdID: 10, SubCmdFrame: 2, SubCmdFrames: 3, MaxLag: 2}
+	r, _ := mgr.Create("match-1", 30, match)
+	a := &agent{name: "a"}
+	mgr.Join(r.ID(), uint32(1), a, nil)
+	ack := func(frame uint32) {
+		mgr.Route([]interface{}{
+			&cstruct.RecvMsg{MsgId: cstruct.MakeDWORD(10, 1), Msg: &lockstep.InputMsg{Ack: frame}},
+			a,
+		})
+	}
+
+	// no acks, nothing is sent after the first MaxLag frames
+	for i := 0; i < 4; i++ {
+		(<-d.ChanTimer).Cb()
+	}
+
+	// an ack beyond the frames sent is ignored
+	ack(100)
+	(<-d.ChanTimer).Cb()
+	fmt.Println("frame", match.Frame())
+
+	// the acks let it catch up
+	for _, frame := range []uint32{2, 4, 6, 8} {
+		ack(frame)
+		(<-d.ChanTimer).Cb()
+	}
+	mgr.Destroy(r.ID())
+
+	// Output:
+	// a <- frame 1 inputs 0
+	// a <- frame 2 inputs 0
+	// frame 5
+	// a <- frames 3-4
+	// a <- frames 5-6
+	// a <- frames 7-8
+	// a <- frame 9 inputs 0
+}
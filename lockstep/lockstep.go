@@ -0,0 +1,181 @@
+package lockstep
+
+import (
+	"time"
+
+	"github.com/CreFire/leaf/room"
+	log "github.com/sirupsen/logrus"
+)
+
+// client -> server
+type InputMsg struct {
+	Ack  uint32 // last frame received by the client
+	Data []byte // empty for an ack only
+}
+
+type PlayerInput struct {
+	PlayerID uint32
+	Data     []byte
+}
+
+// server -> client, one merged frame
+type FrameMsg struct {
+	Frame  uint32
+	Inputs []*PlayerInput
+}
+
+// server -> client, frames for late joiners, reconnects and slow clients
+type FramesMsg struct {
+	Frames []*FrameMsg
+}
+
+type player struct {
+	id   uint32
+	ack  uint32
+	sent uint32
+}
+
+// Match is the room.Logic of a lockstep match, the frame rate is the hz of
+// its room. Member ids must be uint32.
+//
+// r, _ := mgr.Create(id, 20, &lockstep.Match{MainCmdID: 10, SubCmdFrame: 2, SubCmdFrames: 3})
+// mgr.Handle(10, 1, lockstep.HandleInput)
+type Match struct {
+	MainCmdID    uint16
+	SubCmdFrame  uint16
+	SubCmdFrames uint16
+	// the frames sent to a player are at most MaxLag frames after its ack,
+	// a player that stops acking gets no frame until it acks again, then it
+	// catches up in batches, 0 means no limit
+	MaxLag uint32
+	// frames per FramesMsg
+	BatchSize int
+
+	frames  []*FrameMsg
+	pending []*PlayerInput
+	players map[uint32]*player
+}
+
+// HandleInput is the room.Handler of InputMsg
+func HandleInput(r *room.Room, m *room.Member, msg interface{}) {
+	match, ok := r.Logic().(*Match)
+	if !ok {
+		log.Errorf("room %v is not a lockstep match", r.ID())
+		return
+	}
+	match.Input(m, msg.(*InputMsg))
+}
+
+// Input queues the input of m for the next frame
+func (match *Match) Input(m *room.Member, msg *InputMsg) {
+	p := match.player(m)
+	if p == nil {
+		return
+	}
+	// not beyond the frames sent, which would make the lag underflow
+	if msg.Ack > p.ack && msg.Ack <= p.sent {
+		p.ack = msg.Ack
+	}
+	if len(msg.Data) > 0 {
+		match.pending = append(match.pending, &PlayerInput{PlayerID: p.id, Data: msg.Data})
+	}
+}
+
+// Resume makes a reconnected player continue from frame, the frames after
+// it are sent in batches
+func (match *Match) Resume(playerID uint32, frame uint32) {
+	p, ok := match.players[playerID]
+	if !ok {
+		return
+	}
+	if frame > match.Frame() {
+		frame = match.Frame()
+	}
+	p.ack = frame
+	p.sent = frame
+}
+
+// Frame returns the last frame
+func (match *Match) Frame() uint32 {
+	return uint32(len(match.frames))
+}
+
+// Frames returns the frames after from, at most n
+func (match *Match) Frames(from uint32, n int) []*FrameMsg {
+	if from >= match.Frame() {
+		return nil
+	}
+	frames := match.frames[from:]
+	if n > 0 && len(frames) > n {
+		frames = frames[:n]
+	}
+	return frames
+}
+
+// player returns the player of m, nil if not found
+func (match *Match) player(m *room.Member) *player {
+	id, ok := m.ID.(uint32)
+	if !ok {
+		return nil
+	}
+	return match.players[id]
+}
+
+func (match *Match) OnCreate(r *room.Room) {
+	if match.BatchSize <= 0 {
+		match.BatchSize = 32
+	}
+	match.players = make(map[uint32]*player)
+}
+
+// a new player starts from frame 0, a returning one keeps its progress
+func (match *Match) OnJoin(r *room.Room, m *room.Member) {
+	id, ok := m.ID.(uint32)
+	if !ok {
+		log.Errorf("room %v member id %v is not a uint32", r.ID(), m.ID)
+		return
+	}
+	if _, ok := match.players[id]; !ok {
+		match.players[id] = &player{id: id}
+	}
+}
+
+func (match *Match) OnLeave(r *room.Room, m *room.Member) {}
+
+func (match *Match) OnTick(r *room.Room, frame uint64, dt time.Duration) {
+	f := &FrameMsg{Frame: match.Frame() + 1, Inputs: match.pending}
+	match.frames = append(match.frames, f)
+	match.pending = nil
+
+	r.RangeMembers(func(m *room.Member) {
+		p := match.player(m)
+		if p == nil {
+			return
+		}
+		last := f.Frame
+		if match.MaxLag > 0 && p.ack+match.MaxLag < last {
+			last = p.ack + match.MaxLag
+		}
+		if p.sent >= last {
+			return
+		}
+		if p.sent+1 == f.Frame {
+			m.WriteMsg(match.MainCmdID, match.SubCmdFrame, f)
+			p.sent = f.Frame
+			return
+		}
+
+		// catch up one batch per tick, so that a slow client does not get flooded
+		n := match.BatchSize
+		if int(last-p.sent) < n {
+			n = int(last - p.sent)
+		}
+		frames := match.Frames(p.sent, n)
+		if len(frames) > 0 {
+			m.WriteMsg(match.MainCmdID, match.SubCmdFrames, &FramesMsg{Frames: frames})
+			p.sent += uint32(len(frames))
+		}
+	})
+}
+
+func (match *Match) OnDestroy(r *room.Room) {}
@@ -0,0 +1,118 @@
+package matchmaking_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/CreFire/leaf/gate"
+	"github.com/CreFire/leaf/matchmaking"
+	"github.com/CreFire/leaf/network/cstruct"
+	"github.com/CreFire/leaf/room"
+	"github.com/CreFire/leaf/timer"
+)
+
+// agent implements only WriteMsg, the other methods are not called
+type agent struct {
+	gate.Agent
+	name string
+}
+
+func (a *agent) WriteMsg(recv *cstruct.RecvMsg, mainCmdID uint16, subCmdID uint16, msg interface{}) {
+	m := msg.(*matchmaking.MatchedMsg)
+	fmt.Printf("%v <- match %v team %v\n", a.name, m.MatchID, m.Team)
+}
+
+type battle struct{}
+
+func (b *battle) OnCreate(r *room.Room)                               {}
+func (b *battle) OnJoin(r *room.Room, m *room.Member)                 {}
+func (b *battle) OnLeave(r *room.Room, m *room.Member)                {}
+func (b *battle) OnTick(r *room.Room, frame uint64, dt time.Duration) {}
+func (b *battle) OnDestroy(r *room.Room)                              {}
+
+func Example() {
+	d := timer.NewDispatcher(10)
+	mgr := room.NewManager(d)
+
+	m := matchmaking.New(d)
+	m.Interval = time.Millisecond
+	m.AddMode(&matchmaking.Mode{
+		Name:       "1v1",
+		Teams:      2,
+		TeamSize:   1,
+		Window:     100,
+		WidenEvery: 10 * time.Millisecond,
+		WidenStep:  100,
+		OnMatched: matchmaking.RoomHandoff(mgr, 0, func(m *matchmaking.Match) room.Logic {
+			return new(battle)
+		}, 1, 1),
+	})
+	m.Start()
+
+	player := func(name string, rating int32) *matchmaking.Ticket {
+		return &matchmaking.Ticket{
+			ID:      name,
+			Mode:    "1v1",
+			Players: []*matchmaking.Player{{ID: name, Agent: &agent{name: name}, Rating: rating}},
+		}
+	}
+	m.Enqueue(player("a", 1000))
+	m.Enqueue(player("b", 1400))
+	m.Enqueue(player("c", 2000))
+
+	// out of the window
+	(<-d.ChanTimer).Cb()
+	fmt.Println(m.NumTicket("1v1"))
+
+	// the window widens
+	time.Sleep(40 * time.Millisecond)
+	(<-d.ChanTimer).Cb()
+	fmt.Println(m.NumTicket("1v1"), mgr.NumRoom())
+
+	// cancel
+	fmt.Println(m.Cancel("c"), m.NumTicket("1v1"))
+	m.Stop()
+
+	// Output:
+	// 3
+	// a <- match 1 team 0
+	// b <- match 1 team 1
+	// 1 1
+	// true 0
+}
+
+func ExampleMode_party() {
+	d := timer.NewDispatcher(10)
+	m := matchmaking.New(d)
+	m.Interval = time.Millisecond
+	m.AddMode(&matchmaking.Mode{
+		Name:     "2v2",
+		Teams:    2,
+		TeamSize: 2,
+		Window:   100,
+		OnMatched: func(match *matchmaking.Match) {
+			match.RangePlayers(func(team int, p *matchmaking.Player) {
+				fmt.Println(p.ID, "team", team)
+			})
+		},
+	})
+	m.Start()
+
+	m.Enqueue(&matchmaking.Ticket{ID: 1, Mode: "2v2", Players: []*matchmaking.Player{
+		{ID: "a", Rating: 1000},
+		{ID: "b", Rating: 1020},
+	}})
+	m.Enqueue(&matchmaking.Ticket{ID: 2, Mode: "2v2", Players: []*matchmaking.Player{{ID: "c", Rating: 1010}}})
+	m.Enqueue(&matchmaking.Ticket{ID: 3, Mode: "2v2", Players: []*matchmaking.Player{{ID: "d", Rating: 990}}})
+	fmt.Println(m.Enqueue(&matchmaking.Ticket{ID: 4, Mode: "2v2", Players: make([]*matchmaking.Player, 3)}))
+
+	(<-d.ChanTimer).Cb()
+	m.Stop()
+
+	// Output:
+	// invalid party size
+	// a team 0
+	// b team 0
+	// c team 1
+	// d team 1
+}
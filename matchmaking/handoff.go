@@ -0,0 +1,37 @@
+package matchmaking
+
+import (
+	"github.com/CreFire/leaf/room"
+	log "github.com/sirupsen/logrus"
+)
+
+// server -> client
+type MatchedMsg struct {
+	MatchID uint64
+	Mode    string
+	Team    uint32
+}
+
+// RoomHandoff returns an OnMatched callback which creates a room with the id
+// of the match, joins all players (member id is the player id, member data
+// is the *Player) and sends MatchedMsg to every matched agent
+//
+// mode.OnMatched = matchmaking.RoomHandoff(mgr, 20, newLogic, 10, 1)
+func RoomHandoff(mgr *room.Manager, hz int, newLogic func(m *Match) room.Logic, mainCmdID uint16, subCmdID uint16) func(m *Match) {
+	return func(m *Match) {
+		r, err := mgr.Create(m.ID, hz, newLogic(m))
+		if err != nil {
+			log.Errorf("match %v: create room: %v", m.ID, err)
+			return
+		}
+
+		m.RangePlayers(func(team int, p *Player) {
+			member, err := mgr.Join(r.ID(), p.ID, p.Agent, p)
+			if err != nil {
+				log.Errorf("match %v: player %v join room: %v", m.ID, p.ID, err)
+				return
+			}
+			member.WriteMsg(mainCmdID, subCmdID, &MatchedMsg{MatchID: m.ID, Mode: m.Mode.Name, Team: uint32(team)})
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package matchmaking
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/CreFire/leaf/gate"
+	"github.com/CreFire/leaf/timer"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrModeNotFound = errors.New("mode not found")
+	ErrTicketExists = errors.New("ticket already queued")
+	ErrPartySize    = errors.New("invalid party size")
+)
+
+type Player struct {
+	ID     interface{}
+	Agent  gate.Agent
+	Rating int32
+}
+
+// Ticket is a queued party, a solo player is a party of one
+type Ticket struct {
+	ID       interface{}
+	Mode     string
+	Players  []*Player
+	UserData interface{}
+	rating   int32
+	enqueued time.Time
+}
+
+// Rating returns the average rating of the party
+func (t *Ticket) Rating() int32 {
+	return t.rating
+}
+
+// Waited returns how long the ticket has been queued
+func (t *Ticket) Waited() time.Duration {
+	return time.Since(t.enqueued)
+}
+
+type Mode struct {
+	Name     string
+	Teams    int
+	TeamSize int
+	// the rating window starts at Window and grows by WidenStep every
+	// WidenEvery, up to MaxWindow (0 means no limit)
+	Window     int32
+	WidenEvery time.Duration
+	WidenStep  int32
+	MaxWindow  int32
+	// called on the goroutine of the matcher
+	OnMatched func(m *Match)
+
+	queue []*Ticket
+}
+
+func (mode *Mode) window(t *Ticket) int32 {
+	w := mode.Window
+	if mode.WidenEvery > 0 {
+		w += int32(t.Waited()/mode.WidenEvery) * mode.WidenStep
+	}
+	if mode.MaxWindow > 0 && w > mode.MaxWindow {
+		w = mode.MaxWindow
+	}
+	return w
+}
+
+type Match struct {
+	ID    uint64
+	Mode  *Mode
+	Teams [][]*Ticket
+}
+
+// RangePlayers visits the players with their team index
+func (m *Match) RangePlayers(f func(team int, p *Player)) {
+	for i, team := range m.Teams {
+		for _, t := range team {
+			for _, p := range t.Players {
+				f(i, p)
+			}
+		}
+	}
+}
+
+// Matcher matches the queued tickets every Interval, it must be used on the
+// goroutine of the clock, e.g. New(skeleton) in a module
+// one matcher per goroutine (goroutine not safe)
+type Matcher struct {
+	Interval time.Duration
+	clock    timer.Clock
+	modes    map[string]*Mode
+	tickets  map[interface{}]*Ticket
+	t        *timer.Timer
+	lastID   uint64
+}
+
+func New(clock timer.Clock) *Matcher {
+	m := new(Matcher)
+	m.Interval = time.Second
+	m.clock = clock
+	m.modes = make(map[string]*Mode)
+	m.tickets = make(map[interface{}]*Ticket)
+	return m
+}
+
+func (m *Matcher) AddMode(mode *Mode) {
+	if mode.Teams <= 0 || mode.TeamSize <= 0 {
+		panic(fmt.Sprintf("mode %v: invalid team size", mode.Name))
+	}
+	if _, ok := m.modes[mode.Name]; ok {
+		panic(fmt.Sprintf("mode %v: already added", mode.Name))
+	}
+	m.modes[mode.Name] = mode
+}
+
+func (m *Matcher) Start() {
+	if m.t == nil {
+		m.t = m.clock.AfterFunc(m.Interval, m.tick)
+	}
+}
+
+func (m *Matcher) Stop() {
+	if m.t != nil {
+		m.t.Stop()
+		m.t = nil
+	}
+}
+
+func (m *Matcher) Enqueue(t *Ticket) error {
+	mode, ok := m.modes[t.Mode]
+	if !ok {
+		return ErrModeNotFound
+	}
+	if _, ok := m.tickets[t.ID]; ok {
+		return ErrTicketExists
+	}
+	if len(t.Players) == 0 || len(t.Players) > mode.TeamSize {
+		return ErrPartySize
+	}
+
+	var sum int64
+	for _, p := range t.Players {
+		sum += int64(p.Rating)
+	}
+	t.rating = int32(sum / int64(len(t.Players)))
+	t.enqueued = time.Now()
+
+	m.tickets[t.ID] = t
+	mode.queue = append(mode.queue, t)
+	return nil
+}
+
+// Cancel removes a queued ticket
+func (m *Matcher) Cancel(id interface{}) bool {
+	t, ok := m.tickets[id]
+	if !ok {
+		return false
+	}
+	m.remove(t)
+	return true
+}
+
+// CancelAgent removes the ticket of agent, call it on CloseAgent
+func (m *Matcher) CancelAgent(agent gate.Agent) bool {
+	for _, t := range m.tickets {
+		for _, p := range t.Players {
+			if p.Agent == agent {
+				m.remove(t)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (m *Matcher) Ticket(id interface{}) *Ticket {
+	return m.tickets[id]
+}
+
+func (m *Matcher) NumTicket(mode string) int {
+	if mode, ok := m.modes[mode]; ok {
+		return len(mode.queue)
+	}
+	return 0
+}
+
+func (m *Matcher) remove(t *Ticket) {
+	delete(m.tickets, t.ID)
+	mode := m.modes[t.Mode]
+	for i, o := range mode.queue {
+		if o == t {
+			mode.queue = append(mode.queue[:i], mode.queue[i+1:]...)
+			break
+		}
+	}
+}
+
+func (m *Matcher) tick() {
+	m.t = m.clock.AfterFunc(m.Interval, m.tick)
+	for _, mode := range m.modes {
+		m.match(mode)
+	}
+}
+
+// the longest waiting ticket is the anchor, the others within its window
+// are added by rating distance until every team is full
+func (m *Matcher) match(mode *Mode) {
+	for i := 0; i < len(mode.queue); {
+		anchor := mode.queue[i]
+		w := mode.window(anchor)
+
+		var candidates []*Ticket
+		for _, t := range mode.queue[i+1:] {
+			if abs(t.rating-anchor.rating) <= w {
+				candidates = append(candidates, t)
+			}
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return abs(candidates[i].rating-anchor.rating) < abs(candidates[j].rating-anchor.rating)
+		})
+
+		teams, ok := fill(mode, anchor, candidates)
+		if !ok {
+			i++
+			continue
+		}
+
+		m.lastID++
+		match := &Match{ID: m.lastID, Mode: mode, Teams: teams}
+		for _, team := range teams {
+			for _, t := range team {
+				m.remove(t)
+			}
+		}
+		if mode.OnMatched == nil {
+			log.Errorf("mode %v: OnMatched not set", mode.Name)
+			continue
+		}
+		mode.OnMatched(match)
+	}
+}
+
+func fill(mode *Mode, anchor *Ticket, candidates []*Ticket) ([][]*Ticket, bool) {
+	teams := make([][]*Ticket, mode.Teams)
+	free := make([]int, mode.Teams)
+	for i := range free {
+		free[i] = mode.TeamSize
+	}
+	left := mode.Teams * mode.TeamSize
+
+	put := func(t *Ticket) {
+		// the team with the most free slots keeps the teams even
+		best := -1
+		for i := range free {
+			if free[i] >= len(t.Players) && (best < 0 || free[i] > free[best]) {
+				best = i
+			}
+		}
+		if best >= 0 {
+			teams[best] = append(teams[best], t)
+			free[best] -= len(t.Players)
+			left -= len(t.Players)
+		}
+	}
+
+	put(anchor)
+	for _, t := range candidates {
+		if left == 0 {
+			break
+		}
+		put(t)
+	}
+	return teams, left == 0
+}
+
+func abs(n int32) int32 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/CreFire/leaf/chanrpc"
 	"github.com/CreFire/leaf/console"
 	"github.com/CreFire/leaf/go"
+	"github.com/CreFire/leaf/schedule"
 	"github.com/CreFire/leaf/timer"
 	"time"
 )
@@ -12,8 +13,10 @@ type Skeleton struct {
 	GoLen              int
 	TimerDispatcherLen int
 	AsynCallLen        int
+	KeyedGoNum         int
 	ChanRPCServer      *chanrpc.Server
 	g                  *g.Go
+	keyed              *g.KeyedContext
 	dispatcher         *timer.Dispatcher
 	client             *chanrpc.Client
 	server             *chanrpc.Server
@@ -30,8 +33,14 @@ func (s *Skeleton) Init() {
 	if s.AsynCallLen <= 0 {
 		s.AsynCallLen = 0
 	}
+	if s.KeyedGoNum <= 0 {
+		s.KeyedGoNum = 0
+	}
 
 	s.g = g.New(s.GoLen)
+	if s.GoLen > 0 && s.KeyedGoNum > 0 {
+		s.keyed = s.g.NewKeyedContext(s.KeyedGoNum)
+	}
 	s.dispatcher = timer.NewDispatcher(s.TimerDispatcherLen)
 	s.client = chanrpc.NewClient(s.AsynCallLen)
 	s.server = s.ChanRPCServer
@@ -83,6 +92,20 @@ func (s *Skeleton) CronFunc(cronExpr *timer.CronExpr, cb func()) *timer.Cron {
 	return s.dispatcher.CronFunc(cronExpr, cb)
 }
 
+// NewScheduler runs the store calls of the scheduler in order off the
+// skeleton goroutine if GoLen is not 0
+func (s *Skeleton) NewScheduler(store schedule.Store) *schedule.Scheduler {
+	if s.TimerDispatcherLen == 0 {
+		panic("invalid TimerDispatcherLen")
+	}
+
+	sch := schedule.New(s.dispatcher, store)
+	if s.GoLen > 0 {
+		sch.Go = s.g.NewLinearContext().Go
+	}
+	return sch
+}
+
 func (s *Skeleton) Go(f func(), cb func()) {
 	if s.GoLen == 0 {
 		panic("invalid GoLen")
@@ -91,6 +114,36 @@ func (s *Skeleton) Go(f func(), cb func()) {
 	s.g.Go(f, cb)
 }
 
+// GoPool runs f on the named pool created by NewPool, cb runs on the
+// skeleton goroutine as with Go
+func (s *Skeleton) GoPool(name string, f func(), cb func()) error {
+	if s.GoLen == 0 {
+		panic("invalid GoLen")
+	}
+
+	p := s.g.Pool(name)
+	if p == nil {
+		panic("pool " + name + ": not found")
+	}
+	return p.Go(f, cb)
+}
+
+func (s *Skeleton) NewPool(name string, workers int, queueLen int) *g.Pool {
+	if s.GoLen == 0 {
+		panic("invalid GoLen")
+	}
+
+	return s.g.NewPool(name, workers, queueLen)
+}
+
+func (s *Skeleton) PoolStats() []g.PoolStats {
+	var stats []g.PoolStats
+	s.g.RangePools(func(p *g.Pool) {
+		stats = append(stats, p.Stats())
+	})
+	return stats
+}
+
 func (s *Skeleton) NewLinearContext() *g.LinearContext {
 	if s.GoLen == 0 {
 		panic("invalid GoLen")
@@ -99,6 +152,18 @@ func (s *Skeleton) NewLinearContext() *g.LinearContext {
 	return s.g.NewLinearContext()
 }
 
+// GoKeyed runs the calls of the same key in order, e.g. one key per player
+func (s *Skeleton) GoKeyed(key interface{}, f func(), cb func()) {
+	if s.GoLen == 0 {
+		panic("invalid GoLen")
+	}
+	if s.keyed == nil {
+		panic("invalid KeyedGoNum")
+	}
+
+	s.keyed.Go(key, f, cb)
+}
+
 func (s *Skeleton) AsynCall(server *chanrpc.Server, id interface{}, args ...interface{}) {
 	if s.AsynCallLen == 0 {
 		panic("invalid AsynCallLen")
@@ -0,0 +1,318 @@
+// Package capture records the frames of the agents to rotating capture
+// files, and replays them into a Processor or against a running server.
+//
+// A capture file is the magic "LCAP", a version byte and the records:
+//
+//	---------------------------------------------------------
+//	| time int64 | id uint32 | dir uint8 | len uint32 | data |
+//	---------------------------------------------------------
+//
+// in little endian. The data of In and Out is the frame without the length
+// prefix, which is the data of Processor.Unmarshal, the data of Open is the
+// remote address of the agent, the data of Negotiate is the magic byte of
+// the codec, 0 if selected by the subprotocol, and the name of the codec.
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	magic   = "LCAP"
+	version = 1
+
+	headerSize = 17
+)
+
+type Dir uint8
+
+const (
+	In        Dir = iota // from the client
+	Out                  // to the client
+	Open                 // the agent is connected
+	Close                // the agent is closed
+	Negotiate            // the agent selects a codec, see network.Codec
+)
+
+func (d Dir) String() string {
+	switch d {
+	case In:
+		return "in"
+	case Out:
+		return "out"
+	case Open:
+		return "open"
+	case Close:
+		return "close"
+	case Negotiate:
+		return "negotiate"
+	}
+	return fmt.Sprintf("dir(%d)", uint8(d))
+}
+
+type Record struct {
+	// unix nano
+	Time int64
+	// the agent id of the Recorder
+	ID   uint32
+	Dir  Dir
+	Data []byte
+}
+
+var errClosed = errors.New("capture: recorder closed")
+
+// Recorder writes the records to the files in Dir, a new file is created
+// when the file is larger than MaxSize and the oldest files are removed
+// when there are more than MaxFiles, 0 for no limit.
+// The records are written by a goroutine, a record is dropped if the queue
+// is full, so that the agents are never blocked by the disk.
+// goroutine safe
+type Recorder struct {
+	Dir      string
+	MaxSize  int64
+	MaxFiles int
+
+	mu      sync.RWMutex
+	closed  bool
+	queue   chan *[]byte
+	flush   chan chan error
+	done    chan struct{}
+	lastID  uint32
+	dropped uint64
+
+	// of the goroutine
+	f     *os.File
+	w     *bufio.Writer
+	size  int64
+	seq   int
+	names []string
+	err   error
+}
+
+const (
+	queueLen = 4096
+
+	// the buffered records are flushed after this, or by the Close record
+	// of an agent
+	flushInterval = time.Second
+)
+
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 256)
+		return &b
+	},
+}
+
+func NewRecorder(dir string, maxSize int64, maxFiles int) (*Recorder, error) {
+	if maxSize <= 0 {
+		maxSize = 64 * 1024 * 1024
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	// the files of the last runs count for MaxFiles
+	names, err := filepath.Glob(filepath.Join(dir, "*.cap"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	r := &Recorder{
+		Dir:      dir,
+		MaxSize:  maxSize,
+		MaxFiles: maxFiles,
+		queue:    make(chan *[]byte, queueLen),
+		flush:    make(chan chan error),
+		done:     make(chan struct{}),
+		names:    names,
+	}
+	if err := r.rotate(); err != nil {
+		return nil, err
+	}
+	go r.run()
+	return r, nil
+}
+
+// NewID returns a new agent id
+func (r *Recorder) NewID() uint32 {
+	return atomic.AddUint32(&r.lastID, 1)
+}
+
+// Dropped returns the number of the records dropped for a full queue
+func (r *Recorder) Dropped() uint64 {
+	return atomic.LoadUint64(&r.dropped)
+}
+
+// Write queues a record of the data joined, the data can be reused after
+// it returns
+func (r *Recorder) Write(id uint32, dir Dir, data ...[]byte) error {
+	n := 0
+	for _, b := range data {
+		n += len(b)
+	}
+	rec := bufPool.Get().(*[]byte)
+	var h [headerSize]byte
+	binary.LittleEndian.PutUint64(h[0:], uint64(time.Now().UnixNano()))
+	binary.LittleEndian.PutUint32(h[8:], id)
+	h[12] = byte(dir)
+	binary.LittleEndian.PutUint32(h[13:], uint32(n))
+	*rec = append((*rec)[:0], h[:]...)
+	for _, b := range data {
+		*rec = append(*rec, b...)
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return errClosed
+	}
+	select {
+	case r.queue <- rec:
+	default:
+		atomic.AddUint64(&r.dropped, 1)
+	}
+	return nil
+}
+
+// Flush writes the queued records to the file
+func (r *Recorder) Flush() error {
+	r.mu.RLock()
+	if r.closed {
+		r.mu.RUnlock()
+		return errClosed
+	}
+	c := make(chan error, 1)
+	r.flush <- c
+	r.mu.RUnlock()
+	return <-c
+}
+
+// Close writes the queued records and closes the file
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	close(r.queue)
+	r.mu.Unlock()
+
+	<-r.done
+	err := r.closeFile()
+	if r.err != nil {
+		err = r.err
+	}
+	return err
+}
+
+func (r *Recorder) run() {
+	defer close(r.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var dropped uint64
+	for {
+		select {
+		case rec, ok := <-r.queue:
+			if !ok {
+				return
+			}
+			r.write(rec)
+		case c := <-r.flush:
+			// the records queued before
+			for n := len(r.queue); n > 0; n-- {
+				r.write(<-r.queue)
+			}
+			c <- r.fail(r.w.Flush())
+		case <-ticker.C:
+			r.fail(r.w.Flush())
+			if d := r.Dropped(); d != dropped {
+				log.Warnf("capture: %v records dropped", d-dropped)
+				dropped = d
+			}
+		}
+	}
+}
+
+func (r *Recorder) write(b *[]byte) {
+	defer bufPool.Put(b)
+	rec := *b
+	if r.f == nil || r.size >= r.MaxSize {
+		if r.fail(r.rotate()) != nil {
+			return
+		}
+	}
+	r.w.Write(rec)
+	r.size += int64(len(rec))
+	if Dir(rec[12]) == Close {
+		r.fail(r.w.Flush())
+	}
+}
+
+// fail logs err if it is not the last error, the file stays broken until
+// the next rotation
+func (r *Recorder) fail(err error) error {
+	if err != nil && (r.err == nil || err.Error() != r.err.Error()) {
+		log.Errorf("capture error: %v", err)
+	}
+	r.err = err
+	return err
+}
+
+func (r *Recorder) closeFile() error {
+	if r.f == nil {
+		return nil
+	}
+	err := r.w.Flush()
+	if err2 := r.f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// rotate closes the file and creates a new one, the files are named by the
+// time and sorted by the names
+func (r *Recorder) rotate() error {
+	err := r.closeFile()
+	r.f = nil
+	if err != nil {
+		return err
+	}
+	r.seq++
+	name := filepath.Join(r.Dir, fmt.Sprintf("%v-%04d.cap", time.Now().Format("20060102-150405.000000"), r.seq))
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	r.f = f
+	if r.w == nil {
+		r.w = bufio.NewWriterSize(f, 64*1024)
+	} else {
+		r.w.Reset(f)
+	}
+	r.w.WriteString(magic)
+	r.w.WriteByte(version)
+	r.size = int64(len(magic) + 1)
+
+	r.names = append(r.names, name)
+	for r.MaxFiles > 0 && len(r.names) > r.MaxFiles {
+		if err := os.Remove(r.names[0]); err != nil && !os.IsNotExist(err) {
+			log.Errorf("capture error: %v", err)
+		}
+		r.names = r.names[1:]
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package capture_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/CreFire/leaf/network"
+	"github.com/CreFire/leaf/network/capture"
+	"github.com/CreFire/leaf/network/cstruct"
+	"github.com/CreFire/leaf/network/json"
+)
+
+type Hello struct {
+	Name string
+}
+
+func Example() {
+	dir, err := os.MkdirTemp("", "capture")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	p := cstruct.NewProcessor()
+	p.Register(1, 1, &Hello{})
+	p.SetHandler(1, 1, func(args []interface{}) {
+		fmt.Println("agent", args[1], "hello", args[0].(*cstruct.RecvMsg).Msg.(*Hello).Name)
+	})
+	jp := json.NewProcessor()
+	jp.Register(1, 1, &Hello{})
+	jp.SetHandler(1, 1, func(args []interface{}) {
+		fmt.Println("agent", args[1], "json hello", args[0].(*network.Envelope).Msg.(*Hello).Name)
+	})
+	codecs := []network.Codec{{Name: "json", Magic: 'J', Processor: jp}}
+
+	// the gate writes the frames of the agents
+	r, err := capture.NewRecorder(dir, 0, 0)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	id := r.NewID()
+	data, _ := p.Marshal(cstruct.DefaultRecvMsg, 1, 1, &Hello{Name: "leaf"})
+	r.Write(id, capture.Open, []byte("127.0.0.1:1234"))
+	r.Write(id, capture.In, data...)
+	r.Write(id, capture.Close)
+
+	// an agent which selects the json codec by the magic frame
+	id = r.NewID()
+	r.Write(id, capture.Open, []byte("127.0.0.1:1235"))
+	r.Write(id, capture.Negotiate, []byte{'J'}, []byte("json"))
+	r.Write(id, capture.In, []byte(`{"mainCmdId":1,"subCmdId":1,"body":{"Name":"web"}}`))
+	r.Write(id, capture.Close)
+	r.Close()
+
+	names, _ := filepath.Glob(filepath.Join(dir, "*.cap"))
+	rd, err := capture.OpenFiles(names...)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rd.Close()
+	err = capture.Feed(rd, p, codecs, 0, func(id uint32) interface{} {
+		return id
+	})
+	fmt.Println(err)
+
+	// Output:
+	// agent 1 hello leaf
+	// agent 2 json hello web
+	// <nil>
+}
+
+func ExampleRecorder_maxFiles() {
+	dir, err := os.MkdirTemp("", "capture")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	// a new file every 64 bytes, 2 files kept
+	r, err := capture.NewRecorder(dir, 64, 2)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	id := r.NewID()
+	for i := 0; i < 10; i++ {
+		r.Write(id, capture.In, make([]byte, 50))
+	}
+	fmt.Println(r.Close(), r.Dropped())
+
+	names, _ := filepath.Glob(filepath.Join(dir, "*.cap"))
+	rd, err := capture.OpenFiles(names...)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer rd.Close()
+	n := 0
+	capture.Replay(rd, 0, func(rec *capture.Record) error {
+		n++
+		return nil
+	})
+	fmt.Println(len(names), n)
+
+	// Output:
+	// <nil> 0
+	// 2 2
+}
@@ -0,0 +1,99 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// larger records are corrupted
+const maxDataLen = 64 * 1024 * 1024
+
+// Reader reads the records of capture files in order
+type Reader struct {
+	names []string
+	f     *os.File
+	r     *bufio.Reader
+	name  string
+}
+
+// OpenFiles opens the capture files, the rotated files of a Recorder are in the
+// order of the names
+func OpenFiles(names ...string) (*Reader, error) {
+	r := &Reader{names: names}
+	if err := r.next(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Reader) next() error {
+	if len(r.names) == 0 {
+		return io.EOF
+	}
+	f, err := os.Open(r.names[0])
+	if err != nil {
+		return err
+	}
+	r.name = r.names[0]
+	r.names = r.names[1:]
+
+	br := bufio.NewReader(f)
+	var h [len(magic) + 1]byte
+	if _, err := io.ReadFull(br, h[:]); err != nil || string(h[:len(magic)]) != magic {
+		f.Close()
+		return fmt.Errorf("%v: not a capture file", r.name)
+	}
+	if h[len(magic)] != version {
+		f.Close()
+		return fmt.Errorf("%v: unknown version %v", r.name, h[len(magic)])
+	}
+	r.f = f
+	r.r = br
+	return nil
+}
+
+// Read returns the next record, io.EOF at the end of the files. A record
+// cut at the end of a file, e.g. the server crashed, is skipped.
+func (r *Reader) Read() (*Record, error) {
+	for r.f != nil {
+		var h [headerSize]byte
+		_, err := io.ReadFull(r.r, h[:])
+		if err == nil && binary.LittleEndian.Uint32(h[13:]) > maxDataLen {
+			return nil, fmt.Errorf("%v: corrupted record", r.name)
+		}
+		if err == nil {
+			rec := &Record{
+				Time: int64(binary.LittleEndian.Uint64(h[0:])),
+				ID:   binary.LittleEndian.Uint32(h[8:]),
+				Dir:  Dir(h[12]),
+				Data: make([]byte, binary.LittleEndian.Uint32(h[13:])),
+			}
+			if _, err = io.ReadFull(r.r, rec.Data); err == nil {
+				return rec, nil
+			}
+		}
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("%v: %v", r.name, err)
+		}
+
+		r.f.Close()
+		r.f = nil
+		if err := r.next(); err != nil {
+			return nil, err
+		}
+	}
+	return nil, io.EOF
+}
+
+func (r *Reader) Close() error {
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	r.names = nil
+	return err
+}
@@ -0,0 +1,207 @@
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/CreFire/leaf/network"
+)
+
+// Replay calls f with the records of r in order. The records are paced by
+// their relative time divided by speed, e.g. 1 for the real time and 2 for
+// twice as fast, or as fast as possible if speed is 0.
+func Replay(r *Reader, speed float64, f func(rec *Record) error) error {
+	var first int64
+	var start time.Time
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if speed > 0 {
+			if start.IsZero() {
+				first, start = rec.Time, time.Now()
+			} else if d := time.Duration(float64(rec.Time-first)/speed) - time.Since(start); d > 0 {
+				time.Sleep(d)
+			}
+		}
+		if err := f(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// Feed unmarshals and routes the In frames of r with p, or with the codec
+// of the Negotiate record of the agent, agent returns the userData of Route
+// for an agent id of the capture, e.g. a fake gate.Agent.
+// It stops at the first error.
+func Feed(r *Reader, p network.Processor, codecs []network.Codec, speed float64, agent func(id uint32) interface{}) error {
+	negotiated := make(map[uint32]network.Processor)
+	return Replay(r, speed, func(rec *Record) error {
+		var err error
+		switch rec.Dir {
+		case Negotiate:
+			var c *network.Codec
+			if c, err = findCodec(codecs, rec.Data); err == nil {
+				negotiated[rec.ID] = c.Processor
+			}
+		case Close:
+			delete(negotiated, rec.ID)
+		case In:
+			p := p
+			if np, ok := negotiated[rec.ID]; ok {
+				p = np
+			}
+			var msg *network.Envelope
+			if msg, err = p.Unmarshal(rec.Data); err == nil {
+				err = p.Route(msg, agent(rec.ID))
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("agent %v at %v: %v", rec.ID, time.Unix(0, rec.Time).Format(time.RFC3339Nano), err)
+		}
+		return nil
+	})
+}
+
+// findCodec returns the codec of the data of a Negotiate record, by the
+// name or by the magic byte if the name is empty
+func findCodec(codecs []network.Codec, data []byte) (*network.Codec, error) {
+	if len(data) == 0 {
+		return nil, errors.New("bad negotiate record")
+	}
+	magic, name := data[0], string(data[1:])
+	for i := range codecs {
+		if name != "" && codecs[i].Name == name || name == "" && codecs[i].Magic == magic {
+			return &codecs[i], nil
+		}
+	}
+	return nil, fmt.Errorf("codec %q magic %#x not found", name, magic)
+}
+
+// Client replays the In frames of the agents against a running server, a
+// TCPClient is connected for every agent of the capture
+type Client struct {
+	Addr         string
+	LenMsgLen    int
+	MaxMsgLen    int32
+	LittleEndian bool
+	DialTimeout  time.Duration
+	// OnMsg is called with the frames from the server, in the goroutine of
+	// the connection, nil to drop them
+	OnMsg func(id uint32, data []byte)
+}
+
+type replayConn struct {
+	client *network.TCPClient
+	conn   *network.TCPConn
+	done   chan struct{}
+}
+
+type replayAgent struct {
+	id    uint32
+	conn  *network.TCPConn
+	onMsg func(id uint32, data []byte)
+	done  chan struct{}
+}
+
+func (a *replayAgent) Run() {
+	defer close(a.done)
+	for {
+		data, err := a.conn.ReadMsg()
+		if err != nil {
+			return
+		}
+		if a.onMsg != nil {
+			a.onMsg(a.id, data)
+		}
+	}
+}
+
+func (a *replayAgent) OnClose() {}
+
+// Replay connects for the Open records, writes the In frames and the magic
+// frames of the Negotiate records and closes for the Close records. The
+// agents still connected are closed at the end, the codecs selected by the
+// subprotocol are not replayed.
+func (c *Client) Replay(r *Reader, speed float64) error {
+	conns := make(map[uint32]*replayConn)
+	defer func() {
+		for _, rc := range conns {
+			c.close(rc)
+		}
+	}()
+
+	return Replay(r, speed, func(rec *Record) error {
+		rc := conns[rec.ID]
+		switch rec.Dir {
+		case Open, In, Negotiate:
+			if rc == nil {
+				// the Open record may be in a file before the capture
+				var err error
+				if rc, err = c.dial(rec.ID); err != nil {
+					return err
+				}
+				conns[rec.ID] = rc
+			}
+			if rec.Dir == In {
+				return rc.conn.WriteMsg(rec.Data)
+			}
+			if rec.Dir == Negotiate && len(rec.Data) > 0 && rec.Data[0] != 0 {
+				return rc.conn.WriteMsg(rec.Data[:1])
+			}
+		case Close:
+			if rc != nil {
+				delete(conns, rec.ID)
+				c.close(rc)
+			}
+		}
+		return nil
+	})
+}
+
+func (c *Client) dial(id uint32) (*replayConn, error) {
+	timeout := c.DialTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	connected := make(chan *replayAgent, 1)
+	client := &network.TCPClient{
+		Addr:            c.Addr,
+		ConnNum:         1,
+		ConnectInterval: time.Second,
+		LenMsgLen:       c.LenMsgLen,
+		MaxMsgLen:       c.MaxMsgLen,
+		LittleEndian:    c.LittleEndian,
+		NewAgent: func(conn *network.TCPConn) network.Agent {
+			a := &replayAgent{id: id, conn: conn, onMsg: c.OnMsg, done: make(chan struct{})}
+			connected <- a
+			return a
+		},
+	}
+	client.Start()
+
+	select {
+	case a := <-connected:
+		return &replayConn{client: client, conn: a.conn, done: a.done}, nil
+	case <-time.After(timeout):
+		go client.Close()
+		return nil, fmt.Errorf("agent %v: connect to %v timeout", id, c.Addr)
+	}
+}
+
+// close closes rc after the pending frames are written
+func (c *Client) close(rc *replayConn) {
+	rc.conn.Close()
+	select {
+	case <-rc.done:
+	case <-time.After(time.Second):
+	}
+	rc.client.Close()
+}
@@ -12,3 +12,12 @@ type Conn interface {
 	Close()
 	Destroy()
 }
+
+// AppendFunc appends a message to dst
+type AppendFunc func(dst []byte) ([]byte, error)
+
+// AppendConn is implemented by the conns which write the message appended by
+// f in one pooled buffer, TCPConn and WSConn
+type AppendConn interface {
+	WriteMsgAppend(f AppendFunc) error
+}
@@ -5,30 +5,30 @@ import (
 	"errors"
 	"fmt"
 	"github.com/CreFire/leaf/util/cstruct-go"
+	"github.com/CreFire/leaf/util/cstruct-go/schema"
 	"google.golang.org/protobuf/proto"
 	"math"
 
 	"reflect"
+	"sort"
+	"strings"
 
 	"github.com/CreFire/leaf/chanrpc"
+	"github.com/CreFire/leaf/network"
 	log "github.com/sirupsen/logrus"
 )
 
 const (
-	MSG_TYPE_NONE uint8 = 0    // 默认的一般消息类型
-	MSG_TYPE_RPC  uint8 = 0x01 // rpc
+	MSG_TYPE_NONE uint8 = network.MsgTypeNone // 默认的一般消息类型
+	MSG_TYPE_RPC  uint8 = network.MsgTypeRPC  // rpc
 	// MSG_TYPE_NONE uint8 = 0x02 //
 	// MSG_TYPE_NONE uint8 = 0x04 //
 	// MSG_TYPE_NONE uint8 = 0x08 //
 	// MSG_TYPE_NONE uint8 = 0x10 //
 )
 
-type RecvMsg struct {
-	RpcCallId uint32
-	MsgId     uint32
-	Msg       interface{}
-	MsgType   uint8
-}
+// RecvMsg is the envelope of the processors
+type RecvMsg = network.Envelope
 
 var DefaultRecvMsg = &RecvMsg{0, 0, nil, MSG_TYPE_NONE}
 
@@ -45,13 +45,11 @@ func FlagGet(value uint8, flag uint8) bool {
 }
 
 func MakeDWORD(mainCmdID uint16, subCmdID uint16) uint32 {
-	return uint32(mainCmdID) | uint32(subCmdID)<<16
+	return network.MsgID(mainCmdID, subCmdID)
 }
 
 func GetCmd(CmdID uint32) (mainCmdID, subCmdID uint16) {
-	mainCmdID = uint16(CmdID)
-	subCmdID = uint16(CmdID >> 16)
-	return
+	return network.Cmd(CmdID)
 }
 
 // -------------------------
@@ -64,6 +62,11 @@ type Processor struct {
 	// msgType      map[uint32]reflect.Type
 }
 
+var (
+	_ network.Processor       = (*Processor)(nil)
+	_ network.AppendProcessor = (*Processor)(nil)
+)
+
 type MsgInfo struct {
 	msgType       reflect.Type
 	msgRouter     *chanrpc.Server
@@ -268,6 +271,9 @@ func (p *Processor) Unmarshal(data []byte) (*RecvMsg, error) {
 			if err != nil {
 				mainCmdID, subCmdID := GetCmd(id)
 				log.Error("Unmarshal id [%v,%v] message %v error: %v", mainCmdID, subCmdID, reflect.TypeOf(msg), err)
+				if log.IsLevelEnabled(log.DebugLevel) {
+					log.Debugf("Unmarshal dump: %v", p.Dump(data, 0))
+				}
 			}
 			return &RecvMsg{rpcCallId, id, msg, msgType}, err
 		} else {
@@ -310,87 +316,90 @@ func (p *Processor) UnmarshalBody(mainCmdID uint16, subCmdID uint16, data []byte
 func (p *Processor) Marshal(recv *RecvMsg, mainCmdID uint16, subCmdID uint16, msg interface{}) ([][]byte, error) {
 	var id uint32 = MakeDWORD(mainCmdID, subCmdID)
 
-	var header []byte
-
-	if !FlagGet(recv.MsgType, MSG_TYPE_RPC) {
-		header = make([]byte, 5)
-		header[0] = recv.MsgType
-		if p.littleEndian {
-			binary.LittleEndian.PutUint32(header[1:], id)
-		} else {
-			binary.BigEndian.PutUint32(header[1:], id)
-		}
-	} else {
-		if recv.RpcCallId == 0 {
-			log.Error("Marshal error: msgType[%d]!=0 id but rpcCallId[%d]==0 message [%d,%d] ", recv.MsgType, recv.RpcCallId, mainCmdID, subCmdID)
-		}
-		// RPC消息
-		header = make([]byte, 9)
-		header[0] = recv.MsgType
-		if p.littleEndian {
-			binary.LittleEndian.PutUint32(header[1:], id)
-			binary.LittleEndian.PutUint32(header[5:], recv.RpcCallId)
-		} else {
-			binary.BigEndian.PutUint32(header[1:], id)
-			binary.BigEndian.PutUint32(header[5:], recv.RpcCallId)
-		}
+	if FlagGet(recv.MsgType, MSG_TYPE_RPC) && recv.RpcCallId == 0 {
+		log.Errorf("Marshal error: msgType[%d]!=0 id but rpcCallId[%d]==0 message [%d,%d] ", recv.MsgType, recv.RpcCallId, mainCmdID, subCmdID)
 	}
+	header := p.appendHeader(make([]byte, 0, 9), recv, id)
 
 	// data
 	if nil == msg {
 		return [][]byte{header}, nil
 	}
 
-	i := p.msgInfo[id]
-
 	var err error
 	var body []byte
-	if nil != i {
-		if i.bProtoBuf {
-			body, err = proto.Marshal(msg.(proto.Message))
-		} else {
-			body, err = cstruct.Marshal(msg)
-		}
+	if p.isProtoBuf(id, msg) {
+		body, err = proto.Marshal(msg.(proto.Message))
 	} else {
-		_, ok := msg.(proto.Message)
-		if ok {
-			body, err = proto.Marshal(msg.(proto.Message))
-		} else {
-			body, err = cstruct.Marshal(msg)
-		}
+		body, err = cstruct.Marshal(msg)
 	}
 	if err != nil {
-		log.Error("Marshal %v error: %v", reflect.TypeOf(msg), err)
+		log.Errorf("Marshal %v error: %v", reflect.TypeOf(msg), err)
 	}
 	return [][]byte{header, body}, err
 }
-func (p *Processor) MarshalCmd(recv *RecvMsg, mainCmdID uint16, subCmdID uint16) ([]byte, error) {
+
+// goroutine safe
+// AppendMarshal appends the header and body of Marshal to dst
+func (p *Processor) AppendMarshal(dst []byte, recv *RecvMsg, mainCmdID uint16, subCmdID uint16, msg interface{}) ([]byte, error) {
 	var id uint32 = MakeDWORD(mainCmdID, subCmdID)
 
-	var header []byte
+	if FlagGet(recv.MsgType, MSG_TYPE_RPC) && recv.RpcCallId == 0 {
+		log.Errorf("Marshal error: msgType[%d]!=0 id but rpcCallId[%d]==0 message [%d,%d] ", recv.MsgType, recv.RpcCallId, mainCmdID, subCmdID)
+	}
+	dst = p.appendHeader(dst, recv, id)
 
-	if !FlagGet(recv.MsgType, MSG_TYPE_RPC) {
-		header = make([]byte, 5)
-		header[0] = recv.MsgType
-		if p.littleEndian {
-			binary.LittleEndian.PutUint32(header[1:], id)
-		} else {
-			binary.BigEndian.PutUint32(header[1:], id)
-		}
+	// data
+	if nil == msg {
+		return dst, nil
+	}
+
+	var err error
+	if p.isProtoBuf(id, msg) {
+		dst, err = proto.MarshalOptions{}.MarshalAppend(dst, msg.(proto.Message))
 	} else {
+		dst, err = cstruct.AppendMarshal(dst, msg)
+	}
+	if err != nil {
+		log.Errorf("Marshal %v error: %v", reflect.TypeOf(msg), err)
+	}
+	return dst, err
+}
+
+// | msgType | id | rpcCallId, only of the rpc messages |
+func (p *Processor) appendHeader(dst []byte, recv *RecvMsg, id uint32) []byte {
+	var header [9]byte
+	n := 5
+	header[0] = recv.MsgType
+	if p.littleEndian {
+		binary.LittleEndian.PutUint32(header[1:], id)
+	} else {
+		binary.BigEndian.PutUint32(header[1:], id)
+	}
+	if FlagGet(recv.MsgType, MSG_TYPE_RPC) {
 		// RPC消息
-		header = make([]byte, 9)
-		header[0] = recv.MsgType
 		if p.littleEndian {
-			binary.LittleEndian.PutUint32(header[1:], id)
 			binary.LittleEndian.PutUint32(header[5:], recv.RpcCallId)
 		} else {
-			binary.BigEndian.PutUint32(header[1:], id)
 			binary.BigEndian.PutUint32(header[5:], recv.RpcCallId)
 		}
+		n = 9
+	}
+	return append(dst, header[:n]...)
+}
+
+// the registered messages use the registered kind
+func (p *Processor) isProtoBuf(id uint32, msg interface{}) bool {
+	if i := p.msgInfo[id]; i != nil {
+		return i.bProtoBuf
 	}
+	_, ok := msg.(proto.Message)
+	return ok
+}
+func (p *Processor) MarshalCmd(recv *RecvMsg, mainCmdID uint16, subCmdID uint16) ([]byte, error) {
+	var id uint32 = MakeDWORD(mainCmdID, subCmdID)
 
-	return header, nil
+	return p.appendHeader(make([]byte, 0, 9), recv, id), nil
 }
 func (p *Processor) MarshalBody(msg interface{}) ([]byte, error) {
 	var err error
@@ -414,6 +423,64 @@ func (p *Processor) Range(f func(id uint16, t reflect.Type)) {
 	}
 }
 
+// goroutine safe
+// RangeCmd calls f for the registered messages in the order of the ids, t is
+// nil for the messages without a body
+func (p *Processor) RangeCmd(f func(mainCmdID uint16, subCmdID uint16, t reflect.Type, protoBuf bool)) {
+	ids := make([]uint32, 0, len(p.msgInfo))
+	for id := range p.msgInfo {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		mainCmdID, subCmdID := GetCmd(id)
+		i := p.msgInfo[id]
+		f(mainCmdID, subCmdID, i.msgType, i.bProtoBuf)
+	}
+}
+
+// Schema exports the registered cstruct messages, the protobuf messages and
+// the messages without a body are skipped
+func (p *Processor) Schema() (*schema.Schema, error) {
+	s := schema.New()
+	var err error
+	p.RangeCmd(func(mainCmdID uint16, subCmdID uint16, t reflect.Type, protoBuf bool) {
+		if err != nil || t == nil || protoBuf {
+			return
+		}
+		err = s.Add(mainCmdID, subCmdID, t)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// CheckSchema compares the registered messages with the baseline schema
+// file, which is written by Schema. It returns an error of the breaking
+// changes, e.g. in a test to gate the merges.
+func (p *Processor) CheckSchema(baseline string) error {
+	base, err := schema.Read(baseline)
+	if err != nil {
+		return err
+	}
+	s, err := p.Schema()
+	if err != nil {
+		return err
+	}
+	changes := schema.Breaking(schema.Compare(base, s))
+	if len(changes) == 0 {
+		return nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v breaking changes of %v:", len(changes), baseline)
+	for _, c := range changes {
+		b.WriteString("\n\t")
+		b.WriteString(c.String())
+	}
+	return errors.New(b.String())
+}
+
 func (p *Processor) Cmd2Bytes(mainCmdID uint16, subCmdID uint16) []byte {
 	var id uint32 = MakeDWORD(mainCmdID, subCmdID)
 	cmd := make([]byte, 4)
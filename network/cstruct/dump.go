@@ -0,0 +1,227 @@
+package cstruct
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/CreFire/leaf/util/cstruct-go"
+	"github.com/CreFire/leaf/util/cstruct-go/schema"
+	"google.golang.org/protobuf/proto"
+)
+
+// Dump is a frame decoded for debugging
+type Dump struct {
+	// the length prefix, 0 if the frame has none
+	Len       int    `json:"len,omitempty"`
+	MsgType   uint8  `json:"msgType"`
+	MainCmdID uint16 `json:"mainCmdId"`
+	SubCmdID  uint16 `json:"subCmdId"`
+	RpcCallId uint32 `json:"rpcCallId,omitempty"`
+	Type      string `json:"type,omitempty"`
+	// the offset of the body in the frame
+	Offset int `json:"offset"`
+	// the decoded body, the fields before the error if it fails
+	Body interface{} `json:"body,omitempty"`
+	// the body bytes which are not decoded
+	Hex string `json:"hex,omitempty"`
+	// the bytes after the body
+	Trailing    int    `json:"trailing,omitempty"`
+	Error       string `json:"error,omitempty"`
+	ErrorOffset int    `json:"errorOffset,omitempty"`
+	// e.g. the length prefix is not the size of the frame
+	Warning string `json:"warning,omitempty"`
+}
+
+// String returns the indented JSON of d
+func (d *Dump) String() string {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return fmt.Sprintf("%+v", *d)
+	}
+	return string(data)
+}
+
+func (d *Dump) fail(offset int, err error) *Dump {
+	d.Error = err.Error()
+	d.ErrorOffset = offset
+	return d
+}
+
+// Dump decodes the frame data with the registered messages, lenMsgLen is
+// the size of the length prefix of data, 0 if data has no length prefix.
+// It never fails, the error is in the Dump.
+// goroutine safe after the messages are registered
+func (p *Processor) Dump(data []byte, lenMsgLen int) *Dump {
+	d, body := dumpHeader(data, lenMsgLen, p.littleEndian)
+	if d.Error != "" {
+		return d
+	}
+
+	i, ok := p.msgInfo[MakeDWORD(d.MainCmdID, d.SubCmdID)]
+	if !ok {
+		d.Hex = hex.EncodeToString(body)
+		return d.fail(d.Offset, fmt.Errorf("message id [%v,%v] not registered", d.MainCmdID, d.SubCmdID))
+	}
+	if i.msgType == nil {
+		d.Trailing = len(body)
+		return d
+	}
+	d.Type = i.msgType.Elem().String()
+
+	msg := reflect.New(i.msgType.Elem()).Interface()
+	d.Body = msg
+	n, err := unmarshalDump(body, msg, i.bProtoBuf)
+	if err != nil {
+		if i.bProtoBuf {
+			d.Body = nil
+			d.Hex = hex.EncodeToString(body)
+		}
+		return d.fail(d.Offset+n, err)
+	}
+	d.Trailing = len(body) - n
+	return d
+}
+
+// DumpSchema decodes the frame data with the schema exported by the server,
+// e.g. in the tools without the Go types of the messages.
+func DumpSchema(s *schema.Schema, data []byte, lenMsgLen int, littleEndian bool) *Dump {
+	d, body := dumpHeader(data, lenMsgLen, littleEndian)
+	if d.Error != "" {
+		return d
+	}
+
+	for _, m := range s.Messages {
+		if m.Main == d.MainCmdID && m.Sub == d.SubCmdID {
+			d.Type = m.Type
+			break
+		}
+	}
+	if d.Type == "" {
+		d.Hex = hex.EncodeToString(body)
+		return d.fail(d.Offset, fmt.Errorf("message id [%v,%v] not in the schema", d.MainCmdID, d.SubCmdID))
+	}
+
+	b := cstruct.NewBuffer(body)
+	o, err := s.Decode(d.Type, b)
+	if o != nil {
+		d.Body = o
+	}
+	if err != nil {
+		return d.fail(d.Offset+b.Index(), err)
+	}
+	d.Trailing = len(body) - b.Index()
+	return d
+}
+
+// dumpHeader decodes the length prefix, the msgType, the id and the rpc
+// call id of data, and returns the body
+func dumpHeader(data []byte, lenMsgLen int, littleEndian bool) (*Dump, []byte) {
+	d := new(Dump)
+	var order binary.ByteOrder = binary.BigEndian
+	if littleEndian {
+		order = binary.LittleEndian
+	}
+
+	if lenMsgLen > 0 {
+		if len(data) < lenMsgLen {
+			return d.fail(0, fmt.Errorf("frame of %v bytes too short for the length", len(data))), nil
+		}
+		switch lenMsgLen {
+		case 1:
+			d.Len = int(data[0])
+		case 2:
+			d.Len = int(order.Uint16(data))
+		case 4:
+			d.Len = int(order.Uint32(data))
+		default:
+			return d.fail(0, fmt.Errorf("invalid length size %v", lenMsgLen)), nil
+		}
+		data = data[lenMsgLen:]
+		if d.Len != len(data) {
+			// dumped anyway, the length is often the bug
+			d.Warning = fmt.Sprintf("length %v of %v bytes", d.Len, len(data))
+		}
+	}
+
+	off := lenMsgLen
+	if len(data) < 5 {
+		return d.fail(off, fmt.Errorf("frame of %v bytes too short for the header", len(data))), nil
+	}
+	d.MsgType = data[0]
+	d.MainCmdID, d.SubCmdID = GetCmd(order.Uint32(data[1:]))
+	idx := 5
+	if FlagGet(d.MsgType, MSG_TYPE_RPC) {
+		if len(data) < 9 {
+			return d.fail(off+idx, fmt.Errorf("frame of %v bytes too short for the rpc call id", len(data))), nil
+		}
+		d.RpcCallId = order.Uint32(data[idx:])
+		idx += 4
+	}
+	d.Offset = off + idx
+	return d, data[idx:]
+}
+
+// unmarshalDump returns the offset where the decoding stopped on error, or the
+// bytes decoded
+func unmarshalDump(body []byte, msg interface{}, protoBuf bool) (n int, err error) {
+	if protoBuf {
+		if err := proto.Unmarshal(body, msg.(proto.Message)); err != nil {
+			return 0, err
+		}
+		return len(body), nil
+	}
+	b := cstruct.NewBuffer(body)
+	defer func() {
+		if r := recover(); r != nil {
+			n, err = b.Index(), fmt.Errorf("%v", r)
+		}
+	}()
+	err = b.Unmarshal(msg)
+	return b.Index(), err
+}
+
+// DumpCommand is a console command which dumps a hex frame, e.g.
+//
+//	console.RegisterFunc("dump", "dump a cstruct frame", processor.DumpCommand)
+//
+// Usage: dump [-len 0|1|2|4] hex
+func (p *Processor) DumpCommand(args []string) string {
+	lenMsgLen := 2
+	if len(args) >= 2 && args[0] == "-len" {
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			return err.Error()
+		}
+		lenMsgLen = n
+		args = args[2:]
+	}
+	if len(args) == 0 {
+		return "Usage: dump [-len 0|1|2|4] hex\r\n" +
+			"  -len - the size of the length prefix, default 2"
+	}
+	data, err := ParseHex(strings.Join(args, " "))
+	if err != nil {
+		return err.Error()
+	}
+	return strings.ReplaceAll(p.Dump(data, lenMsgLen).String(), "\n", "\r\n")
+}
+
+// ParseHex parses the hex of a frame in the logs, the spaces, commas and
+// 0x prefixes are ignored, e.g. "0x0a, 0x00" or "0a 00"
+func ParseHex(s string) ([]byte, error) {
+	s = strings.ReplaceAll(s, "0x", "")
+	s = strings.ReplaceAll(s, "0X", "")
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\r', '\n', ',':
+			return -1
+		}
+		return r
+	}, s)
+	return hex.DecodeString(s)
+}
@@ -0,0 +1,66 @@
+package cstruct_test
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/CreFire/leaf/network/cstruct"
+)
+
+type Pos struct {
+	X int16
+	Y int16
+}
+
+type Move struct {
+	Ver  uint8
+	Pos  Pos
+	Path []*Pos
+	Name string `ver:"1"`
+}
+
+func ExampleProcessor_Dump() {
+	p := cstruct.NewProcessor()
+	p.Register(1, 2, &Move{})
+
+	data, err := p.Marshal(cstruct.DefaultRecvMsg, 1, 2, &Move{Ver: 1, Pos: Pos{1, 2}, Path: []*Pos{{3, 4}}, Name: "bob"})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	frame := bytes.Join(data, nil)
+	fmt.Println(p.Dump(frame, 0))
+
+	// the name is cut, the length of it is read
+	d := p.Dump(frame[:len(frame)-2], 0)
+	fmt.Println(d.Error, d.ErrorOffset)
+
+	s, _ := p.Schema()
+	d = cstruct.DumpSchema(s, frame[:len(frame)-2], 0, true)
+	fmt.Println(d.Error, d.ErrorOffset)
+
+	// Output:
+	// {
+	//   "msgType": 0,
+	//   "mainCmdId": 1,
+	//   "subCmdId": 2,
+	//   "type": "cstruct_test.Move",
+	//   "offset": 5,
+	//   "body": {
+	//     "Ver": 1,
+	//     "Pos": {
+	//       "X": 1,
+	//       "Y": 2
+	//     },
+	//     "Path": [
+	//       {
+	//         "X": 3,
+	//         "Y": 4
+	//       }
+	//     ],
+	//     "Name": "bob"
+	//   }
+	// }
+	// unexpected EOF 18
+	// Move.Name: unexpected EOF 18
+}
@@ -0,0 +1,32 @@
+package network
+
+import "sync"
+
+// the frames larger than this are not kept by the pool
+const maxPooledFrame = 64 * 1024
+
+var framePool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 512)
+		return &b
+	},
+}
+
+// a message written by the writer goroutine of a conn, a pooled frame is
+// put back after written
+type frame struct {
+	b    []byte
+	pool *[]byte
+}
+
+func getFrame() *[]byte {
+	return framePool.Get().(*[]byte)
+}
+
+func (f frame) free() {
+	if f.pool == nil || cap(f.b) > maxPooledFrame {
+		return
+	}
+	*f.pool = f.b[:0]
+	framePool.Put(f.pool)
+}
@@ -0,0 +1,41 @@
+package json_test
+
+import (
+	"fmt"
+
+	"github.com/CreFire/leaf/network"
+	"github.com/CreFire/leaf/network/json"
+)
+
+type Hello struct {
+	Name string
+}
+
+func Example() {
+	p := json.NewProcessor()
+	p.Register(1, 2, &Hello{})
+	p.SetHandler(1, 2, func(args []interface{}) {
+		msg := args[0].(*network.Envelope)
+		fmt.Println("hello", msg.Msg.(*Hello).Name, msg.RpcCallId)
+	})
+
+	var processor network.Processor = p
+	msg, err := processor.Unmarshal([]byte(`{"mainCmdId":1,"subCmdId":2,"msgType":1,"rpcCallId":7,"body":{"Name":"leaf"}}`))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	processor.Route(msg, nil)
+
+	// the reply of the rpc call
+	data, err := processor.Marshal(msg, 1, 2, &Hello{Name: "client"})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(string(data[0]))
+
+	// Output:
+	// hello leaf 7
+	// {"mainCmdId":1,"subCmdId":2,"msgType":1,"rpcCallId":7,"body":{"Name":"client"}}
+}
@@ -5,14 +5,22 @@ import (
 	"errors"
 	"fmt"
 	"github.com/CreFire/leaf/chanrpc"
+	"github.com/CreFire/leaf/network"
 	log "github.com/sirupsen/logrus"
 	"reflect"
 )
 
+// --------------------------------------------------------------------
+// | {"mainCmdId":1,"subCmdId":2,"msgType":1,"rpcCallId":3,"body":{}} |
+// --------------------------------------------------------------------
+// msgType and rpcCallId are omitted if 0, body is omitted for the messages
+// registered without a type
 type Processor struct {
-	msgInfo map[string]*MsgInfo
+	msgInfo map[uint32]*MsgInfo
 }
 
+var _ network.Processor = (*Processor)(nil)
+
 type MsgInfo struct {
 	msgType       reflect.Type
 	msgRouter     *chanrpc.Server
@@ -23,83 +31,82 @@ type MsgInfo struct {
 type MsgHandler func([]interface{})
 
 type MsgRaw struct {
-	msgID      string
+	msgID      uint32
 	msgRawData json.RawMessage
 }
 
+type frame struct {
+	MainCmdID uint16          `json:"mainCmdId"`
+	SubCmdID  uint16          `json:"subCmdId"`
+	MsgType   uint8           `json:"msgType,omitempty"`
+	RpcCallId uint32          `json:"rpcCallId,omitempty"`
+	Body      json.RawMessage `json:"body,omitempty"`
+}
+
+type outFrame struct {
+	MainCmdID uint16      `json:"mainCmdId"`
+	SubCmdID  uint16      `json:"subCmdId"`
+	MsgType   uint8       `json:"msgType,omitempty"`
+	RpcCallId uint32      `json:"rpcCallId,omitempty"`
+	Body      interface{} `json:"body,omitempty"`
+}
+
 func NewProcessor() *Processor {
 	p := new(Processor)
-	p.msgInfo = make(map[string]*MsgInfo)
+	p.msgInfo = make(map[uint32]*MsgInfo)
 	return p
 }
 
 // It's dangerous to call the method on routing or marshaling (unmarshaling)
-func (p *Processor) Register(msg interface{}) string {
-	msgType := reflect.TypeOf(msg)
-	if msgType == nil || msgType.Kind() != reflect.Ptr {
-		log.Fatal("json message pointer required")
-	}
-	msgID := msgType.Elem().Name()
-	if msgID == "" {
-		log.Fatal("unnamed json message")
-	}
-	if _, ok := p.msgInfo[msgID]; ok {
-		log.Fatal("message %v is already registered", msgID)
+// msg is nil for the messages without a body
+func (p *Processor) Register(mainCmdID uint16, subCmdID uint16, msg interface{}) uint32 {
+	id := network.MsgID(mainCmdID, subCmdID)
+	if _, ok := p.msgInfo[id]; ok {
+		log.Fatalf("message %v,%v is already registered", mainCmdID, subCmdID)
 	}
 
 	i := new(MsgInfo)
-	i.msgType = msgType
-	p.msgInfo[msgID] = i
-	return msgID
+	if msg != nil {
+		msgType := reflect.TypeOf(msg)
+		if msgType.Kind() != reflect.Ptr {
+			log.Fatal("json message pointer required")
+		}
+		i.msgType = msgType
+	}
+	p.msgInfo[id] = i
+	return id
 }
 
-// It's dangerous to call the method on routing or marshaling (unmarshaling)
-func (p *Processor) SetRouter(msg interface{}, msgRouter *chanrpc.Server) {
-	msgType := reflect.TypeOf(msg)
-	if msgType == nil || msgType.Kind() != reflect.Ptr {
-		log.Fatal("json message pointer required")
-	}
-	msgID := msgType.Elem().Name()
-	i, ok := p.msgInfo[msgID]
+func (p *Processor) info(mainCmdID uint16, subCmdID uint16) *MsgInfo {
+	i, ok := p.msgInfo[network.MsgID(mainCmdID, subCmdID)]
 	if !ok {
-		log.Fatal("message %v not registered", msgID)
+		log.Fatalf("message %v,%v not registered", mainCmdID, subCmdID)
 	}
-
-	i.msgRouter = msgRouter
+	return i
 }
 
 // It's dangerous to call the method on routing or marshaling (unmarshaling)
-func (p *Processor) SetHandler(msg interface{}, msgHandler MsgHandler) {
-	msgType := reflect.TypeOf(msg)
-	if msgType == nil || msgType.Kind() != reflect.Ptr {
-		log.Fatal("json message pointer required")
-	}
-	msgID := msgType.Elem().Name()
-	i, ok := p.msgInfo[msgID]
-	if !ok {
-		log.Fatal("message %v not registered", msgID)
-	}
-
-	i.msgHandler = msgHandler
+func (p *Processor) SetRouter(mainCmdID uint16, subCmdID uint16, msgRouter *chanrpc.Server) {
+	p.info(mainCmdID, subCmdID).msgRouter = msgRouter
 }
 
 // It's dangerous to call the method on routing or marshaling (unmarshaling)
-func (p *Processor) SetRawHandler(msgID string, msgRawHandler MsgHandler) {
-	i, ok := p.msgInfo[msgID]
-	if !ok {
-		log.Fatal("message %v not registered", msgID)
-	}
+func (p *Processor) SetHandler(mainCmdID uint16, subCmdID uint16, msgHandler MsgHandler) {
+	p.info(mainCmdID, subCmdID).msgHandler = msgHandler
+}
 
-	i.msgRawHandler = msgRawHandler
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetRawHandler(mainCmdID uint16, subCmdID uint16, msgRawHandler MsgHandler) {
+	p.info(mainCmdID, subCmdID).msgRawHandler = msgRawHandler
 }
 
 // goroutine safe
-func (p *Processor) Route(msg interface{}, userData interface{}) error {
+func (p *Processor) Route(msg *network.Envelope, userData interface{}) error {
 	// raw
-	if msgRaw, ok := msg.(MsgRaw); ok {
+	if msgRaw, ok := msg.Msg.(MsgRaw); ok {
 		i, ok := p.msgInfo[msgRaw.msgID]
 		if !ok {
-			return fmt.Errorf("message %v not registered", msgRaw.msgID)
+			return fmt.Errorf("message id %v not registered", msgRaw.msgID)
 		}
 		if i.msgRawHandler != nil {
 			i.msgRawHandler([]interface{}{msgRaw.msgID, msgRaw.msgRawData, userData})
@@ -108,66 +115,66 @@ func (p *Processor) Route(msg interface{}, userData interface{}) error {
 	}
 
 	// json
-	msgType := reflect.TypeOf(msg)
-	if msgType == nil || msgType.Kind() != reflect.Ptr {
-		return errors.New("json message pointer required")
-	}
-	msgID := msgType.Elem().Name()
-	i, ok := p.msgInfo[msgID]
+	i, ok := p.msgInfo[msg.MsgId]
 	if !ok {
-		return fmt.Errorf("message %v not registered", msgID)
+		mainCmdID, subCmdID := msg.Cmd()
+		return fmt.Errorf("message %v,%v not registered", mainCmdID, subCmdID)
 	}
 	if i.msgHandler != nil {
 		i.msgHandler([]interface{}{msg, userData})
 	}
 	if i.msgRouter != nil {
-		i.msgRouter.Go(msgType, msg, userData)
+		i.msgRouter.Go(msg.MsgId, msg, userData)
+	} else if i.msgHandler == nil {
+		return errors.New("msg not handle")
 	}
 	return nil
 }
 
 // goroutine safe
-func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
-	var m map[string]json.RawMessage
-	err := json.Unmarshal(data, &m)
-	if err != nil {
-		return nil, err
-	}
-	if len(m) != 1 {
-		return nil, errors.New("invalid json data")
+func (p *Processor) Unmarshal(data []byte) (*network.Envelope, error) {
+	var f frame
+	if err := json.Unmarshal(data, &f); err != nil {
+		return &network.Envelope{}, err
 	}
+	msg := &network.Envelope{RpcCallId: f.RpcCallId, MsgType: f.MsgType}
 
-	for msgID, data := range m {
-		i, ok := p.msgInfo[msgID]
-		if !ok {
-			return nil, fmt.Errorf("message %v not registered", msgID)
-		}
-
-		// msg
-		if i.msgRawHandler != nil {
-			return MsgRaw{msgID, data}, nil
-		} else {
-			msg := reflect.New(i.msgType.Elem()).Interface()
-			return msg, json.Unmarshal(data, msg)
-		}
+	id := network.MsgID(f.MainCmdID, f.SubCmdID)
+	i, ok := p.msgInfo[id]
+	if !ok {
+		return msg, fmt.Errorf("message %v,%v not registered", f.MainCmdID, f.SubCmdID)
 	}
+	msg.MsgId = id
 
-	panic("bug")
+	// msg
+	if i.msgRawHandler != nil {
+		msg.Msg = MsgRaw{id, f.Body}
+		return msg, nil
+	}
+	if i.msgType == nil {
+		return msg, nil
+	}
+	msg.Msg = reflect.New(i.msgType.Elem()).Interface()
+	if len(f.Body) == 0 {
+		return msg, nil
+	}
+	return msg, json.Unmarshal(f.Body, msg.Msg)
 }
 
 // goroutine safe
-func (p *Processor) Marshal(msg interface{}) ([][]byte, error) {
-	msgType := reflect.TypeOf(msg)
-	if msgType == nil || msgType.Kind() != reflect.Ptr {
+func (p *Processor) Marshal(recv *network.Envelope, mainCmdID uint16, subCmdID uint16, msg interface{}) ([][]byte, error) {
+	if msg != nil && reflect.TypeOf(msg).Kind() != reflect.Ptr {
 		return nil, errors.New("json message pointer required")
 	}
-	msgID := msgType.Elem().Name()
-	if _, ok := p.msgInfo[msgID]; !ok {
-		return nil, fmt.Errorf("message %v not registered", msgID)
+	if _, ok := p.msgInfo[network.MsgID(mainCmdID, subCmdID)]; !ok {
+		return nil, fmt.Errorf("message %v,%v not registered", mainCmdID, subCmdID)
 	}
 
 	// data
-	m := map[string]interface{}{msgID: msg}
-	data, err := json.Marshal(m)
+	f := outFrame{MainCmdID: mainCmdID, SubCmdID: subCmdID, MsgType: recv.MsgType, Body: msg}
+	if recv.IsRPC() {
+		f.RpcCallId = recv.RpcCallId
+	}
+	data, err := json.Marshal(&f)
 	return [][]byte{data}, err
 }
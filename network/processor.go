@@ -1,12 +1,67 @@
 package network
 
-import "github.com/CreFire/leaf/network/cstruct"
+// flags of Envelope.MsgType
+const (
+	MsgTypeNone uint8 = 0
+	MsgTypeRPC  uint8 = 0x01 // the RpcCallId is in the frame
+)
 
+// Envelope is a message of the processors, the same for all the codecs:
+// the message id, the flags, the rpc call id and the body.
+// cstruct.RecvMsg is an alias of it.
+type Envelope struct {
+	RpcCallId uint32
+	// MsgID(mainCmdID, subCmdID)
+	MsgId uint32
+	// the body, e.g. a *struct, or the raw body of a raw handler
+	Msg     interface{}
+	MsgType uint8
+}
+
+// MsgID returns the id of Envelope.MsgId
+func MsgID(mainCmdID uint16, subCmdID uint16) uint32 {
+	return uint32(mainCmdID) | uint32(subCmdID)<<16
+}
+
+// Cmd returns the mainCmdID and subCmdID of a MsgID
+func Cmd(id uint32) (mainCmdID uint16, subCmdID uint16) {
+	return uint16(id), uint16(id >> 16)
+}
+
+// Cmd returns the mainCmdID and subCmdID of the message
+func (e *Envelope) Cmd() (mainCmdID uint16, subCmdID uint16) {
+	return Cmd(e.MsgId)
+}
+
+// IsRPC reports whether the message is an rpc call or its reply
+func (e *Envelope) IsRPC() bool {
+	return e.MsgType&MsgTypeRPC != 0
+}
+
+// Processor is implemented by the json, protobuf and cstruct processors.
+// The replies of Marshal copy the MsgType and RpcCallId of recv.
 type Processor interface {
 	// Route must goroutine safe
-	Route(msg *cstruct.RecvMsg, userData interface{}) error
+	Route(msg *Envelope, userData interface{}) error
 	// Unmarshal must goroutine safe
-	Unmarshal(data []byte) (*cstruct.RecvMsg, error)
+	Unmarshal(data []byte) (*Envelope, error)
 	// Marshal must goroutine safe
-	Marshal(recv *cstruct.RecvMsg, mainCmdID uint16, subCmdID uint16, msg interface{}) ([][]byte, error)
+	Marshal(recv *Envelope, mainCmdID uint16, subCmdID uint16, msg interface{}) ([][]byte, error)
+}
+
+// AppendProcessor is implemented by the processors which append the header
+// and body of a message to dst, used with AppendConn
+type AppendProcessor interface {
+	// AppendMarshal must goroutine safe
+	AppendMarshal(dst []byte, recv *Envelope, mainCmdID uint16, subCmdID uint16, msg interface{}) ([]byte, error)
+}
+
+// Codec is a processor which the clients select on connecting: the
+// websocket clients by the subprotocol Name in the handshake, and the
+// clients of the listeners by a first frame of the single Magic byte if
+// Magic is not 0, see gate.Gate.Codecs
+type Codec struct {
+	Name      string
+	Magic     byte
+	Processor Processor
 }
@@ -0,0 +1,40 @@
+package protobuf_test
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/CreFire/leaf/network"
+	"github.com/CreFire/leaf/network/protobuf"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func Example() {
+	p := protobuf.NewProcessor()
+	p.Register(1, 2, &wrapperspb.StringValue{})
+	p.SetHandler(1, 2, func(args []interface{}) {
+		msg := args[0].(*network.Envelope)
+		fmt.Println("hello", msg.Msg.(*wrapperspb.StringValue).Value, msg.RpcCallId)
+	})
+
+	var processor network.Processor = p
+	recv := &network.Envelope{MsgType: network.MsgTypeRPC, RpcCallId: 7}
+	data, err := processor.Marshal(recv, 1, 2, wrapperspb.String("leaf"))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	frame := bytes.Join(data, nil)
+	fmt.Printf("% x\n", frame[:9])
+
+	msg, err := processor.Unmarshal(frame)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	processor.Route(msg, nil)
+
+	// Output:
+	// 01 01 00 02 00 07 00 00 00
+	// hello leaf 7
+}
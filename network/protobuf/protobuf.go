@@ -5,21 +5,25 @@ import (
 	"errors"
 	"fmt"
 	"github.com/CreFire/leaf/chanrpc"
+	"github.com/CreFire/leaf/network"
 	"github.com/golang/protobuf/proto"
 	log "github.com/sirupsen/logrus"
-	"math"
 	"reflect"
+	"sort"
 )
 
-// -------------------------
-// | id | protobuf message |
-// -------------------------
+// ---------------------------------------------------------------------------
+// | msgType | id | rpcCallId, only of the rpc messages | protobuf message |
+// ---------------------------------------------------------------------------
+// the header is the one of the cstruct processor, in little endian by
+// default too, id is network.MsgID(mainCmdID, subCmdID)
 type Processor struct {
 	littleEndian bool
-	msgInfo      []*MsgInfo
-	msgID        map[reflect.Type]uint16
+	msgInfo      map[uint32]*MsgInfo
 }
 
+var _ network.Processor = (*Processor)(nil)
+
 type MsgInfo struct {
 	msgType       reflect.Type
 	msgRouter     *chanrpc.Server
@@ -30,14 +34,14 @@ type MsgInfo struct {
 type MsgHandler func([]interface{})
 
 type MsgRaw struct {
-	msgID      uint16
+	msgID      uint32
 	msgRawData []byte
 }
 
 func NewProcessor() *Processor {
 	p := new(Processor)
-	p.littleEndian = false
-	p.msgID = make(map[reflect.Type]uint16)
+	p.littleEndian = true
+	p.msgInfo = make(map[uint32]*MsgInfo)
 	return p
 }
 
@@ -47,65 +51,56 @@ func (p *Processor) SetByteOrder(littleEndian bool) {
 }
 
 // It's dangerous to call the method on routing or marshaling (unmarshaling)
-func (p *Processor) Register(msg proto.Message) uint16 {
-	msgType := reflect.TypeOf(msg)
-	if msgType == nil || msgType.Kind() != reflect.Ptr {
-		log.Fatal("protobuf message pointer required")
-	}
-	if _, ok := p.msgID[msgType]; ok {
-		log.Fatal("message %s is already registered", msgType)
-	}
-	if len(p.msgInfo) >= math.MaxUint16 {
-		log.Fatal("too many protobuf messages (max = %v)", math.MaxUint16)
+// msg is nil for the messages without a body
+func (p *Processor) Register(mainCmdID uint16, subCmdID uint16, msg proto.Message) uint32 {
+	id := network.MsgID(mainCmdID, subCmdID)
+	if _, ok := p.msgInfo[id]; ok {
+		log.Fatalf("message %v,%v is already registered", mainCmdID, subCmdID)
 	}
 
 	i := new(MsgInfo)
-	i.msgType = msgType
-	p.msgInfo = append(p.msgInfo, i)
-	id := uint16(len(p.msgInfo) - 1)
-	p.msgID[msgType] = id
+	if msg != nil {
+		msgType := reflect.TypeOf(msg)
+		if msgType.Kind() != reflect.Ptr {
+			log.Fatal("protobuf message pointer required")
+		}
+		i.msgType = msgType
+	}
+	p.msgInfo[id] = i
 	return id
 }
 
-// It's dangerous to call the method on routing or marshaling (unmarshaling)
-func (p *Processor) SetRouter(msg proto.Message, msgRouter *chanrpc.Server) {
-	msgType := reflect.TypeOf(msg)
-	id, ok := p.msgID[msgType]
+func (p *Processor) info(mainCmdID uint16, subCmdID uint16) *MsgInfo {
+	i, ok := p.msgInfo[network.MsgID(mainCmdID, subCmdID)]
 	if !ok {
-		log.Fatal("message %s not registered", msgType)
+		log.Fatalf("message %v,%v not registered", mainCmdID, subCmdID)
 	}
-
-	p.msgInfo[id].msgRouter = msgRouter
+	return i
 }
 
 // It's dangerous to call the method on routing or marshaling (unmarshaling)
-func (p *Processor) SetHandler(msg proto.Message, msgHandler MsgHandler) {
-	msgType := reflect.TypeOf(msg)
-	id, ok := p.msgID[msgType]
-	if !ok {
-		log.Fatal("message %s not registered", msgType)
-	}
-
-	p.msgInfo[id].msgHandler = msgHandler
+func (p *Processor) SetRouter(mainCmdID uint16, subCmdID uint16, msgRouter *chanrpc.Server) {
+	p.info(mainCmdID, subCmdID).msgRouter = msgRouter
 }
 
 // It's dangerous to call the method on routing or marshaling (unmarshaling)
-func (p *Processor) SetRawHandler(id uint16, msgRawHandler MsgHandler) {
-	if id >= uint16(len(p.msgInfo)) {
-		log.Fatal("message id %v not registered", id)
-	}
+func (p *Processor) SetHandler(mainCmdID uint16, subCmdID uint16, msgHandler MsgHandler) {
+	p.info(mainCmdID, subCmdID).msgHandler = msgHandler
+}
 
-	p.msgInfo[id].msgRawHandler = msgRawHandler
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetRawHandler(mainCmdID uint16, subCmdID uint16, msgRawHandler MsgHandler) {
+	p.info(mainCmdID, subCmdID).msgRawHandler = msgRawHandler
 }
 
 // goroutine safe
-func (p *Processor) Route(msg interface{}, userData interface{}) error {
+func (p *Processor) Route(msg *network.Envelope, userData interface{}) error {
 	// raw
-	if msgRaw, ok := msg.(MsgRaw); ok {
-		if msgRaw.msgID >= uint16(len(p.msgInfo)) {
+	if msgRaw, ok := msg.Msg.(MsgRaw); ok {
+		i, ok := p.msgInfo[msgRaw.msgID]
+		if !ok {
 			return fmt.Errorf("message id %v not registered", msgRaw.msgID)
 		}
-		i := p.msgInfo[msgRaw.msgID]
 		if i.msgRawHandler != nil {
 			i.msgRawHandler([]interface{}{msgRaw.msgID, msgRaw.msgRawData, userData})
 		}
@@ -113,74 +108,113 @@ func (p *Processor) Route(msg interface{}, userData interface{}) error {
 	}
 
 	// protobuf
-	msgType := reflect.TypeOf(msg)
-	id, ok := p.msgID[msgType]
+	i, ok := p.msgInfo[msg.MsgId]
 	if !ok {
-		return fmt.Errorf("message %s not registered", msgType)
+		mainCmdID, subCmdID := msg.Cmd()
+		return fmt.Errorf("message %v,%v not registered", mainCmdID, subCmdID)
 	}
-	i := p.msgInfo[id]
 	if i.msgHandler != nil {
 		i.msgHandler([]interface{}{msg, userData})
 	}
 	if i.msgRouter != nil {
-		i.msgRouter.Go(msgType, msg, userData)
+		i.msgRouter.Go(msg.MsgId, msg, userData)
+	} else if i.msgHandler == nil {
+		return errors.New("msg not handle")
 	}
 	return nil
 }
 
 // goroutine safe
-func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
-	if len(data) < 2 {
-		return nil, errors.New("protobuf data too short")
+func (p *Processor) Unmarshal(data []byte) (*network.Envelope, error) {
+	if len(data) < 5 {
+		return &network.Envelope{}, errors.New("protobuf data too short")
 	}
 
-	// id
-	var id uint16
-	if p.littleEndian {
-		id = binary.LittleEndian.Uint16(data)
-	} else {
-		id = binary.BigEndian.Uint16(data)
+	msg := &network.Envelope{MsgType: data[0]}
+	idx := 5
+	if msg.IsRPC() {
+		if len(data) < 9 {
+			return msg, errors.New("protobuf data too short")
+		}
+		msg.RpcCallId = p.uint32(data[5:])
+		idx = 9
 	}
-	if id >= uint16(len(p.msgInfo)) {
-		return nil, fmt.Errorf("message id %v not registered", id)
+
+	// id
+	id := p.uint32(data[1:])
+	i, ok := p.msgInfo[id]
+	if !ok {
+		mainCmdID, subCmdID := network.Cmd(id)
+		return msg, fmt.Errorf("message %v,%v not registered", mainCmdID, subCmdID)
 	}
+	msg.MsgId = id
 
 	// msg
-	i := p.msgInfo[id]
 	if i.msgRawHandler != nil {
-		return MsgRaw{id, data[2:]}, nil
-	} else {
-		msg := reflect.New(i.msgType.Elem()).Interface()
-		return msg, proto.UnmarshalMerge(data[2:], msg.(proto.Message))
+		msg.Msg = MsgRaw{id, data[idx:]}
+		return msg, nil
 	}
+	if i.msgType == nil {
+		return msg, nil
+	}
+	msg.Msg = reflect.New(i.msgType.Elem()).Interface()
+	return msg, proto.UnmarshalMerge(data[idx:], msg.Msg.(proto.Message))
 }
 
 // goroutine safe
-func (p *Processor) Marshal(msg interface{}) ([][]byte, error) {
-	msgType := reflect.TypeOf(msg)
+func (p *Processor) Marshal(recv *network.Envelope, mainCmdID uint16, subCmdID uint16, msg interface{}) ([][]byte, error) {
+	id := network.MsgID(mainCmdID, subCmdID)
+	if _, ok := p.msgInfo[id]; !ok {
+		return nil, fmt.Errorf("message %v,%v not registered", mainCmdID, subCmdID)
+	}
 
-	// id
-	_id, ok := p.msgID[msgType]
+	// header
+	header := make([]byte, 5, 9)
+	header[0] = recv.MsgType
+	p.putUint32(header[1:], id)
+	if recv.IsRPC() {
+		header = header[:9]
+		p.putUint32(header[5:], recv.RpcCallId)
+	}
+
+	// data
+	if msg == nil {
+		return [][]byte{header}, nil
+	}
+	m, ok := msg.(proto.Message)
 	if !ok {
-		err := fmt.Errorf("message %s not registered", msgType)
-		return nil, err
+		return nil, fmt.Errorf("message %v is not a protobuf message", reflect.TypeOf(msg))
 	}
+	data, err := proto.Marshal(m)
+	return [][]byte{header, data}, err
+}
 
-	id := make([]byte, 2)
+func (p *Processor) uint32(b []byte) uint32 {
 	if p.littleEndian {
-		binary.LittleEndian.PutUint16(id, _id)
-	} else {
-		binary.BigEndian.PutUint16(id, _id)
+		return binary.LittleEndian.Uint32(b)
 	}
+	return binary.BigEndian.Uint32(b)
+}
 
-	// data
-	data, err := proto.Marshal(msg.(proto.Message))
-	return [][]byte{id, data}, err
+func (p *Processor) putUint32(b []byte, v uint32) {
+	if p.littleEndian {
+		binary.LittleEndian.PutUint32(b, v)
+	} else {
+		binary.BigEndian.PutUint32(b, v)
+	}
 }
 
 // goroutine safe
-func (p *Processor) Range(f func(id uint16, t reflect.Type)) {
-	for id, i := range p.msgInfo {
-		f(uint16(id), i.msgType)
+// Range calls f with the registered messages sorted by the id, t is nil for
+// the messages without a body
+func (p *Processor) Range(f func(mainCmdID uint16, subCmdID uint16, t reflect.Type)) {
+	ids := make([]uint32, 0, len(p.msgInfo))
+	for id := range p.msgInfo {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		mainCmdID, subCmdID := network.Cmd(id)
+		f(mainCmdID, subCmdID, p.msgInfo[id].msgType)
 	}
 }
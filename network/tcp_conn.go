@@ -11,7 +11,7 @@ type ConnSet map[net.Conn]struct{}
 type TCPConn struct {
 	sync.Mutex
 	conn      net.Conn
-	writeChan chan []byte
+	writeChan chan frame
 	closeFlag bool
 	msgParser *MsgParser
 }
@@ -19,16 +19,17 @@ type TCPConn struct {
 func newTCPConn(conn net.Conn, pendingWriteNum int, msgParser *MsgParser) *TCPConn {
 	tcpConn := new(TCPConn)
 	tcpConn.conn = conn
-	tcpConn.writeChan = make(chan []byte, pendingWriteNum)
+	tcpConn.writeChan = make(chan frame, pendingWriteNum)
 	tcpConn.msgParser = msgParser
 
 	go func() {
-		for b := range tcpConn.writeChan {
-			if b == nil {
+		for f := range tcpConn.writeChan {
+			if f.b == nil {
 				break
 			}
 
-			_, err := conn.Write(b)
+			_, err := conn.Write(f.b)
+			f.free()
 			if err != nil {
 				break
 			}
@@ -67,18 +68,18 @@ func (tcpConn *TCPConn) Close() {
 		return
 	}
 
-	tcpConn.doWrite(nil)
+	tcpConn.doWrite(frame{})
 	tcpConn.closeFlag = true
 }
 
-func (tcpConn *TCPConn) doWrite(b []byte) {
+func (tcpConn *TCPConn) doWrite(f frame) {
 	if len(tcpConn.writeChan) == cap(tcpConn.writeChan) {
 		log.Debug("close conn: channel full")
 		tcpConn.doDestroy()
 		return
 	}
 
-	tcpConn.writeChan <- b
+	tcpConn.writeChan <- f
 }
 
 // b must not be modified by the others goroutines
@@ -89,7 +90,18 @@ func (tcpConn *TCPConn) Write(b []byte) {
 		return
 	}
 
-	tcpConn.doWrite(b)
+	tcpConn.doWrite(frame{b: b})
+}
+
+func (tcpConn *TCPConn) writeFrame(f frame) {
+	tcpConn.Lock()
+	defer tcpConn.Unlock()
+	if tcpConn.closeFlag {
+		f.free()
+		return
+	}
+
+	tcpConn.doWrite(f)
 }
 
 func (tcpConn *TCPConn) Read(b []byte) (int, error) {
@@ -111,3 +123,7 @@ func (tcpConn *TCPConn) ReadMsg() ([]byte, error) {
 func (tcpConn *TCPConn) WriteMsg(args ...[]byte) error {
 	return tcpConn.msgParser.Write(tcpConn, args...)
 }
+
+func (tcpConn *TCPConn) WriteMsgAppend(f AppendFunc) error {
+	return tcpConn.msgParser.WriteAppend(tcpConn, f)
+}
@@ -115,15 +115,60 @@ func (p *MsgParser) Write(conn *TCPConn, args ...[]byte) error {
 	}
 
 	// check len
+	if err := p.checkLen(msgLen); err != nil {
+		return err
+	}
+
+	msg := make([]byte, uint32(p.lenMsgLen)+msgLen)
+
+	// write len
+	p.putLen(msg, msgLen)
+
+	// write data
+	l := p.lenMsgLen
+	for i := 0; i < len(args); i++ {
+		copy(msg[l:], args[i])
+		l += len(args[i])
+	}
+
+	conn.Write(msg)
+
+	return nil
+}
+
+// goroutine safe
+// WriteAppend writes the len and the message appended by f in one pooled
+// buffer, which is reused after written to conn
+func (p *MsgParser) WriteAppend(conn *TCPConn, f AppendFunc) error {
+	pool := getFrame()
+	msg, err := f((*pool)[:p.lenMsgLen])
+	if err != nil {
+		framePool.Put(pool)
+		return err
+	}
+
+	msgLen := uint32(len(msg) - p.lenMsgLen)
+	if err := p.checkLen(msgLen); err != nil {
+		frame{msg, pool}.free()
+		return err
+	}
+	p.putLen(msg, msgLen)
+
+	conn.writeFrame(frame{msg, pool})
+
+	return nil
+}
+
+func (p *MsgParser) checkLen(msgLen uint32) error {
 	if msgLen > uint32(p.maxMsgLen) {
 		return errors.New("message too long")
 	} else if msgLen < uint32(p.minMsgLen) {
 		return errors.New("message too short")
 	}
+	return nil
+}
 
-	msg := make([]byte, uint32(p.lenMsgLen)+msgLen)
-
-	// write len
+func (p *MsgParser) putLen(msg []byte, msgLen uint32) {
 	switch p.lenMsgLen {
 	case 1:
 		msg[0] = byte(msgLen)
@@ -140,17 +185,6 @@ func (p *MsgParser) Write(conn *TCPConn, args ...[]byte) error {
 			binary.BigEndian.PutUint32(msg, msgLen)
 		}
 	}
-
-	// write data
-	l := p.lenMsgLen
-	for i := 0; i < len(args); i++ {
-		copy(msg[l:], args[i])
-		l += len(args[i])
-	}
-
-	conn.Write(msg)
-
-	return nil
 }
 
 func (p *MsgParser) Pack(data ...[]byte) ([]byte, error) {
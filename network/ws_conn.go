@@ -13,7 +13,7 @@ type WebsocketConnSet map[*websocket.Conn]struct{}
 type WSConn struct {
 	sync.Mutex
 	conn      *websocket.Conn
-	writeChan chan []byte
+	writeChan chan frame
 	maxMsgLen int32
 	closeFlag bool
 }
@@ -21,16 +21,17 @@ type WSConn struct {
 func newWSConn(conn *websocket.Conn, pendingWriteNum int, maxMsgLen int32) *WSConn {
 	wsConn := new(WSConn)
 	wsConn.conn = conn
-	wsConn.writeChan = make(chan []byte, pendingWriteNum)
+	wsConn.writeChan = make(chan frame, pendingWriteNum)
 	wsConn.maxMsgLen = maxMsgLen
 
 	go func() {
-		for b := range wsConn.writeChan {
-			if b == nil {
+		for f := range wsConn.writeChan {
+			if f.b == nil {
 				break
 			}
 
-			err := conn.WriteMessage(websocket.BinaryMessage, b)
+			err := conn.WriteMessage(websocket.BinaryMessage, f.b)
+			f.free()
 			if err != nil {
 				break
 			}
@@ -69,18 +70,18 @@ func (wsConn *WSConn) Close() {
 		return
 	}
 
-	wsConn.doWrite(nil)
+	wsConn.doWrite(frame{})
 	wsConn.closeFlag = true
 }
 
-func (wsConn *WSConn) doWrite(b []byte) {
+func (wsConn *WSConn) doWrite(f frame) {
 	if len(wsConn.writeChan) == cap(wsConn.writeChan) {
 		log.Debug("close conn: channel full")
 		wsConn.doDestroy()
 		return
 	}
 
-	wsConn.writeChan <- b
+	wsConn.writeChan <- f
 }
 
 func (wsConn *WSConn) LocalAddr() net.Addr {
@@ -91,6 +92,11 @@ func (wsConn *WSConn) RemoteAddr() net.Addr {
 	return wsConn.conn.RemoteAddr()
 }
 
+// Subprotocol returns the subprotocol of the handshake, "" if none
+func (wsConn *WSConn) Subprotocol() string {
+	return wsConn.conn.Subprotocol()
+}
+
 // goroutine not safe
 func (wsConn *WSConn) ReadMsg() ([]byte, error) {
 	_, b, err := wsConn.conn.ReadMessage()
@@ -120,7 +126,7 @@ func (wsConn *WSConn) WriteMsg(args ...[]byte) error {
 
 	// don't copy
 	if len(args) == 1 {
-		wsConn.doWrite(args[0])
+		wsConn.doWrite(frame{b: args[0]})
 		return nil
 	}
 
@@ -132,7 +138,36 @@ func (wsConn *WSConn) WriteMsg(args ...[]byte) error {
 		l += len(args[i])
 	}
 
-	wsConn.doWrite(msg)
+	wsConn.doWrite(frame{b: msg})
+
+	return nil
+}
+
+// WriteMsgAppend writes the message appended by f in one pooled buffer
+func (wsConn *WSConn) WriteMsgAppend(f AppendFunc) error {
+	pool := getFrame()
+	msg, err := f((*pool)[:0])
+	if err != nil {
+		framePool.Put(pool)
+		return err
+	}
+
+	wsConn.Lock()
+	defer wsConn.Unlock()
+	if wsConn.closeFlag {
+		frame{msg, pool}.free()
+		return nil
+	}
+
+	// check len
+	if len(msg) > int(wsConn.maxMsgLen) {
+		frame{msg, pool}.free()
+		return errors.New("message too long")
+	} else if len(msg) < 1 {
+		frame{msg, pool}.free()
+		return errors.New("message too short")
+	}
 
+	wsConn.doWrite(frame{msg, pool})
 	return nil
 }
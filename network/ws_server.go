@@ -18,6 +18,7 @@ type WSServer struct {
 	HTTPTimeout     time.Duration
 	CertFile        string
 	KeyFile         string
+	Subprotocols    []string // of the handshake by preference, see WSConn.Subprotocol
 	NewAgent        func(*WSConn) Agent
 	ln              net.Listener
 	handler         *WSHandler
@@ -125,6 +126,7 @@ func (server *WSServer) Start() {
 		conns:           make(WebsocketConnSet),
 		upgrader: websocket.Upgrader{
 			HandshakeTimeout: server.HTTPTimeout,
+			Subprotocols:     server.Subprotocols,
 			CheckOrigin:      func(_ *http.Request) bool { return true },
 		},
 	}
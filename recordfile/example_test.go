@@ -1,8 +1,12 @@
 package recordfile_test
 
 import (
+	"archive/zip"
 	"fmt"
+	"github.com/CreFire/leaf/chanrpc"
 	"github.com/CreFire/leaf/recordfile"
+	"os"
+	"path/filepath"
 )
 
 func Example() {
@@ -65,3 +69,375 @@ func Example() {
 	// CreFire
 	// 6
 }
+
+func ExampleManager() {
+	type Item struct {
+		ID    int `rf:"index"`
+		Price int
+	}
+
+	dir, _ := os.MkdirTemp("", "recordfile")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "item.txt")
+	os.WriteFile(path, []byte("id\tprice\n1\t10\n2\t20\n"), 0644)
+
+	m := recordfile.NewManager()
+	m.Add("item", path, Item{})
+	m.Validate = func(t *recordfile.Tables) error {
+		rf := t.Get("item")
+		for i := 0; i < rf.NumRecord(); i++ {
+			if r := rf.Record(i).(*Item); r.Price <= 0 {
+				return fmt.Errorf("item %v: invalid price %v", r.ID, r.Price)
+			}
+		}
+		return nil
+	}
+
+	// a module gets notified
+	server := chanrpc.NewServer(1)
+	server.Register("TablesReloaded", func(args []interface{}) {
+		t := args[0].(*recordfile.Tables)
+		fmt.Println("version", t.Version(), "changed", args[1])
+	})
+	m.Subscribe(server, "TablesReloaded")
+
+	if err := m.Load(); err != nil {
+		fmt.Println(err)
+		return
+	}
+	server.Exec(<-server.ChanCall)
+	fmt.Println(m.Get("item").Lookup("ID", 2).(*Item).Price)
+
+	// rejected, the old tables stay
+	os.WriteFile(path, []byte("id\tprice\n1\t10\n2\t0\n"), 0644)
+	_, err := m.Reload()
+	fmt.Println(err)
+	fmt.Println(m.Get("item").Lookup("ID", 2).(*Item).Price)
+
+	// not read again until it changes
+	changed, err := m.Reload()
+	fmt.Println(changed, err)
+
+	// as the console command "reload item"
+	os.WriteFile(path, []byte("id\tprice\n1\t10\n2\t25\n"), 0644)
+	fmt.Println(m.Command([]interface{}{"item"}))
+	server.Exec(<-server.ChanCall)
+	fmt.Println(m.Get("item").Lookup("ID", 2).(*Item).Price)
+
+	// Output:
+	// version 1 changed [item]
+	// 20
+	// item 2: invalid price 0
+	// 20
+	// [] <nil>
+	// reloaded item (version 2)
+	// version 2 changed [item]
+	// 25
+}
+
+func ExampleRecordFile_Lookup() {
+	type Reward struct {
+		ID      int    `rf:"index"`
+		Chapter int    `rf:"index=chapter,multi"`
+		Type    string `rf:"index=type_level"`
+		Level   int32  `rf:"index=type_level,index=level,multi,sorted"`
+		Gold    int
+	}
+
+	dir, _ := os.MkdirTemp("", "recordfile")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "reward.txt")
+	os.WriteFile(path, []byte("id\tchapter\ttype\tlevel\tgold\n"+
+		"1\t1\tsword\t1\t10\n"+
+		"2\t1\tshield\t3\t20\n"+
+		"3\t2\tsword\t2\t30\n"+
+		"4\t2\tshield\t1\t40\n"), 0644)
+
+	rf, err := recordfile.New(Reward{})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := rf.Read(path); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println(rf.Lookup("ID", 3).(*Reward).Gold)
+
+	// a key the field can not hold finds nothing
+	fmt.Println(rf.Lookup("ID", 3.0).(*Reward).Gold, rf.Lookup("ID", 1.7))
+
+	// composite key, in field order
+	fmt.Println(rf.Lookup("type_level", "shield", 1).(*Reward).Gold)
+
+	// non-unique
+	for _, r := range rf.LookupAll("chapter", 2) {
+		fmt.Println("chapter 2:", r.(*Reward).ID)
+	}
+
+	// sorted
+	rf.Range("level", 2, nil, func(r interface{}) bool {
+		fmt.Println("level >= 2:", r.(*Reward).ID)
+		return true
+	})
+
+	// Output:
+	// 30
+	// 30 <nil>
+	// 40
+	// chapter 2: 3
+	// chapter 2: 4
+	// level >= 2: 3
+	// level >= 2: 2
+}
+
+func ExampleLoad() {
+	type Item struct {
+		ID    int32  `rf:"index"`
+		Name  string `rf:"index=name"`
+		Type  string `rf:"index=type,multi"`
+		Price int
+	}
+
+	dir, _ := os.MkdirTemp("", "recordfile")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "item.txt")
+	os.WriteFile(path, []byte("id\tname\ttype\tprice\n"+
+		"1\tsword\tweapon\t100\n"+
+		"2\tbow\tweapon\t80\n"+
+		"3\tpotion\tconsumable\t5\n"), 0644)
+
+	items, err := recordfile.Load[Item](path)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println(items.Len(), items.Get(2).Name)
+	for _, item := range items.Filter(func(item *Item) bool { return item.Price < 100 }) {
+		fmt.Println("cheap:", item.Name)
+	}
+
+	byName := recordfile.IndexOf[string](items, "name")
+	fmt.Println(byName.Get("potion").ID)
+
+	byType := recordfile.IndexOf[string](items, "type")
+	fmt.Println(len(byType.All("weapon")))
+
+	// Output:
+	// 3 bow
+	// cheap: bow
+	// cheap: potion
+	// 3
+	// 2
+}
+
+func ExampleValidateDir() {
+	type Item struct {
+		ID      int    `rf:"index"`
+		Name    string `rf:"required"`
+		Quality string `rf:"enum=white|blue|purple,default=white"`
+	}
+	type Drop struct {
+		ID     int   `rf:"index"`
+		Items  []int `rf:"ref=item.ID"`
+		Weight int   `rf:"min=1,max=100"`
+	}
+
+	dir, _ := os.MkdirTemp("", "recordfile")
+	defer os.RemoveAll(dir)
+	os.WriteFile(filepath.Join(dir, "item.txt"), []byte("id\tname\tquality\n"+
+		"1\tsword\t\n"+
+		"2\t\tblue\n"+
+		"3\tbow\tgold\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "drop.txt"), []byte("id\titems\tweight\n"+
+		"1\t[1,2]\t50\n"+
+		"2\t[4,3]\t0\n"+
+		"2\t[]\t10\n"), 0644)
+
+	vs := recordfile.ValidateDir(dir, ".txt", map[string]interface{}{
+		"item": Item{},
+		"drop": Drop{},
+	})
+	for _, v := range vs {
+		fmt.Println(filepath.Base(v.File), v.Row, v.Col, v.Field, v.Msg)
+	}
+
+	// Output:
+	// drop.txt 2 1 Items 4 not found in item.ID
+	// drop.txt 2 2 Weight 0 less than min 1
+	// drop.txt 3 0 ID index ID: duplicate key 2
+	// item.txt 2 1 Name required
+	// item.txt 3 2 Quality gold not in white|blue|purple
+}
+
+func ExampleCheckRefs() {
+	type Item struct {
+		ID int `rf:"index"`
+	}
+	type Drop struct {
+		ID     int   `rf:"index"`
+		Items  []int `rf:"ref=item.ID"`
+		Weight int   `rf:"min=1"`
+	}
+
+	dir, _ := os.MkdirTemp("", "recordfile")
+	defer os.RemoveAll(dir)
+	os.WriteFile(filepath.Join(dir, "item.txt"), []byte("id\n1\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "drop.txt"), []byte("weight\t#memo\titems\tid\n"+
+		"10\t\t[1,4]\t1\n"), 0644)
+
+	item, _ := recordfile.New(Item{})
+	item.Read(filepath.Join(dir, "item.txt"))
+
+	// the columns of the file, read from the snapshot too
+	drop, _ := recordfile.New(Drop{})
+	drop.MapByName = true
+	for i := 0; i < 2; i++ {
+		drop.ReadWithSnapshot(filepath.Join(dir, "drop.txt"), filepath.Join(dir, "drop.snap"))
+		vs := recordfile.CheckRefs(map[string]*recordfile.RecordFile{"item": item, "drop": drop})
+		for _, v := range vs {
+			fmt.Println(v.Row, v.Col, v.Field, v.Msg)
+		}
+	}
+
+	// Output:
+	// 1 2 Items 4 not found in item.ID
+	// 1 2 Items 4 not found in item.ID
+}
+
+func ExampleRecordFile_MapByName() {
+	type Monster struct {
+		ID   int    `rf:"index"`
+		Name string `col:"monster_name"`
+		HP   int    `rf:"default=100"`
+		Memo string `col:"-"`
+	}
+
+	// name, type and comment rows, the columns in any order
+	dir, _ := os.MkdirTemp("", "recordfile")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "monster.txt")
+	os.WriteFile(path, []byte("monster_name\t#note\tid\n"+
+		"string\tstring\tint\n"+
+		"name\tfor designers\tid\n"+
+		"slime\tweak\t1\n"+
+		"dragon\tboss\t2\n"), 0644)
+
+	rf, _ := recordfile.New(Monster{})
+	rf.MapByName = true
+	rf.HeaderRows = 3
+	if err := rf.Read(path); err != nil {
+		fmt.Println(err)
+		return
+	}
+	m := rf.Lookup("ID", 2).(*Monster)
+	fmt.Println(m.Name, m.HP)
+
+	// json
+	path = filepath.Join(dir, "monster.json")
+	os.WriteFile(path, []byte(`[{"id": 3, "monster_name": "ghost", "hp": 50}]`), 0644)
+	if err := rf.Read(path); err != nil {
+		fmt.Println(err)
+		return
+	}
+	m = rf.Record(0).(*Monster)
+	fmt.Println(m.ID, m.Name, m.HP)
+
+	// Output:
+	// dragon 100
+	// 3 ghost 50
+}
+
+func ExampleRecordFile_xlsx() {
+	type Item struct {
+		ID   int `rf:"index"`
+		Name string
+	}
+
+	dir, _ := os.MkdirTemp("", "recordfile")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "item.xlsx")
+	writeXLSX(path, map[string]string{
+		"xl/workbook.xml": `<workbook xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+			<sheets><sheet name="item" sheetId="1" r:id="rId1"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships>
+			<Relationship Id="rId1" Target="worksheets/sheet1.xml"/></Relationships>`,
+		"xl/sharedStrings.xml": `<sst><si><t>id</t></si><si><t>name</t></si><si><r><t>long</t></r><r><t>sword</t></r></si></sst>`,
+		"xl/worksheets/sheet1.xml": `<worksheet><sheetData>
+			<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c></row>
+			<row r="2"><c r="A2"><v>7</v></c><c r="B2" t="s"><v>2</v></c></row>
+			<row r="3"><c r="A3" t="inlineStr"><is><t>#9</t></is></c><c r="B3" t="inlineStr"><is><t>axe</t></is></c></row>
+			<row r="4"><c r="A4"><v>8</v></c><c r="B4" t="inlineStr"><is><t>bow</t></is></c></row>
+			</sheetData></worksheet>`,
+	})
+
+	rf, _ := recordfile.New(Item{})
+	rf.MapByName = true
+	if err := rf.Read(path); err != nil {
+		fmt.Println(err)
+		return
+	}
+	for i := 0; i < rf.NumRecord(); i++ {
+		item := rf.Record(i).(*Item)
+		fmt.Println(item.ID, item.Name)
+	}
+
+	// Output:
+	// 7 longsword
+	// 8 bow
+}
+
+func writeXLSX(path string, files map[string]string) {
+	f, _ := os.Create(path)
+	defer f.Close()
+	w := zip.NewWriter(f)
+	for name, content := range files {
+		fw, _ := w.Create(name)
+		fw.Write([]byte(content))
+	}
+	w.Close()
+}
+
+func ExampleRecordFile_ReadWithSnapshot() {
+	type Item struct {
+		ID    int32 `rf:"index"`
+		Name  string
+		Drops []uint32
+	}
+
+	dir, _ := os.MkdirTemp("", "recordfile")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "item.txt")
+	snap := filepath.Join(dir, "item.snap")
+	os.WriteFile(path, []byte("id\tname\tdrops\n1\tsword\t[3,4]\n2\tbow\t[]\n"), 0644)
+
+	read := func() {
+		rf, _ := recordfile.New(Item{})
+		if err := rf.ReadWithSnapshot(path, snap); err != nil {
+			fmt.Println(err)
+			return
+		}
+		items, _ := recordfile.NewTable[Item](rf)
+		fmt.Println(items.Len(), items.Get(int32(1)).Name, items.Get(int32(1)).Drops)
+	}
+
+	// parsed and snapshotted
+	read()
+	_, err := os.Stat(snap)
+	fmt.Println(err == nil)
+
+	// from the snapshot
+	read()
+
+	// the source changed, parsed again
+	os.WriteFile(path, []byte("id\tname\tdrops\n1\taxe\t[5]\n"), 0644)
+	read()
+
+	// Output:
+	// 2 sword [3 4]
+	// true
+	// 2 sword [3 4]
+	// 1 axe [5]
+}
@@ -0,0 +1,272 @@
+package recordfile
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+type indexDef struct {
+	name   string
+	fields []int
+	multi  bool
+	sorted bool
+}
+
+func parseIndexes(typeRecord reflect.Type, tags []*fieldTag) ([]*indexDef, error) {
+	var defs []*indexDef
+	byName := make(map[string]*indexDef)
+	for i := 0; i < typeRecord.NumField(); i++ {
+		f := typeRecord.Field(i)
+		for _, opt := range tags[i].indexes {
+			switch f.Type.Kind() {
+			case reflect.Struct, reflect.Slice, reflect.Map:
+				return nil, fmt.Errorf("could not index %s field %v %v",
+					f.Type.Kind(), i, f.Name)
+			}
+
+			def, ok := byName[opt.name]
+			if !ok {
+				def = &indexDef{name: opt.name}
+				byName[opt.name] = def
+				defs = append(defs, def)
+			}
+			def.fields = append(def.fields, i)
+			def.multi = def.multi || opt.multi
+			def.sorted = def.sorted || opt.sorted
+		}
+	}
+
+	for _, def := range defs {
+		if !def.sorted {
+			continue
+		}
+		if len(def.fields) > 1 {
+			return nil, fmt.Errorf("index %v: sorted index must have one field", def.name)
+		}
+		if _, ok := less(reflect.Zero(typeRecord.Field(def.fields[0]).Type)); !ok {
+			return nil, fmt.Errorf("index %v: could not sort %v", def.name, typeRecord.Field(def.fields[0]).Type)
+		}
+	}
+	return defs, nil
+}
+
+type entry struct {
+	key    reflect.Value
+	record interface{}
+}
+
+type namedIndex struct {
+	def     *indexDef
+	unique  map[interface{}]interface{}
+	groups  map[interface{}][]interface{}
+	entries []entry
+}
+
+func newNamedIndex(def *indexDef) *namedIndex {
+	i := &namedIndex{def: def}
+	if def.multi {
+		i.groups = make(map[interface{}][]interface{})
+	} else {
+		i.unique = make(map[interface{}]interface{})
+	}
+	return i
+}
+
+func (i *namedIndex) add(record reflect.Value, r interface{}) error {
+	key := makeKey(record, i.def.fields)
+	if i.def.multi {
+		i.groups[key] = append(i.groups[key], r)
+	} else {
+		if _, ok := i.unique[key]; ok {
+			return fmt.Errorf("index %v: duplicate key %v", i.def.name, key)
+		}
+		i.unique[key] = r
+	}
+	if i.def.sorted {
+		i.entries = append(i.entries, entry{record.Field(i.def.fields[0]), r})
+	}
+	return nil
+}
+
+func (i *namedIndex) sort() {
+	if !i.def.sorted || len(i.entries) == 0 {
+		return
+	}
+	lessFn, _ := less(i.entries[0].key)
+	sort.SliceStable(i.entries, func(a, b int) bool {
+		return lessFn(i.entries[a].key, i.entries[b].key)
+	})
+}
+
+func makeKey(record reflect.Value, fields []int) interface{} {
+	if len(fields) == 1 {
+		return record.Field(fields[0]).Interface()
+	}
+	key := reflect.New(reflect.ArrayOf(len(fields), typeInterface)).Elem()
+	for i, f := range fields {
+		key.Index(i).Set(record.Field(f))
+	}
+	return key.Interface()
+}
+
+var typeInterface = reflect.TypeOf((*interface{})(nil)).Elem()
+
+// lookupKey converts the values to the types of the index fields, so that
+// Lookup("id", 1) works with an int32 field
+func (rf *RecordFile) lookupKey(def *indexDef, values []interface{}) (interface{}, bool) {
+	if len(values) != len(def.fields) {
+		return nil, false
+	}
+	if len(values) == 1 {
+		v, ok := convert(values[0], rf.typeRecord.Field(def.fields[0]).Type)
+		if !ok {
+			return nil, false
+		}
+		return v.Interface(), true
+	}
+
+	key := reflect.New(reflect.ArrayOf(len(values), typeInterface)).Elem()
+	for i, f := range def.fields {
+		v, ok := convert(values[i], rf.typeRecord.Field(f).Type)
+		if !ok {
+			return nil, false
+		}
+		key.Index(i).Set(v)
+	}
+	return key.Interface(), true
+}
+
+// convert converts a key to the type of the field, a value which the type
+// can not hold converts to no key, e.g. 1.7 or 300 to an int8
+func convert(value interface{}, t reflect.Type) (reflect.Value, bool) {
+	v := reflect.ValueOf(value)
+	if !v.IsValid() {
+		return v, false
+	}
+	if v.Type() == t {
+		return v, true
+	}
+	if !v.Type().ConvertibleTo(t) || v.Kind() == reflect.String != (t.Kind() == reflect.String) {
+		return v, false
+	}
+	c := v.Convert(t)
+	// floats of different sizes are only rounded
+	if (isInteger(v.Kind()) || isInteger(t.Kind())) && c.Convert(v.Type()).Interface() != v.Interface() {
+		return v, false
+	}
+	if isSigned(v.Kind()) && isUnsigned(t.Kind()) && v.Int() < 0 ||
+		isUnsigned(v.Kind()) && isSigned(t.Kind()) && c.Int() < 0 {
+		return v, false
+	}
+	return c, true
+}
+
+func isSigned(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Int64
+}
+
+func isUnsigned(k reflect.Kind) bool {
+	return k >= reflect.Uint && k <= reflect.Uintptr
+}
+
+func isInteger(k reflect.Kind) bool {
+	return isSigned(k) || isUnsigned(k)
+}
+
+func less(v reflect.Value) (func(a, b reflect.Value) bool, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(a, b reflect.Value) bool { return a.Int() < b.Int() }, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return func(a, b reflect.Value) bool { return a.Uint() < b.Uint() }, true
+	case reflect.Float32, reflect.Float64:
+		return func(a, b reflect.Value) bool { return a.Float() < b.Float() }, true
+	case reflect.String:
+		return func(a, b reflect.Value) bool { return a.String() < b.String() }, true
+	}
+	return nil, false
+}
+
+func (rf *RecordFile) named(name string) *namedIndex {
+	i, ok := rf.namedIndexes[name]
+	if !ok {
+		panic(fmt.Sprintf("index %v: not found", name))
+	}
+	return i
+}
+
+// Lookup returns the record with key in the index name, the key of a
+// composite index is the values of its fields in order. For a multi index
+// it returns the first record.
+func (rf *RecordFile) Lookup(name string, key ...interface{}) interface{} {
+	i := rf.named(name)
+	k, ok := rf.lookupKey(i.def, key)
+	if !ok {
+		return nil
+	}
+	if i.def.multi {
+		if records := i.groups[k]; len(records) > 0 {
+			return records[0]
+		}
+		return nil
+	}
+	return i.unique[k]
+}
+
+// LookupAll returns all records with key in the index name, in file order
+func (rf *RecordFile) LookupAll(name string, key ...interface{}) []interface{} {
+	i := rf.named(name)
+	k, ok := rf.lookupKey(i.def, key)
+	if !ok {
+		return nil
+	}
+	if i.def.multi {
+		return i.groups[k]
+	}
+	if r, ok := i.unique[k]; ok {
+		return []interface{}{r}
+	}
+	return nil
+}
+
+// Range visits the records with from <= key <= to in the sorted index name
+// in key order, nil from or to means no bound, f returns false to stop
+func (rf *RecordFile) Range(name string, from interface{}, to interface{}, f func(record interface{}) bool) {
+	i := rf.named(name)
+	if !i.def.sorted {
+		panic(fmt.Sprintf("index %v: not sorted", name))
+	}
+	if len(i.entries) == 0 {
+		return
+	}
+
+	t := rf.typeRecord.Field(i.def.fields[0]).Type
+	lessFn, _ := less(i.entries[0].key)
+	start := 0
+	if from != nil {
+		v, ok := convert(from, t)
+		if !ok {
+			return
+		}
+		start = sort.Search(len(i.entries), func(n int) bool {
+			return !lessFn(i.entries[n].key, v)
+		})
+	}
+	var end reflect.Value
+	if to != nil {
+		var ok bool
+		if end, ok = convert(to, t); !ok {
+			return
+		}
+	}
+
+	for _, e := range i.entries[start:] {
+		if end.IsValid() && lessFn(end, e.key) {
+			return
+		}
+		if !f(e.record) {
+			return
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package recordfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CreFire/leaf/chanrpc"
+	log "github.com/sirupsen/logrus"
+)
+
+// Tables is a published set of tables, it must not be modified
+type Tables struct {
+	version uint64
+	files   map[string]*RecordFile
+}
+
+func (t *Tables) Version() uint64 {
+	return t.version
+}
+
+func (t *Tables) Get(name string) *RecordFile {
+	return t.files[name]
+}
+
+type table struct {
+	name    string
+	path    string
+	st      interface{}
+	modTime time.Time
+	size    int64
+	// read but rejected by the refs or Validate, published with the next
+	// valid set
+	pending *RecordFile
+}
+
+type subscriber struct {
+	server *chanrpc.Server
+	id     interface{}
+}
+
+// Manager loads a set of tables and reloads them on change, a new set is
+// published only if every changed table is read and validated, so readers
+// never see a half reloaded set
+//
+// m := recordfile.NewManager()
+// m.Add("item", "item.txt", Item{})
+// m.Load()
+// m.Watch(5 * time.Second)
+type Manager struct {
+	// cross table validation, called before publishing after the refs are
+	// checked, the names of the tables are used by ref
+	Validate func(t *Tables) error
+	// the directory of the snapshots of the tables, empty for none, see
+	// RecordFile.ReadWithSnapshot
+	SnapshotDir string
+
+	mu          sync.Mutex
+	tables      []*table
+	subscribers []subscriber
+	current     atomic.Value
+	closeSig    chan bool
+	wg          sync.WaitGroup
+}
+
+func NewManager() *Manager {
+	m := new(Manager)
+	m.current.Store(&Tables{files: make(map[string]*RecordFile)})
+	return m
+}
+
+// you must call the function before calling Load
+func (m *Manager) Add(name string, path string, st interface{}) error {
+	if _, err := New(st); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.tables {
+		if t.name == name {
+			return fmt.Errorf("table %v: already added", name)
+		}
+	}
+	m.tables = append(m.tables, &table{name: name, path: path, st: st})
+	return nil
+}
+
+// Subscribe makes server get id with args (*Tables, []string) after every
+// reload, the []string is the names of the changed tables
+// you must call the function before calling Load
+func (m *Manager) Subscribe(server *chanrpc.Server, id interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subscribers = append(m.subscribers, subscriber{server, id})
+}
+
+// goroutine safe
+func (m *Manager) Tables() *Tables {
+	return m.current.Load().(*Tables)
+}
+
+// goroutine safe
+func (m *Manager) Get(name string) *RecordFile {
+	return m.Tables().Get(name)
+}
+
+// Load reads all tables
+func (m *Manager) Load() error {
+	_, err := m.reload(true, nil)
+	return err
+}
+
+// Reload reads the tables whose files changed, or the named tables
+// goroutine safe
+func (m *Manager) Reload(names ...string) ([]string, error) {
+	return m.reload(false, names)
+}
+
+func (m *Manager) reload(all bool, names []string) ([]string, error) {
+	t, changed, subscribers, err := m.read(all, names)
+	if err != nil || len(changed) == 0 {
+		return nil, err
+	}
+
+	// published unlocked, Tables.Version tells the order
+	for _, s := range subscribers {
+		s.server.Go(s.id, t, changed)
+	}
+	return changed, nil
+}
+
+// read reads the tables and stores them if they are all valid, it returns
+// the subscribers to publish them to
+func (m *Manager) read(all bool, names []string) (*Tables, []string, []subscriber, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, name := range names {
+		if m.table(name) == nil {
+			return nil, nil, nil, fmt.Errorf("table %v: not found", name)
+		}
+	}
+
+	old := m.Tables()
+	t := &Tables{version: old.version + 1, files: make(map[string]*RecordFile, len(m.tables))}
+	var changed, pending []string
+	var stats []os.FileInfo
+	var errs []string
+	for _, tab := range m.tables {
+		fi, err := os.Stat(tab.path)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if !all && !contains(names, tab.name) &&
+			(len(names) > 0 || fi.ModTime().Equal(tab.modTime) && fi.Size() == tab.size) {
+			if tab.pending != nil {
+				t.files[tab.name] = tab.pending
+				pending = append(pending, tab.name)
+			} else {
+				t.files[tab.name] = old.files[tab.name]
+			}
+			continue
+		}
+
+		rf, _ := New(tab.st)
+		if m.SnapshotDir != "" {
+			err = rf.ReadWithSnapshot(tab.path, filepath.Join(m.SnapshotDir, tab.name+".snap"))
+		} else {
+			err = rf.Read(tab.path)
+		}
+		if err != nil {
+			// a broken file is not read again until it changes
+			tab.modTime = fi.ModTime()
+			tab.size = fi.Size()
+			tab.pending = nil
+			errs = append(errs, fmt.Sprintf("table %v: %v", tab.name, err))
+			continue
+		}
+		t.files[tab.name] = rf
+		changed = append(changed, tab.name)
+		stats = append(stats, fi)
+	}
+	if len(errs) > 0 {
+		return nil, nil, nil, errors.New(strings.Join(errs, "; "))
+	}
+	if len(changed) == 0 {
+		return nil, nil, nil, nil
+	}
+
+	// the files are not read again until they change, valid or not
+	for i, name := range changed {
+		tab := m.table(name)
+		tab.modTime = stats[i].ModTime()
+		tab.size = stats[i].Size()
+		tab.pending = t.files[name]
+	}
+	if vs := CheckRefs(t.files); len(vs) > 0 {
+		return nil, nil, nil, vs
+	}
+	if m.Validate != nil {
+		if err := m.Validate(t); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	changed = append(changed, pending...)
+	for _, name := range changed {
+		m.table(name).pending = nil
+	}
+	m.current.Store(t)
+	subscribers := make([]subscriber, len(m.subscribers))
+	copy(subscribers, m.subscribers)
+	return t, changed, subscribers, nil
+}
+
+func (m *Manager) table(name string) *table {
+	for _, t := range m.tables {
+		if t.name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// Watch reloads the changed tables every d
+func (m *Manager) Watch(d time.Duration) {
+	if m.closeSig != nil {
+		return
+	}
+	m.closeSig = make(chan bool)
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(d)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.closeSig:
+				return
+			case <-ticker.C:
+				changed, err := m.Reload()
+				if err != nil {
+					log.Errorf("reload tables error: %v", err)
+				} else if len(changed) > 0 {
+					log.Infof("tables reloaded: %v", strings.Join(changed, ", "))
+				}
+			}
+		}
+	}()
+}
+
+// Close stops watching
+func (m *Manager) Close() {
+	if m.closeSig != nil {
+		close(m.closeSig)
+		m.wg.Wait()
+		m.closeSig = nil
+	}
+}
+
+// Command is a console command reloading the changed tables, or the named
+// tables
+//
+// console.Register("reload", "reload tables", m.Command, skeleton.ChanRPCServer)
+func (m *Manager) Command(args []interface{}) interface{} {
+	names := make([]string, len(args))
+	for i := range args {
+		names[i] = args[i].(string)
+	}
+
+	changed, err := m.Reload(names...)
+	if err != nil {
+		return err.Error()
+	}
+	if len(changed) == 0 {
+		return "no change"
+	}
+	sort.Strings(changed)
+	return fmt.Sprintf("reloaded %v (version %v)", strings.Join(changed, ", "), m.Tables().Version())
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
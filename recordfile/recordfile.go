@@ -1,7 +1,7 @@
 package recordfile
 
 import (
-	"encoding/csv"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -16,11 +16,29 @@ var Comment = '#'
 type Index map[interface{}]interface{}
 
 type RecordFile struct {
-	Comma      rune
-	Comment    rune
-	typeRecord reflect.Type
-	records    []interface{}
-	indexes    []Index
+	Comma   rune
+	Comment rune
+	// the number of header rows, 0 means 1
+	HeaderRows int
+	// map the columns by the names in the header row NameRow instead of by
+	// the field order, the name of a field is its col tag or its name, case
+	// insensitive. The columns with unknown names or names starting with
+	// Comment are ignored, a field with the tag col:"-" is not read.
+	MapByName bool
+	NameRow   int
+	// the sheet of a .xlsx file, empty means the first one
+	Sheet string
+
+	typeRecord   reflect.Type
+	fieldTags    []*fieldTag
+	indexDefs    []*indexDef
+	path         string
+	sourceHash   [sha256.Size]byte
+	headerRows   int
+	cols         []int
+	records      []interface{}
+	indexes      []Index
+	namedIndexes map[string]*namedIndex
 }
 
 func New(st interface{}) (*RecordFile, error) {
@@ -56,80 +74,118 @@ func New(st interface{}) (*RecordFile, error) {
 			return nil, fmt.Errorf("invalid type: %v %s",
 				f.Name, kind)
 		}
+	}
 
-		tag := f.Tag
-		if tag == "index" {
-			switch kind {
-			case reflect.Struct, reflect.Slice, reflect.Map:
-				return nil, fmt.Errorf("could not index %s field %v %v",
-					kind, i, f.Name)
-			}
-		}
+	fieldTags, err := parseTags(typeRecord)
+	if err != nil {
+		return nil, err
+	}
+	indexDefs, err := parseIndexes(typeRecord, fieldTags)
+	if err != nil {
+		return nil, err
 	}
 
 	rf := new(RecordFile)
 	rf.typeRecord = typeRecord
+	rf.fieldTags = fieldTags
+	rf.indexDefs = indexDefs
 
 	return rf, nil
 }
 
+// Read reads the file, on error rf is not changed, the error of bad data
+// is Violations. See readSheet for the formats.
 func (rf *RecordFile) Read(name string) error {
-	file, err := os.Open(name)
+	return rf.read(name, false)
+}
+
+// with partial, the records are kept if the violations are all about the
+// values (not the parsing), so that they can still be referenced
+func (rf *RecordFile) read(name string, partial bool) error {
+	rf.init()
+	data, err := os.ReadFile(name)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	return rf.readData(name, data, partial)
+}
 
+func (rf *RecordFile) init() {
 	if rf.Comma == 0 {
 		rf.Comma = Comma
 	}
 	if rf.Comment == 0 {
 		rf.Comment = Comment
 	}
-	reader := csv.NewReader(file)
-	reader.Comma = rf.Comma
-	reader.Comment = rf.Comment
-	lines, err := reader.ReadAll()
+	if rf.HeaderRows <= 0 {
+		rf.HeaderRows = 1
+	}
+}
+
+func (rf *RecordFile) readData(name string, data []byte, partial bool) error {
+	s, err := rf.readSheet(name, data)
 	if err != nil {
 		return err
 	}
+	return rf.parse(name, s, partial)
+}
 
+func (rf *RecordFile) parse(name string, s *sheet, partial bool) error {
 	typeRecord := rf.typeRecord
+	lines := s.lines
 
-	// make records
-	records := make([]interface{}, len(lines)-1)
-
-	// make indexes
-	indexes := []Index{}
-	for i := 0; i < typeRecord.NumField(); i++ {
-		tag := typeRecord.Field(i).Tag
-		if tag == "index" {
-			indexes = append(indexes, make(Index))
+	// map the fields to the columns
+	cols := make([]int, typeRecord.NumField())
+	for i := range cols {
+		cols[i] = i
+	}
+	if s.names != nil {
+		var err error
+		if cols, err = rf.mapColumns(s.names); err != nil {
+			return Violations{&Violation{File: name, Msg: err.Error()}}
 		}
 	}
 
-	for n := 1; n < len(lines); n++ {
+	// make records
+	numRecord := len(lines) - s.headerRows
+	if numRecord < 0 {
+		numRecord = 0
+	}
+	records := make([]interface{}, numRecord)
+
+	// every violation is reported, the indexes are built only if every row
+	// is parsed
+	var vs Violations
+	fatal := false
+	for n := s.headerRows; n < len(lines); n++ {
 		value := reflect.New(typeRecord)
-		records[n-1] = value.Interface()
+		records[n-s.headerRows] = value.Interface()
 		record := value.Elem()
 
 		line := lines[n]
-		if len(line) != typeRecord.NumField() {
-			return fmt.Errorf("line %v, field count mismatch: %v (file) %v (st)",
-				n, len(line), typeRecord.NumField())
+		if s.names == nil && len(line) != typeRecord.NumField() {
+			vs = append(vs, &Violation{File: name, Row: n,
+				Msg: fmt.Sprintf("field count mismatch: %v (file) %v (st)", len(line), typeRecord.NumField())})
+			fatal = true
+			continue
 		}
 
-		iIndex := 0
-
 		for i := 0; i < typeRecord.NumField(); i++ {
 			f := typeRecord.Field(i)
 
 			// records
-			strField := line[i]
+			col := cols[i]
+			strField := ""
+			if col >= 0 && col < len(line) {
+				strField = line[col]
+			}
 			field := record.Field(i)
-			if !field.CanSet() {
+			if !field.CanSet() || col < 0 && rf.fieldTags[i].def == nil {
 				continue
 			}
+			if strField == "" && rf.fieldTags[i].def != nil {
+				strField = *rf.fieldTags[i].def
+			}
 
 			var err error
 
@@ -177,29 +233,108 @@ func (rf *RecordFile) Read(name string) error {
 			}
 
 			if err != nil {
-				return fmt.Errorf("parse field (row=%v, col=%v) error: %v",
-					n, i, err)
+				vs = append(vs, &Violation{File: name, Row: n, Col: col, Field: f.Name,
+					Msg: fmt.Sprintf("parse field error: %v", err)})
+				fatal = true
+				continue
 			}
-
-			// indexes
-			if f.Tag == "index" {
-				index := indexes[iIndex]
-				iIndex++
-				if _, ok := index[field.Interface()]; ok {
-					return fmt.Errorf("index error: duplicate at (row=%v, col=%v)",
-						n, i)
-				}
-				index[field.Interface()] = records[n-1]
+			if msg := rf.fieldTags[i].check(field); msg != "" {
+				vs = append(vs, &Violation{File: name, Row: n, Col: col, Field: f.Name, Msg: msg})
 			}
 		}
 	}
+	if fatal {
+		return vs
+	}
 
+	idx, ivs := rf.buildIndexes(records)
+	for _, v := range ivs {
+		v.File = name
+		v.Row += s.headerRows
+		v.Col = cols[v.Col]
+	}
+	vs = append(vs, ivs...)
+	vs.sort()
+	if len(vs) > 0 && !partial {
+		return vs
+	}
+
+	rf.path = name
+	rf.sourceHash = s.hash
+	rf.headerRows = s.headerRows
+	rf.cols = cols
 	rf.records = records
-	rf.indexes = indexes
+	rf.indexes = idx.indexes
+	rf.namedIndexes = idx.named
 
+	if len(vs) > 0 {
+		return vs
+	}
 	return nil
 }
 
+type indexes struct {
+	indexes []Index
+	named   map[string]*namedIndex
+}
+
+// buildIndexes indexes the records, the Row of a violation is the index of
+// the record and the Col is the field
+func (rf *RecordFile) buildIndexes(records []interface{}) (*indexes, Violations) {
+	typeRecord := rf.typeRecord
+
+	idx := new(indexes)
+	idx.indexes = []Index{}
+	for i := 0; i < typeRecord.NumField(); i++ {
+		tag := typeRecord.Field(i).Tag
+		if tag == "index" {
+			idx.indexes = append(idx.indexes, make(Index))
+		}
+	}
+	idx.named = make(map[string]*namedIndex, len(rf.indexDefs))
+	for _, def := range rf.indexDefs {
+		idx.named[def.name] = newNamedIndex(def)
+	}
+
+	var vs Violations
+	for n, r := range records {
+		record := reflect.ValueOf(r).Elem()
+
+		// the duplicates are reported by the named indexes
+		iIndex := 0
+		for i := 0; i < typeRecord.NumField(); i++ {
+			field := record.Field(i)
+			if typeRecord.Field(i).Tag == "index" && field.CanSet() {
+				index := idx.indexes[iIndex]
+				iIndex++
+				if _, ok := index[field.Interface()]; !ok {
+					index[field.Interface()] = r
+				}
+			}
+		}
+
+		for _, def := range rf.indexDefs {
+			if err := idx.named[def.name].add(record, r); err != nil {
+				vs = append(vs, &Violation{Row: n, Col: def.fields[0],
+					Field: typeRecord.Field(def.fields[0]).Name, Msg: err.Error()})
+			}
+		}
+	}
+	for _, index := range idx.named {
+		index.sort()
+	}
+	return idx, vs
+}
+
+// col returns the column of the field i in the file read, -1 if the field
+// is not read
+func (rf *RecordFile) col(i int) int {
+	if rf.cols == nil {
+		return i
+	}
+	return rf.cols[i]
+}
+
 func (rf *RecordFile) Record(i int) interface{} {
 	return rf.records[i]
 }
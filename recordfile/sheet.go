@@ -0,0 +1,388 @@
+package recordfile
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// the cells of a file
+type sheet struct {
+	lines      [][]string
+	headerRows int
+	// the column names, nil to map the columns by the field order
+	names []string
+	// the sha256 of the file
+	hash [sha256.Size]byte
+}
+
+// readSheet reads the data of a file by its extension: .xlsx, .json, .csv
+// (separated by comma unless Comma is not the default) or text separated
+// by Comma
+func (rf *RecordFile) readSheet(name string, data []byte) (*sheet, error) {
+	s, err := rf.decodeSheet(name, data)
+	if err != nil {
+		return nil, err
+	}
+	s.hash = sha256.Sum256(data)
+	return s, nil
+}
+
+func (rf *RecordFile) decodeSheet(name string, data []byte) (*sheet, error) {
+	var lines [][]string
+	var err error
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		return rf.readJSON(data)
+	case ".xlsx":
+		lines, err = readXLSX(data, rf.Sheet)
+		lines = skipComments(lines, rf.Comment)
+	case ".csv":
+		comma := rf.Comma
+		if comma == Comma {
+			comma = ','
+		}
+		lines, err = readCSV(data, comma, rf.Comment)
+	default:
+		lines, err = readCSV(data, rf.Comma, rf.Comment)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sheet{lines: lines, headerRows: rf.HeaderRows}
+	if rf.MapByName {
+		if rf.NameRow < 0 || rf.NameRow >= rf.HeaderRows {
+			return nil, fmt.Errorf("invalid NameRow %v", rf.NameRow)
+		}
+		if rf.NameRow >= len(lines) {
+			return nil, errors.New("header not found")
+		}
+		s.names = lines[rf.NameRow]
+	}
+	return s, nil
+}
+
+// ReadCells reads the cells of a text, .csv or .xlsx file, e.g. to read
+// the header rows. sheet is the sheet of a .xlsx file, empty means the
+// first one.
+func ReadCells(name string, sheet string) ([][]string, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".xlsx":
+		lines, err := readXLSX(data, sheet)
+		return skipComments(lines, Comment), err
+	case ".csv":
+		return readCSV(data, ',', Comment)
+	default:
+		return readCSV(data, Comma, Comment)
+	}
+}
+
+func readCSV(data []byte, comma rune, comment rune) ([][]string, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = comma
+	reader.Comment = comment
+	return reader.ReadAll()
+}
+
+// skipComments removes the rows whose first cell starts with comment, as
+// csv.Reader does for the text files
+func skipComments(lines [][]string, comment rune) [][]string {
+	n := 0
+	for _, line := range lines {
+		if len(line) > 0 && strings.HasPrefix(line[0], string(comment)) {
+			continue
+		}
+		lines[n] = line
+		n++
+	}
+	return lines[:n]
+}
+
+// colName returns the column name of field i, "" if it is not read
+func (rf *RecordFile) colName(i int) string {
+	f := rf.typeRecord.Field(i)
+	if f.Tag == "index" {
+		return f.Name
+	}
+	switch name := f.Tag.Get("col"); name {
+	case "-":
+		return ""
+	case "":
+		return f.Name
+	default:
+		return name
+	}
+}
+
+// mapColumns returns the column of every field, -1 if the field is not read
+func (rf *RecordFile) mapColumns(names []string) ([]int, error) {
+	byName := make(map[string]int, len(names))
+	for i, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || strings.HasPrefix(name, string(rf.Comment)) {
+			continue
+		}
+		if _, ok := byName[name]; ok {
+			return nil, fmt.Errorf("duplicate column %v", names[i])
+		}
+		byName[name] = i
+	}
+
+	cols := make([]int, rf.typeRecord.NumField())
+	for i := range cols {
+		cols[i] = -1
+		name := rf.colName(i)
+		if name == "" || rf.typeRecord.Field(i).PkgPath != "" {
+			continue
+		}
+		col, ok := byName[strings.ToLower(name)]
+		if !ok && rf.fieldTags[i].def == nil {
+			return nil, fmt.Errorf("column %v not found", name)
+		}
+		if ok {
+			cols[i] = col
+		}
+	}
+	return cols, nil
+}
+
+// readJSON reads an array of objects, the keys are the column names, a
+// string is the text of a cell and any other value is its json
+func (rf *RecordFile) readJSON(data []byte) (*sheet, error) {
+	var objects []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &objects); err != nil {
+		return nil, err
+	}
+
+	s := &sheet{headerRows: 1}
+	for i := 0; i < rf.typeRecord.NumField(); i++ {
+		s.names = append(s.names, rf.colName(i))
+	}
+	s.lines = append(s.lines, s.names)
+	for _, o := range objects {
+		values := make(map[string]json.RawMessage, len(o))
+		for k, v := range o {
+			values[strings.ToLower(k)] = v
+		}
+
+		line := make([]string, len(s.names))
+		for i, name := range s.names {
+			v, ok := values[strings.ToLower(name)]
+			if !ok || name == "" || string(v) == "null" {
+				continue
+			}
+			var str string
+			if json.Unmarshal(v, &str) == nil {
+				line[i] = str
+			} else {
+				line[i] = string(v)
+			}
+		}
+		s.lines = append(s.lines, line)
+	}
+	return s, nil
+}
+
+// a minimal reader of the cell values of a .xlsx sheet
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRels struct {
+	Rels []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t *xlsxText) String() string {
+	if len(t.Runs) == 0 {
+		return t.T
+	}
+	var b strings.Builder
+	for _, r := range t.Runs {
+		b.WriteString(r.T)
+	}
+	return b.String()
+}
+
+type xlsxSST struct {
+	SI []xlsxText `xml:"si"`
+}
+
+type xlsxSheet struct {
+	Rows []struct {
+		R     int `xml:"r,attr"`
+		Cells []struct {
+			R  string   `xml:"r,attr"`
+			T  string   `xml:"t,attr"`
+			V  string   `xml:"v"`
+			IS xlsxText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+func readXLSX(data []byte, sheetName string) ([][]string, error) {
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]*zip.File, len(z.File))
+	for _, f := range z.File {
+		files[f.Name] = f
+	}
+	decode := func(name string, v interface{}) error {
+		f, ok := files[name]
+		if !ok {
+			return fmt.Errorf("xlsx: %v not found", name)
+		}
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		return xml.NewDecoder(r).Decode(v)
+	}
+
+	// find the sheet
+	var wb xlsxWorkbook
+	if err := decode("xl/workbook.xml", &wb); err != nil {
+		return nil, err
+	}
+	rid := ""
+	for _, s := range wb.Sheets {
+		if sheetName == "" || s.Name == sheetName {
+			rid = s.RID
+			break
+		}
+	}
+	if rid == "" {
+		return nil, fmt.Errorf("xlsx: sheet %v not found", sheetName)
+	}
+	var rels xlsxRels
+	if err := decode("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	target := ""
+	for _, r := range rels.Rels {
+		if r.ID == rid {
+			target = r.Target
+		}
+	}
+	if strings.HasPrefix(target, "/") {
+		target = target[1:]
+	} else {
+		target = path.Join("xl", target)
+	}
+
+	var sst xlsxSST
+	if _, ok := files["xl/sharedStrings.xml"]; ok {
+		if err := decode("xl/sharedStrings.xml", &sst); err != nil {
+			return nil, err
+		}
+	}
+	var ws xlsxSheet
+	if err := decode(target, &ws); err != nil {
+		return nil, err
+	}
+
+	var lines [][]string
+	for i, row := range ws.Rows {
+		// rows and cells may be sparse
+		n := row.R - 1
+		if n < 0 {
+			n = i
+		}
+		for len(lines) <= n {
+			lines = append(lines, nil)
+		}
+		var line []string
+		for j, c := range row.Cells {
+			col := j
+			if c.R != "" {
+				if col, err = xlsxColumn(c.R); err != nil {
+					return nil, err
+				}
+			}
+			for len(line) <= col {
+				line = append(line, "")
+			}
+
+			switch c.T {
+			case "s":
+				k, err := strconv.Atoi(c.V)
+				if err != nil || k < 0 || k >= len(sst.SI) {
+					return nil, fmt.Errorf("xlsx: invalid shared string %v", c.V)
+				}
+				line[col] = sst.SI[k].String()
+			case "inlineStr":
+				line[col] = c.IS.String()
+			default:
+				line[col] = c.V
+			}
+		}
+		lines[n] = line
+	}
+
+	// drop the empty rows, like the blank lines of a text file, and fill
+	// the missing cells at the end of the rows
+	var nonEmpty [][]string
+	width := 0
+	for _, line := range lines {
+		for _, cell := range line {
+			if cell != "" {
+				nonEmpty = append(nonEmpty, line)
+				if len(line) > width {
+					width = len(line)
+				}
+				break
+			}
+		}
+	}
+	for i, line := range nonEmpty {
+		for len(line) < width {
+			line = append(line, "")
+		}
+		nonEmpty[i] = line
+	}
+	return nonEmpty, nil
+}
+
+// xlsxColumn returns the column index of a cell reference like "AB12"
+func xlsxColumn(ref string) (int, error) {
+	col := 0
+	i := 0
+	for ; i < len(ref) && ref[i] >= 'A' && ref[i] <= 'Z'; i++ {
+		col = col*26 + int(ref[i]-'A'+1)
+	}
+	if i == 0 {
+		return 0, fmt.Errorf("xlsx: invalid cell %v", ref)
+	}
+	return col - 1, nil
+}
@@ -0,0 +1,249 @@
+package recordfile
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+
+	"github.com/CreFire/leaf/util/cstruct-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// a snapshot is
+//
+//	magic | version uint16 | source sha256 | type sha256 | header rows uint16 |
+//	column of every field int32 | record count uint32 |
+//	(record size uint32 | cstruct record)...
+//
+// in little endian, a snapshot is used only if its version, the sha256 of
+// the source file and the sha256 of the record type and the read settings
+// all match
+var snapshotMagic = []byte("LEAFSNAP")
+
+// bump it on any change of the layout or of the cstruct encoding
+const snapshotVersion uint16 = 2
+
+var errStaleSnapshot = errors.New("stale snapshot")
+
+// WriteSnapshot writes the records read last to a snapshot of their source
+// file, the record type must be supported by cstruct
+func (rf *RecordFile) WriteSnapshot(name string) error {
+	if rf.path == "" {
+		return errors.New("no file read")
+	}
+	if err := cstructSupported(rf.typeRecord); err != nil {
+		return err
+	}
+
+	// written aside and renamed, a reader never sees a partial snapshot
+	tmp := name + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	err = rf.writeSnapshot(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err2 := file.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(tmp, name)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+func (rf *RecordFile) writeSnapshot(w io.Writer) error {
+	fingerprint := rf.fingerprint()
+	head := new(bytes.Buffer)
+	head.Write(snapshotMagic)
+	binary.Write(head, binary.LittleEndian, snapshotVersion)
+	head.Write(rf.sourceHash[:])
+	head.Write(fingerprint[:])
+	binary.Write(head, binary.LittleEndian, uint16(rf.headerRows))
+	for i := 0; i < rf.typeRecord.NumField(); i++ {
+		binary.Write(head, binary.LittleEndian, int32(rf.col(i)))
+	}
+	binary.Write(head, binary.LittleEndian, uint32(len(rf.records)))
+	if _, err := w.Write(head.Bytes()); err != nil {
+		return err
+	}
+
+	var size [4]byte
+	for _, r := range rf.records {
+		data, err := cstruct.Marshal(r)
+		if err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint32(size[:], uint32(len(data)))
+		if _, err := w.Write(size[:]); err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadWithSnapshot reads the file name from the snapshot if it is up to
+// date, otherwise it reads the file and rewrites the snapshot. A snapshot
+// which can not be read or written is logged and ignored.
+//
+//	rf.ReadWithSnapshot("gamedata/item.txt", "cache/item.snap")
+func (rf *RecordFile) ReadWithSnapshot(name string, snapshot string) error {
+	rf.init()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	err = rf.readSnapshot(snapshot, name, sha256.Sum256(data))
+	if err == nil {
+		return nil
+	}
+	if err != errStaleSnapshot && !os.IsNotExist(err) {
+		log.Warnf("read snapshot %v: %v", snapshot, err)
+	}
+
+	if err := rf.readData(name, data, false); err != nil {
+		return err
+	}
+	if err := rf.WriteSnapshot(snapshot); err != nil {
+		log.Warnf("write snapshot %v: %v", snapshot, err)
+	}
+	return nil
+}
+
+// readSnapshot reads the snapshot of the file source whose sha256 is hash,
+// on error rf is not changed
+func (rf *RecordFile) readSnapshot(name string, source string, hash [sha256.Size]byte) (err error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	numField := rf.typeRecord.NumField()
+	headSize := len(snapshotMagic) + 2 + 2*sha256.Size + 2 + 4*numField + 4
+	if len(data) < headSize || !bytes.HasPrefix(data, snapshotMagic) {
+		return errors.New("not a recordfile snapshot")
+	}
+	head := data[len(snapshotMagic):]
+	fingerprint := rf.fingerprint()
+	if binary.LittleEndian.Uint16(head) != snapshotVersion ||
+		!bytes.Equal(head[2:2+sha256.Size], hash[:]) ||
+		!bytes.Equal(head[2+sha256.Size:2+2*sha256.Size], fingerprint[:]) {
+		return errStaleSnapshot
+	}
+	head = head[2+2*sha256.Size:]
+	headerRows := int(binary.LittleEndian.Uint16(head))
+	head = head[2:]
+	cols := make([]int, numField)
+	for i := range cols {
+		cols[i] = int(int32(binary.LittleEndian.Uint32(head[4*i:])))
+	}
+	numRecord := int(binary.LittleEndian.Uint32(head[4*numField:]))
+	data = data[headSize:]
+
+	// the type was checked by the fingerprint, a panic is bad data
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("bad snapshot: %v", r)
+		}
+	}()
+	records := make([]interface{}, 0, numRecord)
+	for i := 0; i < numRecord; i++ {
+		if len(data) < 4 {
+			return io.ErrUnexpectedEOF
+		}
+		size := binary.LittleEndian.Uint32(data)
+		data = data[4:]
+		if uint64(len(data)) < uint64(size) {
+			return io.ErrUnexpectedEOF
+		}
+		r := reflect.New(rf.typeRecord).Interface()
+		if err := cstruct.Unmarshal(data[:size], r); err != nil {
+			return err
+		}
+		records = append(records, r)
+		data = data[size:]
+	}
+	if len(data) > 0 {
+		return errors.New("bad snapshot: trailing data")
+	}
+
+	idx, vs := rf.buildIndexes(records)
+	if len(vs) > 0 {
+		return vs
+	}
+
+	rf.path = source
+	rf.sourceHash = hash
+	rf.headerRows = headerRows
+	rf.cols = cols
+	rf.records = records
+	rf.indexes = idx.indexes
+	rf.namedIndexes = idx.named
+	return nil
+}
+
+// fingerprint is the sha256 of what the records depend on but the source
+func (rf *RecordFile) fingerprint() [sha256.Size]byte {
+	h := sha256.New()
+	fmt.Fprintf(h, "%q %q %v %v %v %q\n", rf.Comma, rf.Comment, rf.HeaderRows,
+		rf.MapByName, rf.NameRow, rf.Sheet)
+	fmt.Fprintf(h, "%v %v %v %v\n", cstruct.OptionSliceIgnoreNil, cstruct.OptionIntSize,
+		cstruct.OptionLenMode, cstruct.OptionVarint)
+	writeType(h, rf.typeRecord, make(map[reflect.Type]bool))
+
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+func writeType(w io.Writer, t reflect.Type, seen map[reflect.Type]bool) {
+	fmt.Fprintf(w, "%v(%v)", t, t.Kind())
+	switch t.Kind() {
+	case reflect.Struct:
+		if seen[t] {
+			return
+		}
+		seen[t] = true
+		io.WriteString(w, "{")
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			fmt.Fprintf(w, "%v %q ", f.Name, f.Tag)
+			writeType(w, f.Type, seen)
+			io.WriteString(w, ";")
+		}
+		io.WriteString(w, "}")
+	case reflect.Map:
+		writeType(w, t.Key(), seen)
+		writeType(w, t.Elem(), seen)
+	case reflect.Array, reflect.Slice, reflect.Ptr:
+		writeType(w, t.Elem(), seen)
+	}
+}
+
+// cstructSupported checks t by building its cstruct properties, which
+// panics on an unsupported field
+func cstructSupported(t reflect.Type) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	cstruct.GetProperties(t)
+	return nil
+}
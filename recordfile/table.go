@@ -0,0 +1,138 @@
+package recordfile
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Table is a RecordFile of Row, Row must be a struct
+type Table[Row any] struct {
+	rf   *RecordFile
+	rows []*Row
+}
+
+// Load reads the file of Row
+//
+// items, err := recordfile.Load[Item]("item.txt")
+func Load[Row any](path string) (*Table[Row], error) {
+	return LoadWith[Row](path, nil)
+}
+
+// LoadWith is Load with the RecordFile set by setup before reading, e.g.
+// to set MapByName
+func LoadWith[Row any](path string, setup func(rf *RecordFile)) (*Table[Row], error) {
+	var st Row
+	rf, err := New(st)
+	if err != nil {
+		return nil, err
+	}
+	if setup != nil {
+		setup(rf)
+	}
+	if err := rf.Read(path); err != nil {
+		return nil, err
+	}
+	return NewTable[Row](rf)
+}
+
+// NewTable wraps a read RecordFile, e.g. one from Manager.Get
+func NewTable[Row any](rf *RecordFile) (*Table[Row], error) {
+	if rf.typeRecord != reflect.TypeOf((*Row)(nil)).Elem() {
+		return nil, fmt.Errorf("record type mismatch: %v (file) %v (Row)",
+			rf.typeRecord, reflect.TypeOf((*Row)(nil)).Elem())
+	}
+
+	t := &Table[Row]{rf: rf, rows: make([]*Row, len(rf.records))}
+	for i, r := range rf.records {
+		t.rows[i] = r.(*Row)
+	}
+	return t, nil
+}
+
+func (t *Table[Row]) RecordFile() *RecordFile {
+	return t.rf
+}
+
+func (t *Table[Row]) Len() int {
+	return len(t.rows)
+}
+
+func (t *Table[Row]) Row(i int) *Row {
+	return t.rows[i]
+}
+
+// All returns the rows in file order, it must not be modified
+func (t *Table[Row]) All() []*Row {
+	return t.rows
+}
+
+// Get looks up key in the first index of Row
+func (t *Table[Row]) Get(key interface{}) *Row {
+	if len(t.rf.indexDefs) == 0 {
+		return nil
+	}
+	return t.Lookup(t.rf.indexDefs[0].name, key)
+}
+
+func (t *Table[Row]) Filter(f func(r *Row) bool) []*Row {
+	var rows []*Row
+	for _, r := range t.rows {
+		if f(r) {
+			rows = append(rows, r)
+		}
+	}
+	return rows
+}
+
+// see RecordFile.Lookup
+func (t *Table[Row]) Lookup(name string, key ...interface{}) *Row {
+	r, _ := t.rf.Lookup(name, key...).(*Row)
+	return r
+}
+
+// see RecordFile.LookupAll
+func (t *Table[Row]) LookupAll(name string, key ...interface{}) []*Row {
+	records := t.rf.LookupAll(name, key...)
+	rows := make([]*Row, len(records))
+	for i, r := range records {
+		rows[i] = r.(*Row)
+	}
+	return rows
+}
+
+// see RecordFile.Range
+func (t *Table[Row]) Range(name string, from interface{}, to interface{}, f func(r *Row) bool) {
+	t.rf.Range(name, from, to, func(r interface{}) bool {
+		return f(r.(*Row))
+	})
+}
+
+// KeyIndex is a typed index of a single field of type K
+type KeyIndex[K comparable, Row any] struct {
+	t    *Table[Row]
+	name string
+}
+
+// IndexOf returns the index name of t, it panics if the index is not a
+// single field of type K
+//
+// byName := recordfile.IndexOf[string](items, "name")
+// item := byName.Get("sword")
+func IndexOf[K comparable, Row any](t *Table[Row], name string) *KeyIndex[K, Row] {
+	i := t.rf.named(name)
+	if len(i.def.fields) != 1 {
+		panic(fmt.Sprintf("index %v: composite index", name))
+	}
+	if ft := t.rf.typeRecord.Field(i.def.fields[0]).Type; ft != reflect.TypeOf((*K)(nil)).Elem() {
+		panic(fmt.Sprintf("index %v: key type mismatch: %v (field) %v (K)", name, ft, reflect.TypeOf((*K)(nil)).Elem()))
+	}
+	return &KeyIndex[K, Row]{t: t, name: name}
+}
+
+func (i *KeyIndex[K, Row]) Get(key K) *Row {
+	return i.t.Lookup(i.name, key)
+}
+
+func (i *KeyIndex[K, Row]) All(key K) []*Row {
+	return i.t.LookupAll(i.name, key)
+}
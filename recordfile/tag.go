@@ -0,0 +1,133 @@
+package recordfile
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// field options, in the tag rf:"index=name,multi"
+//
+//	index=name      the field is (a part of) the index name, the fields of
+//	                one index make a composite key in field order, index
+//	                alone names the index after the field
+//	multi           the index before is not unique
+//	sorted          the index before supports Range, single field only
+//	ref=table.Field the value (each element of an array or a slice) must be
+//	                a Field value of table, Field is an index name or a
+//	                field name, checked by CheckRefs
+//	min=n, max=n    the number must be in the range
+//	enum=a|b|c      the value must be one of them
+//	required        the value must not be zero or empty
+//	default=v       the text of an empty cell
+type fieldTag struct {
+	indexes  []*indexOpt
+	refTable string
+	refField string
+	min, max *float64
+	enum     []string
+	required bool
+	def      *string
+}
+
+type indexOpt struct {
+	name   string
+	multi  bool
+	sorted bool
+}
+
+func parseTags(typeRecord reflect.Type) ([]*fieldTag, error) {
+	tags := make([]*fieldTag, typeRecord.NumField())
+	for i := range tags {
+		t, err := parseTag(typeRecord.Field(i))
+		if err != nil {
+			return nil, err
+		}
+		tags[i] = t
+	}
+	return tags, nil
+}
+
+func parseTag(f reflect.StructField) (*fieldTag, error) {
+	t := new(fieldTag)
+	if f.Tag == "index" {
+		// not settable, skipped by Read
+		if f.PkgPath == "" {
+			t.indexes = append(t.indexes, &indexOpt{name: f.Name})
+		}
+		return t, nil
+	}
+
+	tag, ok := f.Tag.Lookup("rf")
+	if !ok {
+		return t, nil
+	}
+	if f.PkgPath != "" {
+		return nil, fmt.Errorf("field %v: unexported", f.Name)
+	}
+	var last *indexOpt
+	for _, opt := range strings.Split(tag, ",") {
+		opt = strings.TrimSpace(opt)
+		name, value := opt, ""
+		if i := strings.Index(opt, "="); i >= 0 {
+			name, value = opt[:i], opt[i+1:]
+		}
+		switch name {
+		case "":
+		case "index":
+			if value == "" {
+				value = f.Name
+			}
+			last = &indexOpt{name: value}
+			t.indexes = append(t.indexes, last)
+		case "multi", "sorted":
+			if last == nil {
+				return nil, fmt.Errorf("field %v: %v without index", f.Name, opt)
+			}
+			if name == "multi" {
+				last.multi = true
+			} else {
+				last.sorted = true
+			}
+		case "ref":
+			i := strings.LastIndex(value, ".")
+			if i <= 0 || i == len(value)-1 {
+				return nil, fmt.Errorf("field %v: invalid ref %v", f.Name, value)
+			}
+			t.refTable, t.refField = value[:i], value[i+1:]
+		case "min", "max":
+			if !isNumber(f.Type.Kind()) {
+				return nil, fmt.Errorf("field %v: %v of %v", f.Name, name, f.Type)
+			}
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("field %v: invalid %v %v", f.Name, name, value)
+			}
+			if name == "min" {
+				t.min = &v
+			} else {
+				t.max = &v
+			}
+		case "enum":
+			t.enum = strings.Split(value, "|")
+		case "required":
+			t.required = true
+		case "default":
+			t.def = &value
+		default:
+			return nil, fmt.Errorf("field %v: invalid tag option %v", f.Name, opt)
+		}
+	}
+	return t, nil
+}
+
+func isNumber(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
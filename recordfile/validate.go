@@ -0,0 +1,224 @@
+package recordfile
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Violation is a bad cell, Row counts from 1 after the header like Read
+type Violation struct {
+	File  string
+	Row   int
+	Col   int
+	Field string
+	Msg   string
+}
+
+func (v *Violation) Error() string {
+	s := fmt.Sprintf("(row=%v, col=%v) %v: %v", v.Row, v.Col, v.Field, v.Msg)
+	if v.File != "" {
+		s = v.File + " " + s
+	}
+	return s
+}
+
+type Violations []*Violation
+
+func (vs Violations) Error() string {
+	s := make([]string, len(vs))
+	for i, v := range vs {
+		s[i] = v.Error()
+	}
+	return strings.Join(s, "\n")
+}
+
+func (vs Violations) sort() {
+	sort.SliceStable(vs, func(i, j int) bool {
+		if vs[i].File != vs[j].File {
+			return vs[i].File < vs[j].File
+		}
+		if vs[i].Row != vs[j].Row {
+			return vs[i].Row < vs[j].Row
+		}
+		return vs[i].Col < vs[j].Col
+	})
+}
+
+// check checks the constraints of a field but the ref
+func (t *fieldTag) check(field reflect.Value) string {
+	if t.required {
+		switch field.Kind() {
+		case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+			if field.Len() == 0 {
+				return "required"
+			}
+		default:
+			if field.IsZero() {
+				return "required"
+			}
+		}
+	}
+
+	if t.min != nil || t.max != nil {
+		var v float64
+		switch field.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			v = float64(field.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			v = float64(field.Uint())
+		default:
+			v = field.Float()
+		}
+		if t.min != nil && v < *t.min {
+			return fmt.Sprintf("%v less than min %v", v, *t.min)
+		}
+		if t.max != nil && v > *t.max {
+			return fmt.Sprintf("%v greater than max %v", v, *t.max)
+		}
+	}
+
+	if t.enum != nil {
+		s := fmt.Sprint(field.Interface())
+		for _, e := range t.enum {
+			if s == e {
+				return ""
+			}
+		}
+		return fmt.Sprintf("%v not in %v", s, strings.Join(t.enum, "|"))
+	}
+	return ""
+}
+
+// CheckRefs checks the ref options of the tables against each other, the
+// keys are the table names used by ref
+func CheckRefs(tables map[string]*RecordFile) Violations {
+	return checkRefs(tables, nil)
+}
+
+// the refs to the broken tables are skipped
+func checkRefs(tables map[string]*RecordFile, broken map[string]bool) Violations {
+	var vs Violations
+	sets := make(map[string]*valueSet)
+
+	for _, rf := range tables {
+		for i, t := range rf.fieldTags {
+			if t.refTable == "" || broken[t.refTable] {
+				continue
+			}
+			f := rf.typeRecord.Field(i)
+
+			target, ok := tables[t.refTable]
+			if !ok {
+				vs = append(vs, &Violation{File: rf.path, Col: rf.col(i), Field: f.Name,
+					Msg: fmt.Sprintf("ref table %v not found", t.refTable)})
+				continue
+			}
+			ref := t.refTable + "." + t.refField
+			set, ok := sets[ref]
+			if !ok {
+				set = target.valueSet(t.refField)
+				sets[ref] = set
+			}
+			if set == nil {
+				vs = append(vs, &Violation{File: rf.path, Col: rf.col(i), Field: f.Name,
+					Msg: fmt.Sprintf("ref field %v not found", ref)})
+				continue
+			}
+
+			for n, r := range rf.records {
+				field := reflect.ValueOf(r).Elem().Field(i)
+				values := []reflect.Value{field}
+				if field.Kind() == reflect.Slice || field.Kind() == reflect.Array {
+					values = values[:0]
+					for e := 0; e < field.Len(); e++ {
+						values = append(values, field.Index(e))
+					}
+				}
+				for _, v := range values {
+					if !set.has(v) {
+						vs = append(vs, &Violation{File: rf.path, Row: n + rf.headerRows, Col: rf.col(i), Field: f.Name,
+							Msg: fmt.Sprintf("%v not found in %v", v.Interface(), ref)})
+					}
+				}
+			}
+		}
+	}
+	vs.sort()
+	return vs
+}
+
+type valueSet struct {
+	typ    reflect.Type
+	values map[interface{}]bool
+}
+
+func (s *valueSet) has(v reflect.Value) bool {
+	key, ok := convert(v.Interface(), s.typ)
+	return ok && s.values[key.Interface()]
+}
+
+// valueSet returns the values of a field, nil if name is neither a single
+// field index nor a field
+func (rf *RecordFile) valueSet(name string) *valueSet {
+	field := -1
+	if i, ok := rf.namedIndexes[name]; ok && len(i.def.fields) == 1 {
+		field = i.def.fields[0]
+	} else if f, ok := rf.typeRecord.FieldByName(name); ok && len(f.Index) == 1 {
+		field = f.Index[0]
+	}
+	if field < 0 {
+		return nil
+	}
+
+	set := &valueSet{typ: rf.typeRecord.Field(field).Type, values: make(map[interface{}]bool, len(rf.records))}
+	for _, r := range rf.records {
+		set.values[reflect.ValueOf(r).Elem().Field(field).Interface()] = true
+	}
+	return set
+}
+
+// ValidateDir reads the tables in dir and checks them all, tables maps the
+// table names to the record structs, the file of a table is name + ext.
+// It returns every violation found, the refs to a table which could not be
+// parsed are not checked.
+//
+//	vs := recordfile.ValidateDir("gamedata", ".txt", map[string]interface{}{
+//		"item": Item{},
+//		"drop": Drop{},
+//	})
+func ValidateDir(dir string, ext string, tables map[string]interface{}) Violations {
+	var vs Violations
+	files := make(map[string]*RecordFile, len(tables))
+	for name, st := range tables {
+		path := filepath.Join(dir, name+ext)
+		rf, err := New(st)
+		if err != nil {
+			vs = append(vs, &Violation{File: path, Msg: err.Error()})
+			continue
+		}
+		if err := rf.read(path, true); err != nil {
+			if v, ok := err.(Violations); ok {
+				vs = append(vs, v...)
+			} else {
+				vs = append(vs, &Violation{File: path, Msg: err.Error()})
+			}
+		}
+		// read with bad values
+		if rf.path != "" {
+			files[name] = rf
+		}
+	}
+
+	broken := make(map[string]bool)
+	for name := range tables {
+		if files[name] == nil {
+			broken[name] = true
+		}
+	}
+	vs = append(vs, checkRefs(files, broken)...)
+	vs.sort()
+	return vs
+}
@@ -0,0 +1,84 @@
+package room_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/CreFire/leaf/gate"
+	"github.com/CreFire/leaf/network/cstruct"
+	"github.com/CreFire/leaf/room"
+	"github.com/CreFire/leaf/timer"
+)
+
+// agent implements only WriteMsg, the other methods are not called
+type agent struct {
+	gate.Agent
+	name string
+}
+
+func (a *agent) WriteMsg(recv *cstruct.RecvMsg, mainCmdID uint16, subCmdID uint16, msg interface{}) {
+	fmt.Printf("%v <- [%v,%v] %v\n", a.name, mainCmdID, subCmdID, msg)
+}
+
+type Move struct {
+	X, Y int32
+}
+
+type battle struct{}
+
+func (b *battle) OnCreate(r *room.Room) {
+	fmt.Println("create", r.ID())
+}
+
+func (b *battle) OnJoin(r *room.Room, m *room.Member) {
+	fmt.Println("join", m.ID)
+}
+
+func (b *battle) OnLeave(r *room.Room, m *room.Member) {
+	fmt.Println("leave", m.ID)
+}
+
+func (b *battle) OnTick(r *room.Room, frame uint64, dt time.Duration) {
+	r.Broadcast(1, 2, fmt.Sprintf("frame %v", frame))
+	if frame == 2 {
+		r.Destroy()
+	}
+}
+
+func (b *battle) OnDestroy(r *room.Room) {
+	fmt.Println("destroy", r.ID())
+}
+
+func Example() {
+	d := timer.NewDispatcher(10)
+	mgr := room.NewManager(d)
+	mgr.Handle(1, 1, func(r *room.Room, m *room.Member, msg interface{}) {
+		move := msg.(*Move)
+		fmt.Println(m.ID, "moves to", move.X, move.Y)
+	})
+
+	r, _ := mgr.Create("room-1", 20, new(battle))
+	a := &agent{name: "a"}
+	mgr.Join(r.ID(), 1001, a, nil)
+
+	// as routed by cstruct.Processor
+	mgr.Route([]interface{}{
+		&cstruct.RecvMsg{MsgId: cstruct.MakeDWORD(1, 1), Msg: &Move{X: 3, Y: 4}},
+		a,
+	})
+
+	// dispatch
+	(<-d.ChanTimer).Cb()
+	(<-d.ChanTimer).Cb()
+	fmt.Println(mgr.NumRoom())
+
+	// Output:
+	// create room-1
+	// join 1001
+	// 1001 moves to 3 4
+	// a <- [1,2] frame 1
+	// a <- [1,2] frame 2
+	// leave 1001
+	// destroy room-1
+	// 0
+}
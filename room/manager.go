@@ -0,0 +1,168 @@
+package room
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/CreFire/leaf/gate"
+	"github.com/CreFire/leaf/network/cstruct"
+	"github.com/CreFire/leaf/timer"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrRoomExists   = errors.New("room already exists")
+	ErrRoomNotFound = errors.New("room not found")
+	ErrMemberExists = errors.New("member already in a room")
+)
+
+type Handler func(r *Room, m *Member, msg interface{})
+
+// Manager owns the rooms of a module, it must be used on the goroutine of
+// the clock, e.g. NewManager(skeleton) in a module
+// one manager per goroutine (goroutine not safe)
+type Manager struct {
+	clock    timer.Clock
+	rooms    map[interface{}]*Room
+	agents   map[gate.Agent]*Member
+	handlers map[uint32]Handler
+}
+
+func NewManager(clock timer.Clock) *Manager {
+	mgr := new(Manager)
+	mgr.clock = clock
+	mgr.rooms = make(map[interface{}]*Room)
+	mgr.agents = make(map[gate.Agent]*Member)
+	mgr.handlers = make(map[uint32]Handler)
+	return mgr
+}
+
+// Create creates a room ticking hz times per second, hz 0 means no tick
+func (mgr *Manager) Create(id interface{}, hz int, logic Logic) (*Room, error) {
+	if _, ok := mgr.rooms[id]; ok {
+		return nil, ErrRoomExists
+	}
+	if hz < 0 {
+		return nil, fmt.Errorf("invalid hz %v", hz)
+	}
+
+	r := new(Room)
+	r.id = id
+	r.hz = hz
+	r.logic = logic
+	r.mgr = mgr
+	r.members = make(map[interface{}]*Member)
+	mgr.rooms[id] = r
+
+	logic.OnCreate(r)
+	if hz > 0 {
+		r.start = time.Now()
+		r.schedule()
+	}
+	return r, nil
+}
+
+func (mgr *Manager) Get(id interface{}) *Room {
+	return mgr.rooms[id]
+}
+
+func (mgr *Manager) NumRoom() int {
+	return len(mgr.rooms)
+}
+
+// Destroy makes all members leave and stops the tick
+func (mgr *Manager) Destroy(id interface{}) {
+	r, ok := mgr.rooms[id]
+	if !ok {
+		return
+	}
+
+	for len(r.order) > 0 {
+		mgr.leave(r.order[len(r.order)-1])
+	}
+	if r.t != nil {
+		r.t.Stop()
+	}
+	r.destroyed = true
+	delete(mgr.rooms, id)
+	r.logic.OnDestroy(r)
+}
+
+// Join adds agent to a room, an agent can only be in one room at a time
+func (mgr *Manager) Join(roomID interface{}, memberID interface{}, agent gate.Agent, data interface{}) (*Member, error) {
+	r, ok := mgr.rooms[roomID]
+	if !ok {
+		return nil, ErrRoomNotFound
+	}
+	if _, ok := mgr.agents[agent]; ok {
+		return nil, ErrMemberExists
+	}
+	if _, ok := r.members[memberID]; ok {
+		return nil, ErrMemberExists
+	}
+
+	m := &Member{ID: memberID, Agent: agent, Data: data, room: r}
+	r.members[memberID] = m
+	r.order = append(r.order, m)
+	mgr.agents[agent] = m
+	r.logic.OnJoin(r, m)
+	return m, nil
+}
+
+// Leave removes agent from its room, call it on CloseAgent
+func (mgr *Manager) Leave(agent gate.Agent) {
+	if m, ok := mgr.agents[agent]; ok {
+		mgr.leave(m)
+	}
+}
+
+func (mgr *Manager) leave(m *Member) {
+	r := m.room
+	delete(r.members, m.ID)
+	for i, o := range r.order {
+		if o == m {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+	delete(mgr.agents, m.Agent)
+	r.logic.OnLeave(r, m)
+}
+
+func (mgr *Manager) MemberOf(agent gate.Agent) *Member {
+	return mgr.agents[agent]
+}
+
+// Handle sets the handler of a room message, the message must be routed to
+// Route, for example:
+//
+// mgr.Handle(mainCmdID, subCmdID, h)
+// msg.Register(mainCmdID, subCmdID, &Move{}, ChanRPC, mgr.Route)
+func (mgr *Manager) Handle(mainCmdID uint16, subCmdID uint16, h Handler) {
+	id := cstruct.MakeDWORD(mainCmdID, subCmdID)
+	if _, ok := mgr.handlers[id]; ok {
+		panic(fmt.Sprintf("message %v,%v: handler already set", mainCmdID, subCmdID))
+	}
+	mgr.handlers[id] = h
+}
+
+// Route is a chanrpc function, args: (*cstruct.RecvMsg, gate.Agent)
+func (mgr *Manager) Route(args []interface{}) {
+	recv := args[0].(*cstruct.RecvMsg)
+	agent := args[1].(gate.Agent)
+
+	h, ok := mgr.handlers[recv.MsgId]
+	if !ok {
+		mainCmdID, subCmdID := cstruct.GetCmd(recv.MsgId)
+		log.Errorf("room message %v,%v: no handler", mainCmdID, subCmdID)
+		return
+	}
+	m, ok := mgr.agents[agent]
+	if !ok {
+		mainCmdID, subCmdID := cstruct.GetCmd(recv.MsgId)
+		log.Debugf("room message %v,%v: agent %v not in a room", mainCmdID, subCmdID, agent.RemoteAddr())
+		return
+	}
+	h(m.room, m, recv.Msg)
+}
@@ -0,0 +1,125 @@
+package room
+
+import (
+	"time"
+
+	"github.com/CreFire/leaf/gate"
+	"github.com/CreFire/leaf/network/cstruct"
+	"github.com/CreFire/leaf/timer"
+)
+
+// Logic is the game logic of a room, all methods are called on the
+// goroutine of the room manager
+type Logic interface {
+	OnCreate(r *Room)
+	OnJoin(r *Room, m *Member)
+	OnLeave(r *Room, m *Member)
+	// frame starts from 1, dt is the fixed tick interval
+	OnTick(r *Room, frame uint64, dt time.Duration)
+	OnDestroy(r *Room)
+}
+
+type Member struct {
+	ID    interface{}
+	Agent gate.Agent
+	Data  interface{}
+	room  *Room
+}
+
+func (m *Member) Room() *Room {
+	return m.room
+}
+
+func (m *Member) WriteMsg(mainCmdID uint16, subCmdID uint16, msg interface{}) {
+	m.Agent.WriteMsg(cstruct.DefaultRecvMsg, mainCmdID, subCmdID, msg)
+}
+
+type Room struct {
+	id        interface{}
+	hz        int
+	logic     Logic
+	mgr       *Manager
+	members   map[interface{}]*Member
+	order     []*Member
+	frame     uint64
+	start     time.Time
+	t         *timer.Timer
+	destroyed bool
+	UserData  interface{}
+}
+
+func (r *Room) ID() interface{} {
+	return r.id
+}
+
+func (r *Room) Hz() int {
+	return r.hz
+}
+
+func (r *Room) Frame() uint64 {
+	return r.frame
+}
+
+func (r *Room) Logic() Logic {
+	return r.logic
+}
+
+func (r *Room) Member(id interface{}) *Member {
+	return r.members[id]
+}
+
+func (r *Room) NumMember() int {
+	return len(r.members)
+}
+
+// RangeMembers visits the members in join order
+func (r *Room) RangeMembers(f func(m *Member)) {
+	for _, m := range r.order {
+		f(m)
+	}
+}
+
+// Broadcast sends msg to all members
+func (r *Room) Broadcast(mainCmdID uint16, subCmdID uint16, msg interface{}) {
+	for _, m := range r.order {
+		m.WriteMsg(mainCmdID, subCmdID, msg)
+	}
+}
+
+// BroadcastExcept sends msg to all members but except
+func (r *Room) BroadcastExcept(except interface{}, mainCmdID uint16, subCmdID uint16, msg interface{}) {
+	for _, m := range r.order {
+		if m.ID != except {
+			m.WriteMsg(mainCmdID, subCmdID, msg)
+		}
+	}
+}
+
+func (r *Room) Destroy() {
+	r.mgr.Destroy(r.id)
+}
+
+func (r *Room) interval() time.Duration {
+	return time.Second / time.Duration(r.hz)
+}
+
+// the n-th tick is due at start + n * interval, so a late tick
+// does not delay the following ones
+func (r *Room) schedule() {
+	due := r.start.Add(time.Duration(r.frame+1) * r.interval())
+	d := time.Until(due)
+	if d < 0 {
+		d = 0
+	}
+	r.t = r.mgr.clock.AfterFunc(d, r.tick)
+}
+
+func (r *Room) tick() {
+	if r.destroyed {
+		return
+	}
+
+	r.frame++
+	r.schedule()
+	r.logic.OnTick(r, r.frame, r.interval())
+}
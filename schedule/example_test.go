@@ -0,0 +1,139 @@
+package schedule_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/CreFire/leaf/go"
+	"github.com/CreFire/leaf/schedule"
+	"github.com/CreFire/leaf/timer"
+)
+
+func Example() {
+	d := timer.NewDispatcher(10)
+	s := schedule.New(d, schedule.NewMemStore())
+	s.Register("mail", func(job *schedule.Job) {
+		fmt.Println("send mail:", string(job.Payload))
+	})
+
+	// job 1
+	s.After("mail-1", "mail", time.Millisecond, []byte("hello"))
+
+	// job 2
+	s.After("mail-2", "mail", time.Millisecond, []byte("will not send"))
+	s.Cancel("mail-2")
+
+	// dispatch
+	(<-d.ChanTimer).Cb()
+
+	// Output:
+	// send mail: hello
+}
+
+func ExampleScheduler_Start() {
+	store := schedule.NewMemStore()
+	store.Save(&schedule.Job{
+		ID:      "auction-1",
+		Name:    "auction",
+		Due:     time.Now().Add(-time.Hour).UnixMilli(),
+		Payload: []byte("1"),
+	})
+
+	// two nodes reload the same overdue job
+	d1 := timer.NewDispatcher(10)
+	d2 := timer.NewDispatcher(10)
+	for i, d := range []*timer.Dispatcher{d1, d2} {
+		node := i + 1
+		s := schedule.New(d, store)
+		s.Register("auction", func(job *schedule.Job) {
+			fmt.Printf("node %v ends auction %s\n", node, job.Payload)
+		})
+		s.Start()
+	}
+
+	// only one of them runs it
+	(<-d1.ChanTimer).Cb()
+	(<-d2.ChanTimer).Cb()
+
+	jobs, _ := store.Load()
+	fmt.Println(len(jobs))
+
+	// Output:
+	// node 1 ends auction 1
+	// 0
+}
+
+func ExampleMissSkip() {
+	store := schedule.NewMemStore()
+	store.Save(&schedule.Job{
+		ID:   "reward",
+		Name: "reward",
+		Due:  time.Now().Add(-time.Hour).UnixMilli(),
+	})
+
+	d := timer.NewDispatcher(10)
+	s := schedule.New(d, store)
+	s.MissPolicy = schedule.MissSkip
+	s.Register("reward", func(job *schedule.Job) {
+		fmt.Println("will not run")
+	})
+	s.Start()
+
+	jobs, _ := store.Load()
+	fmt.Println(len(jobs))
+
+	// Output:
+	// 0
+}
+
+func ExampleScheduler_Go() {
+	d := timer.NewDispatcher(10)
+	gr := g.New(10)
+	s := schedule.New(d, schedule.NewMemStore())
+	s.Go = gr.NewLinearContext().Go
+	s.Register("mail", func(job *schedule.Job) {
+		fmt.Println("send mail:", string(job.Payload))
+	})
+
+	// armed once saved
+	s.After("mail-1", "mail", time.Millisecond, []byte("hello"))
+	gr.Cb(<-gr.ChanCb)
+
+	// run once claimed
+	(<-d.ChanTimer).Cb()
+	gr.Cb(<-gr.ChanCb)
+
+	// Output:
+	// send mail: hello
+}
+
+func ExampleScheduler_Poll() {
+	store := schedule.NewMemStore()
+	sent := false
+	nodes := make([]*schedule.Scheduler, 2)
+	dispatchers := make([]*timer.Dispatcher, 2)
+	for i := range nodes {
+		node := i + 1
+		dispatchers[i] = timer.NewDispatcher(10)
+		nodes[i] = schedule.New(dispatchers[i], store)
+		nodes[i].Poll = time.Millisecond
+		nodes[i].Register("mail", func(job *schedule.Job) {
+			fmt.Printf("node %v sends mail %s\n", node, job.Payload)
+			sent = true
+		})
+		nodes[i].Start()
+	}
+
+	// added on node 1, which stops before the job is due
+	nodes[0].After("mail-1", "mail", 10*time.Millisecond, []byte("hello"))
+	nodes[0].Stop()
+
+	// node 2 arms it on its next poll
+	for !sent {
+		(<-dispatchers[1].ChanTimer).Cb()
+	}
+	nodes[1].Stop()
+
+	// Output:
+	// node 2 sends mail hello
+}
@@ -0,0 +1,92 @@
+package schedule
+
+import (
+	"github.com/CreFire/leaf/db/mongodb"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// MongoStore keeps one document per job in db.collection
+type MongoStore struct {
+	c          *mongodb.DialContext
+	db         string
+	collection string
+}
+
+func NewMongoStore(c *mongodb.DialContext, db string, collection string) *MongoStore {
+	s := new(MongoStore)
+	s.c = c
+	s.db = db
+	s.collection = collection
+	return s
+}
+
+// goroutine safe
+func (s *MongoStore) Load() ([]*Job, error) {
+	session := s.c.Ref()
+	defer s.c.UnRef(session)
+
+	var jobs []*Job
+	err := session.DB(s.db).C(s.collection).Find(nil).All(&jobs)
+	return jobs, err
+}
+
+// goroutine safe
+func (s *MongoStore) Get(id string) (*Job, error) {
+	session := s.c.Ref()
+	defer s.c.UnRef(session)
+
+	job := new(Job)
+	err := session.DB(s.db).C(s.collection).FindId(id).One(job)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// goroutine safe
+func (s *MongoStore) Save(job *Job) error {
+	session := s.c.Ref()
+	defer s.c.UnRef(session)
+
+	_, err := session.DB(s.db).C(s.collection).UpsertId(job.ID, job)
+	return err
+}
+
+// goroutine safe
+func (s *MongoStore) Remove(id string) error {
+	session := s.c.Ref()
+	defer s.c.UnRef(session)
+
+	err := session.DB(s.db).C(s.collection).RemoveId(id)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// goroutine safe
+func (s *MongoStore) Claim(id string, due int64, next int64) (bool, error) {
+	session := s.c.Ref()
+	defer s.c.UnRef(session)
+
+	c := session.DB(s.db).C(s.collection)
+	selector := bson.M{"_id": id, "due": due}
+
+	var err error
+	if next == 0 {
+		err = c.Remove(selector)
+	} else {
+		err = c.Update(selector, bson.M{"$set": bson.M{"due": next}})
+	}
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,124 @@
+package schedule
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/CreFire/leaf/util/goredis"
+	"github.com/gomodule/redigo/redis"
+)
+
+// KEYS[1] jobs hash, KEYS[2] due hash
+// ARGV[1] id, ARGV[2] due, ARGV[3] next
+const claimScript = `
+if redis.call('HGET', KEYS[2], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+if ARGV[3] == '0' then
+	redis.call('HDEL', KEYS[1], ARGV[1])
+	redis.call('HDEL', KEYS[2], ARGV[1])
+else
+	redis.call('HSET', KEYS[2], ARGV[1], ARGV[3])
+end
+return 1
+`
+
+// KEYS[1] jobs hash, KEYS[2] due hash
+// ARGV[1] id, ARGV[2] due, ARGV[3] job
+const saveScript = `
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[3])
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[2])
+return 1
+`
+
+// RedisStore keeps jobs in two hashes sharing the hash tag {key},
+// so that it also works on a redis cluster
+type RedisStore struct {
+	cli     *goredis.Client
+	jobsKey string
+	dueKey  string
+}
+
+func NewRedisStore(cli *goredis.Client, key string) *RedisStore {
+	s := new(RedisStore)
+	s.cli = cli
+	s.jobsKey = "{" + key + "}:jobs"
+	s.dueKey = "{" + key + "}:due"
+	return s
+}
+
+// goroutine safe
+func (s *RedisStore) Load() ([]*Job, error) {
+	values, err := redis.StringMap(s.cli.Do("HGETALL", s.jobsKey))
+	if err != nil {
+		return nil, err
+	}
+	dues, err := redis.Int64Map(s.cli.Do("HGETALL", s.dueKey))
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*Job, 0, len(values))
+	for id, v := range values {
+		job := new(Job)
+		if err := json.Unmarshal([]byte(v), job); err != nil {
+			return nil, err
+		}
+		if due, ok := dues[id]; ok {
+			job.Due = due
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// goroutine safe
+func (s *RedisStore) Get(id string) (*Job, error) {
+	v, err := redis.Bytes(s.cli.Do("HGET", s.jobsKey, id))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	job := new(Job)
+	if err := json.Unmarshal(v, job); err != nil {
+		return nil, err
+	}
+	due, err := redis.Int64(s.cli.Do("HGET", s.dueKey, id))
+	if err == nil {
+		job.Due = due
+	} else if err != redis.ErrNil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// goroutine safe
+func (s *RedisStore) Save(job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	_, err = s.cli.Do("EVAL", saveScript, 2, s.jobsKey, s.dueKey, job.ID, job.Due, data)
+	return err
+}
+
+// goroutine safe
+func (s *RedisStore) Remove(id string) error {
+	if _, err := s.cli.Do("HDEL", s.jobsKey, id); err != nil {
+		return err
+	}
+	_, err := s.cli.Do("HDEL", s.dueKey, id)
+	return err
+}
+
+// goroutine safe
+func (s *RedisStore) Claim(id string, due int64, next int64) (bool, error) {
+	n, err := redis.Int(s.cli.Do("EVAL", claimScript, 2, s.jobsKey, s.dueKey,
+		id, strconv.FormatInt(due, 10), strconv.FormatInt(next, 10)))
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
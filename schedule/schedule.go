@@ -0,0 +1,362 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/CreFire/leaf/timer"
+	log "github.com/sirupsen/logrus"
+)
+
+// MissPolicy decides what to do with jobs that came due while no
+// server was running
+type MissPolicy int
+
+const (
+	// MissRunOnce runs an overdue job once, cron jobs then continue from now
+	MissRunOnce MissPolicy = iota
+	// MissSkip drops overdue one-shot jobs and moves cron jobs to their next time
+	MissSkip
+	// MissRunAll runs a cron job once per missed occurrence (at most MaxCatchUp)
+	MissRunAll
+)
+
+// Scheduler persists jobs in a Store and fires them with a timer.Clock.
+// Handlers run on the clock's goroutine, the store calls too unless Go is
+// set. A job added on a node is armed on the other nodes by their next Poll,
+// without Poll only when they Start.
+// one scheduler per goroutine (goroutine not safe)
+type Scheduler struct {
+	MissPolicy MissPolicy
+	MaxCatchUp int
+	// Go runs the store calls f off the clock's goroutine and then cb on it,
+	// in order, e.g. LinearContext.Go. With Go the store errors of After, At,
+	// Cron and Cancel are logged instead of returned. Start loads the jobs
+	// on the calling goroutine anyway.
+	Go func(f func(), cb func())
+	// Poll reloads the jobs every Poll to arm the jobs added or moved by the
+	// other nodes and to stop the removed ones, 0 for none
+	Poll time.Duration
+
+	clock    timer.Clock
+	store    Store
+	handlers map[string]func(*Job)
+	timers   map[string]*armed
+	poll     *timer.Timer
+	stopped  bool
+}
+
+type armed struct {
+	t   *timer.Timer
+	due int64
+}
+
+func New(clock timer.Clock, store Store) *Scheduler {
+	s := new(Scheduler)
+	s.MaxCatchUp = 100
+	s.clock = clock
+	s.store = store
+	s.handlers = make(map[string]func(*Job))
+	s.timers = make(map[string]*armed)
+	return s
+}
+
+// you must call the function before calling Start
+func (s *Scheduler) Register(name string, f func(job *Job)) {
+	if _, ok := s.handlers[name]; ok {
+		panic(fmt.Sprintf("job handler %v: already registered", name))
+	}
+	s.handlers[name] = f
+}
+
+// Start loads the persisted jobs and arms them according to MissPolicy
+func (s *Scheduler) Start() error {
+	jobs, err := s.store.Load()
+	if err != nil {
+		return err
+	}
+	s.stopped = false
+	if s.Poll > 0 {
+		s.poll = s.clock.AfterFunc(s.Poll, s.reload)
+	}
+
+	now := time.Now()
+	for _, job := range jobs {
+		if job.Due > now.UnixMilli() {
+			s.arm(job, 1)
+			continue
+		}
+
+		switch s.MissPolicy {
+		case MissSkip:
+			next, err := nextDue(job, now)
+			if err != nil {
+				log.Errorf("job %v: %v", job.ID, err)
+				continue
+			}
+			ok, err := s.store.Claim(job.ID, job.Due, next)
+			if err != nil {
+				log.Errorf("claim job %v error: %v", job.ID, err)
+				continue
+			}
+			if ok && next != 0 {
+				job.Due = next
+				s.arm(job, 1)
+			}
+		case MissRunAll:
+			s.arm(job, s.missed(job, now))
+		default:
+			s.arm(job, 1)
+		}
+	}
+	return nil
+}
+
+// After schedules a one-shot job, an existing job with the same id is replaced
+func (s *Scheduler) After(id string, name string, d time.Duration, payload []byte) error {
+	return s.At(id, name, time.Now().Add(d), payload)
+}
+
+func (s *Scheduler) At(id string, name string, t time.Time, payload []byte) error {
+	return s.add(&Job{
+		ID:      id,
+		Name:    name,
+		Due:     t.UnixMilli(),
+		Payload: payload,
+	})
+}
+
+// Cron schedules a recurring job, an existing job with the same id is replaced
+func (s *Scheduler) Cron(id string, name string, expr string, payload []byte) error {
+	job := &Job{
+		ID:      id,
+		Name:    name,
+		Cron:    expr,
+		Payload: payload,
+	}
+	next, err := nextDue(job, time.Now())
+	if err != nil {
+		return err
+	}
+	if next == 0 {
+		return errors.New("cron expression never fires")
+	}
+	job.Due = next
+	return s.add(job)
+}
+
+// Cancel removes the job from the store and stops its local timer
+func (s *Scheduler) Cancel(id string) error {
+	s.disarm(id)
+	if s.Go == nil {
+		return s.store.Remove(id)
+	}
+
+	var err error
+	s.Go(func() {
+		err = s.store.Remove(id)
+	}, func() {
+		if err != nil {
+			log.Errorf("remove job %v error: %v", id, err)
+		}
+	})
+	return nil
+}
+
+// Stop stops the local timers, jobs stay in the store
+func (s *Scheduler) Stop() {
+	s.stopped = true
+	if s.poll != nil {
+		s.poll.Stop()
+		s.poll = nil
+	}
+	for id := range s.timers {
+		s.disarm(id)
+	}
+}
+
+func (s *Scheduler) add(job *Job) error {
+	if _, ok := s.handlers[job.Name]; !ok {
+		return fmt.Errorf("job handler %v: not registered", job.Name)
+	}
+	if s.Go == nil {
+		if err := s.store.Save(job); err != nil {
+			return err
+		}
+		s.arm(job, 1)
+		return nil
+	}
+
+	var err error
+	s.Go(func() {
+		err = s.store.Save(job)
+	}, func() {
+		if err != nil {
+			log.Errorf("save job %v error: %v", job.ID, err)
+			return
+		}
+		s.arm(job, 1)
+	})
+	return nil
+}
+
+// do runs f through Go, or right now without Go
+func (s *Scheduler) do(f func(), cb func()) {
+	if s.Go == nil {
+		f()
+		cb()
+		return
+	}
+	s.Go(f, cb)
+}
+
+func (s *Scheduler) arm(job *Job, runs int) {
+	if s.stopped {
+		return
+	}
+	s.disarm(job.ID)
+
+	d := time.Until(time.UnixMilli(job.Due))
+	if d < 0 {
+		d = 0
+	}
+	s.timers[job.ID] = &armed{
+		t: s.clock.AfterFunc(d, func() {
+			s.fire(job, runs)
+		}),
+		due: job.Due,
+	}
+}
+
+func (s *Scheduler) disarm(id string) {
+	if a, ok := s.timers[id]; ok {
+		a.t.Stop()
+		delete(s.timers, id)
+	}
+}
+
+func (s *Scheduler) fire(job *Job, runs int) {
+	delete(s.timers, job.ID)
+
+	f, ok := s.handlers[job.Name]
+	if !ok {
+		log.Errorf("job %v: handler %v not registered", job.ID, job.Name)
+		return
+	}
+
+	next, err := nextDue(job, time.Now())
+	if err != nil {
+		log.Errorf("job %v: %v", job.ID, err)
+		return
+	}
+
+	var claimed bool
+	var stored *Job
+	s.do(func() {
+		claimed, err = s.store.Claim(job.ID, job.Due, next)
+		if err != nil {
+			log.Errorf("claim job %v error: %v", job.ID, err)
+			return
+		}
+		// fired on another node, follow the stored copy
+		if !claimed && job.Cron != "" {
+			stored, err = s.store.Get(job.ID)
+			if err != nil {
+				log.Errorf("get job %v error: %v", job.ID, err)
+			}
+		}
+	}, func() {
+		if !claimed {
+			if stored != nil {
+				s.arm(stored, 1)
+			}
+			return
+		}
+
+		fired := *job
+		if next != 0 {
+			job.Due = next
+			s.arm(job, 1)
+		}
+		for i := 0; i < runs; i++ {
+			f(&fired)
+		}
+	})
+}
+
+// reload arms the jobs added or moved by the other nodes and stops the
+// removed ones
+func (s *Scheduler) reload() {
+	s.poll = nil
+
+	var jobs []*Job
+	var err error
+	s.do(func() {
+		jobs, err = s.store.Load()
+	}, func() {
+		if s.stopped {
+			return
+		}
+		s.poll = s.clock.AfterFunc(s.Poll, s.reload)
+		if err != nil {
+			log.Errorf("load jobs error: %v", err)
+			return
+		}
+
+		stored := make(map[string]bool, len(jobs))
+		for _, job := range jobs {
+			stored[job.ID] = true
+			if a, ok := s.timers[job.ID]; ok && a.due == job.Due {
+				continue
+			}
+			// the jobs of the other servers
+			if _, ok := s.handlers[job.Name]; !ok {
+				continue
+			}
+			s.arm(job, 1)
+		}
+		for id := range s.timers {
+			if !stored[id] {
+				s.disarm(id)
+			}
+		}
+	})
+}
+
+// missed counts the occurrences of an overdue job up to now
+func (s *Scheduler) missed(job *Job, now time.Time) int {
+	if job.Cron == "" {
+		return 1
+	}
+	cronExpr, err := timer.NewCronExpr(job.Cron)
+	if err != nil {
+		return 1
+	}
+
+	n := 1
+	t := time.UnixMilli(job.Due)
+	for n < s.MaxCatchUp {
+		t = cronExpr.Next(t)
+		if t.IsZero() || t.After(now) {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+func nextDue(job *Job, now time.Time) (int64, error) {
+	if job.Cron == "" {
+		return 0, nil
+	}
+	cronExpr, err := timer.NewCronExpr(job.Cron)
+	if err != nil {
+		return 0, err
+	}
+	next := cronExpr.Next(now)
+	if next.IsZero() {
+		return 0, nil
+	}
+	return next.UnixMilli(), nil
+}
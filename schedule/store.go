@@ -0,0 +1,95 @@
+package schedule
+
+import (
+	"sync"
+)
+
+// Job is the persisted form of a scheduled callback
+type Job struct {
+	ID      string `bson:"_id" json:"id"`
+	Name    string `bson:"name" json:"name"` // handler name
+	Due     int64  `bson:"due" json:"due"`   // unix milliseconds
+	Cron    string `bson:"cron,omitempty" json:"cron,omitempty"`
+	Payload []byte `bson:"payload,omitempty" json:"payload,omitempty"`
+}
+
+// Store must be goroutine safe
+type Store interface {
+	Load() ([]*Job, error)
+	// Get returns nil, nil if the job does not exist
+	Get(id string) (*Job, error)
+	Save(job *Job) error
+	Remove(id string) error
+	// Claim atomically checks that job id is still due at due and then
+	// advances it to next, or removes it when next is 0.
+	// Only one caller wins for the same (id, due).
+	Claim(id string, due int64, next int64) (bool, error)
+}
+
+// MemStore keeps jobs in memory, it is useful for tests and single node servers
+type MemStore struct {
+	sync.Mutex
+	jobs map[string]Job
+}
+
+func NewMemStore() *MemStore {
+	s := new(MemStore)
+	s.jobs = make(map[string]Job)
+	return s
+}
+
+func (s *MemStore) Load() ([]*Job, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	jobs := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		job := job
+		jobs = append(jobs, &job)
+	}
+	return jobs, nil
+}
+
+func (s *MemStore) Get(id string) (*Job, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, nil
+	}
+	return &job, nil
+}
+
+func (s *MemStore) Save(job *Job) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.jobs[job.ID] = *job
+	return nil
+}
+
+func (s *MemStore) Remove(id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.jobs, id)
+	return nil
+}
+
+func (s *MemStore) Claim(id string, due int64, next int64) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok || job.Due != due {
+		return false, nil
+	}
+	if next == 0 {
+		delete(s.jobs, id)
+	} else {
+		job.Due = next
+		s.jobs[id] = job
+	}
+	return true, nil
+}
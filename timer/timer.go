@@ -18,6 +18,11 @@ func NewDispatcher(l int) *Dispatcher {
 	return disp
 }
 
+// Clock is implemented by Dispatcher and module.Skeleton
+type Clock interface {
+	AfterFunc(d time.Duration, cb func()) *Timer
+}
+
 // Timer
 type Timer struct {
 	t  *time.Timer
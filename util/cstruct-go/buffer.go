@@ -3,14 +3,16 @@ package cstruct
 import (
 	"encoding/binary"
 	"io"
+	"math"
 	"reflect"
 	"strconv"
+	"sync"
 	"unsafe"
 )
 
 const (
 	VersionFieldName    string = "Ver" // struct版本字段名，值为正数，一般定义为：Ver uint8，值从1开始；如果新增定义Ver字段，Ver可定义为无符号数据类型（无符号最小值为0），其它字段的tag ver设置从0开始，这样就可以处理所有字段数据
-	VersionFieldTagName string = "ver" // struct版本字段tag名，表示定义该字段时的版本号，例如：Data uint32 `ver:"1"`，当 tag ver的值 <= struct版本字段值，本struc字段的数据就会做解码处理；当编码的时候发现 tag ver的值 > struct版本字段值，会panic
+	VersionFieldTagName string = "ver" // struct版本字段tag名，表示定义该字段时的版本号，例如：Data uint32 `ver:"1"`，当 tag ver的值 <= struct版本字段值，本struc字段的数据就会做解码处理；当编码的时候发现 tag ver的值 > struct版本字段值，返回 *FieldVerError
 )
 
 /*
@@ -72,22 +74,64 @@ func (p *Buffer) Reset() {
 	p.index = 0
 }
 
+// the buffers larger than this are not kept by the pool
+const maxPooledSize = 64 * 1024
+
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(Buffer) },
+}
+
+// GetBuffer returns an empty Buffer of the pool, which reuses the bytes of
+// the buffers put back
+func GetBuffer() *Buffer {
+	p := bufferPool.Get().(*Buffer)
+	p.Reset()
+	return p
+}
+
+// PutBuffer puts p back to the pool, the bytes of p must not be used after
+func PutBuffer(p *Buffer) {
+	if cap(p.buf) > maxPooledSize {
+		p.buf = nil
+	}
+	bufferPool.Put(p)
+}
+
 // Marshal
 
 func (p *Buffer) Marshal(obj IStruct) error {
+	p.buf = nil
+	p.index = 0
+	return p.Append(obj)
+}
+
+// Append encodes obj after the bytes of p, the buffer is grown at most once
+func (p *Buffer) Append(obj IStruct) error {
+	if m, ok := obj.(Marshaler); ok {
+		if reflect.ValueOf(obj).IsNil() {
+			return ErrNil
+		}
+		p.reserve(m.SizeCStruct())
+		return m.MarshalCStruct(p)
+	}
 	t, base, err := getbase(obj)
 	if structPointer_IsNil(base) {
 		return ErrNil
 	}
 	if err == nil {
 		props := GetProperties(t.Elem())
-		s := p.size_struct(props, base)
-		p.buf = make([]byte, s)
+		p.reserve(p.size_struct(props, base))
 		err = p.enc_struct(props, base)
 	}
 	return err
 }
 
+// reserve makes room for n bytes after index
+func (p *Buffer) reserve(n int) {
+	p.grow(n)
+	p.index -= n
+}
+
 func getbase(obj IStruct) (t reflect.Type, b structPointer, err error) {
 	if obj == nil {
 		err = ErrNil
@@ -136,7 +180,7 @@ func (o *Buffer) enc_struct(prop *StructProperties, base structPointer) error {
 						return err
 					}
 				} else {
-					panic("cstruct: enc_struct ver err: field name = " + p.Name + " p.ver[" + strconv.Itoa(int(p.ver)) + "] > propVer[" + strconv.Itoa(int(propVer)) + "]")
+					return &FieldVerError{Field: p.Name, Ver: p.ver, StructVer: propVer}
 				}
 			}
 		}
@@ -147,6 +191,9 @@ func (o *Buffer) enc_struct(prop *StructProperties, base structPointer) error {
 // Unmarshal
 
 func (p *Buffer) Unmarshal(obj IStruct) error {
+	if m, ok := obj.(Marshaler); ok {
+		return m.UnmarshalCStruct(p)
+	}
 	typ, base, err := getbase(obj)
 	if err != nil {
 		return err
@@ -292,8 +339,9 @@ func (o *Buffer) dec_uint64(p *Properties, base structPointer) error {
 func (o *Buffer) enc_string(p *Properties, base structPointer) error {
 	v := structPointer_StringVal(base, p.field)
 	ln := len(*v)
-	binary.LittleEndian.PutUint16(o.buf[o.index:], uint16(ln))
-	o.index += 2
+	if err := o.putLen16(ln); err != nil {
+		return err
+	}
 	if ln > 0 {
 		copy(o.buf[o.index:], *v)
 		o.index += ln
@@ -328,8 +376,9 @@ func (o *Buffer) size_string(p *Properties, base structPointer) int {
 func (o *Buffer) enc_slice_byte(p *Properties, base structPointer) error {
 	v := structPointer_Bytes(base, p.field)
 	ln := len(*v)
-	binary.LittleEndian.PutUint16(o.buf[o.index:], uint16(ln))
-	o.index += 2
+	if err := o.putLen16(ln); err != nil {
+		return err
+	}
 	if ln > 0 {
 		copy(o.buf[o.index:], *v)
 		o.index += ln
@@ -412,8 +461,9 @@ func (o *Buffer) size_substruct(p *Properties, base structPointer) int {
 func (o *Buffer) enc_slice_bool(p *Properties, base structPointer) error {
 	v := structPointer_BoolSlice(base, p.field)
 	ln := len(*v)
-	binary.LittleEndian.PutUint16(o.buf[o.index:], uint16(ln))
-	o.index += 2
+	if err := o.putLen16(ln); err != nil {
+		return err
+	}
 	for i := 0; i < ln; i++ {
 		x := 0
 		if (*v)[i] {
@@ -453,8 +503,9 @@ func (o *Buffer) size_slice_bool(p *Properties, base structPointer) int {
 func (o *Buffer) enc_slice_uint16(p *Properties, base structPointer) error {
 	v := (*[]uint16)(unsafe.Pointer(uintptr(base) + uintptr(p.field)))
 	ln := len(*v)
-	binary.LittleEndian.PutUint16(o.buf[o.index:], uint16(ln))
-	o.index += 2
+	if err := o.putLen16(ln); err != nil {
+		return err
+	}
 	for i := 0; i < ln; i++ {
 		binary.LittleEndian.PutUint16(o.buf[o.index:], (*v)[i])
 		o.index += 2
@@ -489,8 +540,9 @@ func (o *Buffer) size_slice_uint16(p *Properties, base structPointer) int {
 func (o *Buffer) enc_slice_uint32(p *Properties, base structPointer) error {
 	v := (*[]uint32)(unsafe.Pointer(uintptr(base) + uintptr(p.field)))
 	ln := len(*v)
-	binary.LittleEndian.PutUint16(o.buf[o.index:], uint16(ln))
-	o.index += 2
+	if err := o.putLen16(ln); err != nil {
+		return err
+	}
 	for i := 0; i < ln; i++ {
 		binary.LittleEndian.PutUint32(o.buf[o.index:], (*v)[i])
 		o.index += 4
@@ -525,8 +577,9 @@ func (o *Buffer) size_slice_uint32(p *Properties, base structPointer) int {
 func (o *Buffer) enc_slice_uint64(p *Properties, base structPointer) error {
 	v := (*[]uint64)(unsafe.Pointer(uintptr(base) + uintptr(p.field)))
 	ln := len(*v)
-	binary.LittleEndian.PutUint16(o.buf[o.index:], uint16(ln))
-	o.index += 2
+	if err := o.putLen16(ln); err != nil {
+		return err
+	}
 	for i := 0; i < ln; i++ {
 		binary.LittleEndian.PutUint64(o.buf[o.index:], (*v)[i])
 		o.index += 8
@@ -561,12 +614,14 @@ func (o *Buffer) size_slice_uint64(p *Properties, base structPointer) int {
 func (o *Buffer) enc_slice_string(p *Properties, base structPointer) error {
 	v := (*[]string)(unsafe.Pointer(uintptr(base) + uintptr(p.field)))
 	ln := len(*v)
-	binary.LittleEndian.PutUint16(o.buf[o.index:], uint16(ln))
-	o.index += 2
+	if err := o.putLen16(ln); err != nil {
+		return err
+	}
 	for i := 0; i < ln; i++ {
 		ln2 := len((*v)[i])
-		binary.LittleEndian.PutUint16(o.buf[o.index:], uint16(ln2))
-		o.index += 2
+		if err := o.putLen16(ln2); err != nil {
+			return err
+		}
 		if ln2 > 0 {
 			copy(o.buf[o.index:], (*v)[i])
 			o.index += ln2
@@ -611,12 +666,15 @@ func (o *Buffer) size_slice_string(p *Properties, base structPointer) int {
 func (o *Buffer) enc_slice_substruct(p *Properties, base structPointer) error {
 	sliceHeader := (*reflect.SliceHeader)(unsafe.Pointer(uintptr(base) + uintptr(p.field)))
 	var ln = sliceHeader.Len
-	binary.LittleEndian.PutUint16(o.buf[o.index:], uint16(ln))
-	o.index += 2
+	if err := o.putLen16(ln); err != nil {
+		return err
+	}
 	itemsize := int(p.stype.Size())
 	for i := 0; i < ln; i++ {
 		sv := (structPointer)(unsafe.Pointer(sliceHeader.Data + uintptr(i*itemsize)))
-		o.enc_struct(p.sprop, sv)
+		if err := o.enc_struct(p.sprop, sv); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -637,7 +695,9 @@ func (o *Buffer) dec_slice_substruct(p *Properties, base structPointer) error {
 	sliceHeader.Data = data.Pointer()
 	for i := 0; i < int(nb); i++ {
 		data := (structPointer)(unsafe.Pointer(sliceHeader.Data + uintptr(i*itemsize)))
-		o.unmarshalType(p.stype, p.sprop, data)
+		if err := o.unmarshalType(p.stype, p.sprop, data); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -658,8 +718,9 @@ func (o *Buffer) size_slice_substruct(p *Properties, base structPointer) int {
 func (o *Buffer) enc_slice_substruct_ptr(p *Properties, base structPointer) error {
 	v := structPointer_StructPointerSlice(base, p.field)
 	ln := v.Len()
-	binary.LittleEndian.PutUint16(o.buf[o.index:], uint16(ln))
-	o.index += 2
+	if err := o.putLen16(ln); err != nil {
+		return err
+	}
 	for i := 0; i < ln; i++ {
 		sv := (*v)[i]
 		if sv == nil {
@@ -668,7 +729,9 @@ func (o *Buffer) enc_slice_substruct_ptr(p *Properties, base structPointer) erro
 		} else {
 			o.buf[o.index] = uint8(1)
 			o.index++
-			o.enc_struct(p.sprop, sv)
+			if err := o.enc_struct(p.sprop, sv); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -691,7 +754,9 @@ func (o *Buffer) dec_slice_substruct_ptr(p *Properties, base structPointer) erro
 			v.Append(nil)
 		} else {
 			bas := toStructPointer(reflect.New(p.stype))
-			o.unmarshalType(p.stype, p.sprop, bas)
+			if err := o.unmarshalType(p.stype, p.sprop, bas); err != nil {
+				return err
+			}
 			v.Append(bas)
 		}
 	}
@@ -724,9 +789,14 @@ func (o *Buffer) enc_slice_substruct_ptr_ignore_nil(p *Properties, base structPo
 		sv := (*v)[i]
 		if sv != nil {
 			real_ln++
-			o.enc_struct(p.sprop, sv)
+			if err := o.enc_struct(p.sprop, sv); err != nil {
+				return err
+			}
 		}
 	}
+	if real_ln > math.MaxUint16 {
+		return ErrLenOverflow
+	}
 	binary.LittleEndian.PutUint16(o.buf[len_index:], uint16(real_ln))
 	return nil
 }
@@ -739,7 +809,9 @@ func (o *Buffer) dec_slice_substruct_ptr_ignore_nil(p *Properties, base structPo
 	}
 	for j := 0; j < int(nb); j++ {
 		bas := toStructPointer(reflect.New(p.stype))
-		o.unmarshalType(p.stype, p.sprop, bas)
+		if err := o.unmarshalType(p.stype, p.sprop, bas); err != nil {
+			return err
+		}
 		v.Append(bas)
 	}
 	return nil
@@ -762,12 +834,14 @@ func (o *Buffer) size_slice_substruct_ptr_ignore_nil(p *Properties, base structP
 func (o *Buffer) enc_slice_slice_byte(p *Properties, base structPointer) error {
 	v := structPointer_BytesSlice(base, p.field)
 	ln := len(*v)
-	binary.LittleEndian.PutUint16(o.buf[o.index:], uint16(ln))
-	o.index += 2
+	if err := o.putLen16(ln); err != nil {
+		return err
+	}
 	for i := 0; i < ln; i++ {
 		ln2 := len((*v)[i])
-		binary.LittleEndian.PutUint16(o.buf[o.index:], uint16(ln2))
-		o.index += 2
+		if err := o.putLen16(ln2); err != nil {
+			return err
+		}
 		if ln2 > 0 {
 			copy(o.buf[o.index:], (*v)[i])
 			o.index += ln2
@@ -814,12 +888,14 @@ func (o *Buffer) size_slice_slice_byte(p *Properties, base structPointer) int {
 func (o *Buffer) enc_slice_slice_uint16(p *Properties, base structPointer) error {
 	v := structPointer_Uint16sSlice(base, p.field)
 	ln := len(*v)
-	binary.LittleEndian.PutUint16(o.buf[o.index:], uint16(ln))
-	o.index += 2
+	if err := o.putLen16(ln); err != nil {
+		return err
+	}
 	for i := 0; i < ln; i++ {
 		ln2 := len((*v)[i])
-		binary.LittleEndian.PutUint16(o.buf[o.index:], uint16(ln2))
-		o.index += 2
+		if err := o.putLen16(ln2); err != nil {
+			return err
+		}
 		if ln2 > 0 {
 			for j := 0; j < ln2; j++ {
 				binary.LittleEndian.PutUint16(o.buf[o.index:], (*v)[i][j])
@@ -871,12 +947,14 @@ func (o *Buffer) size_slice_slice_uint16(p *Properties, base structPointer) int
 func (o *Buffer) enc_slice_slice_uint32(p *Properties, base structPointer) error {
 	v := structPointer_Uint32sSlice(base, p.field)
 	ln := len(*v)
-	binary.LittleEndian.PutUint16(o.buf[o.index:], uint16(ln))
-	o.index += 2
+	if err := o.putLen16(ln); err != nil {
+		return err
+	}
 	for i := 0; i < ln; i++ {
 		ln2 := len((*v)[i])
-		binary.LittleEndian.PutUint16(o.buf[o.index:], uint16(ln2))
-		o.index += 2
+		if err := o.putLen16(ln2); err != nil {
+			return err
+		}
 		if ln2 > 0 {
 			for j := 0; j < ln2; j++ {
 				binary.LittleEndian.PutUint32(o.buf[o.index:], (*v)[i][j])
@@ -924,6 +1002,15 @@ func (o *Buffer) size_slice_slice_uint32(p *Properties, base structPointer) int
 	return ret
 }
 
+func (o *Buffer) putLen16(n int) error {
+	if n > math.MaxUint16 {
+		return ErrLenOverflow
+	}
+	binary.LittleEndian.PutUint16(o.buf[o.index:], uint16(n))
+	o.index += 2
+	return nil
+}
+
 func (o *Buffer) readUInt16() (uint16, error) {
 	i := o.index + 2
 	if i < 0 || i > len(o.buf) {
@@ -1100,7 +1187,9 @@ func (o *Buffer) enc_array_substruct(p *Properties, base structPointer) error {
 		itemsize := int(p.stype.Size())
 		for i := 0; i < ln; i++ {
 			data := (structPointer)(unsafe.Pointer(uintptr(base) + uintptr(p.field) + uintptr(i*itemsize)))
-			o.enc_struct(p.sprop, data)
+			if err := o.enc_struct(p.sprop, data); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -1116,7 +1205,9 @@ func (o *Buffer) dec_array_substruct(p *Properties, base structPointer) error {
 		}
 		for i := 0; i < ln; i++ {
 			data := (structPointer)(unsafe.Pointer(uintptr(base) + uintptr(p.field) + uintptr(i*int(p.stype.Size()))))
-			o.unmarshalType(p.stype, p.sprop, data)
+			if err := o.unmarshalType(p.stype, p.sprop, data); err != nil {
+				return err
+			}
 		}
 		o.index = end
 	}
@@ -1139,7 +1230,9 @@ func (o *Buffer) enc_array_substruct_ptr(p *Properties, base structPointer) erro
 			} else {
 				o.buf[o.index] = uint8(1)
 				o.index++
-				o.enc_struct(p.sprop, *data)
+				if err := o.enc_struct(p.sprop, *data); err != nil {
+					return err
+				}
 			}
 		}
 	}
@@ -1161,7 +1254,9 @@ func (o *Buffer) dec_array_substruct_ptr(p *Properties, base structPointer) erro
 				*data = nil
 			} else {
 				bas := toStructPointer(reflect.New(p.stype))
-				o.unmarshalType(p.stype, p.sprop, bas)
+				if err := o.unmarshalType(p.stype, p.sprop, bas); err != nil {
+					return err
+				}
 				*data = bas
 			}
 		}
@@ -0,0 +1,634 @@
+package cstruct
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"unsafe"
+)
+
+// codec encodes a value by reflection, it is used for the types without a
+// fast path: maps, int and uint, pointers to primitives, nested slices and
+// arrays, and for the fields not in the default encoding. size is the full
+// size of the value.
+type codec struct {
+	enc  func(o *Buffer, v reflect.Value) error
+	dec  func(o *Buffer, v reflect.Value) error
+	size func(o *Buffer, v reflect.Value) int
+}
+
+// field with a codec
+func (o *Buffer) enc_value(p *Properties, base structPointer) error {
+	return p.codec.enc(o, structPointer_NewAt(base, p.field, p.t).Elem())
+}
+
+func (o *Buffer) dec_value(p *Properties, base structPointer) error {
+	return p.codec.dec(o, structPointer_NewAt(base, p.field, p.t).Elem())
+}
+
+func (o *Buffer) size_value(p *Properties, base structPointer) int {
+	return p.codec.size(o, structPointer_NewAt(base, p.field, p.t).Elem())
+}
+
+// the encoding of a field and its elements
+type encoding struct {
+	len    LenMode
+	varint bool
+}
+
+// parseEncoding returns the encoding of a field by the options and its tag
+func parseEncoding(tag reflect.StructTag) encoding {
+	e := encoding{len: OptionLenMode, varint: OptionVarint}
+	opts, ok := tag.Lookup(EncodingTagName)
+	if !ok {
+		return e
+	}
+	for _, opt := range strings.Split(opts, ",") {
+		switch strings.TrimSpace(opt) {
+		case "len16":
+			e.len = Len16
+		case "len32":
+			e.len = Len32
+		case "varlen":
+			e.len = LenVarint
+		case "varint":
+			e.varint = true
+		case "fixed":
+			e.varint = false
+		case "":
+		default:
+			panic("cstruct: unknow tag option " + opt)
+		}
+	}
+	return e
+}
+
+// newCodec must be called with propertiesMu held, name is the field name
+// for the panic of an unknown type
+func newCodec(t reflect.Type, name string, e encoding) *codec {
+	switch t.Kind() {
+	case reflect.Bool:
+		return fixedCodec(1,
+			func(b []byte, v reflect.Value) error {
+				b[0] = 0
+				if v.Bool() {
+					b[0] = 1
+				}
+				return nil
+			},
+			func(b []byte, v reflect.Value) { v.SetBool(b[0] != 0) })
+	case reflect.Int8, reflect.Uint8:
+		if t.Kind() == reflect.Int8 {
+			return intCodec(1)
+		}
+		return uintCodec(1)
+	case reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
+		n := int(t.Size())
+		if t.Kind() == reflect.Int {
+			n = OptionIntSize
+		}
+		if e.varint {
+			return varintCodec(n*8, true)
+		}
+		return intCodec(n)
+	case reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint:
+		n := int(t.Size())
+		if t.Kind() == reflect.Uint {
+			n = OptionIntSize
+		}
+		if e.varint {
+			return varintCodec(n*8, false)
+		}
+		return uintCodec(n)
+	case reflect.Float32:
+		return fixedCodec(4,
+			func(b []byte, v reflect.Value) error {
+				binary.LittleEndian.PutUint32(b, math.Float32bits(float32(v.Float())))
+				return nil
+			},
+			func(b []byte, v reflect.Value) {
+				v.SetFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))))
+			})
+	case reflect.Float64:
+		return fixedCodec(8,
+			func(b []byte, v reflect.Value) error {
+				binary.LittleEndian.PutUint64(b, math.Float64bits(v.Float()))
+				return nil
+			},
+			func(b []byte, v reflect.Value) {
+				v.SetFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+			})
+	case reflect.String:
+		return stringCodec(e.len)
+	case reflect.Struct:
+		return structCodec(t)
+	case reflect.Ptr:
+		return ptrCodec(t, name, e)
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Struct && OptionSliceStructPointer {
+			panic("cstruct: not pointer type. field name =" + name)
+		}
+		return sliceCodec(t, name, e)
+	case reflect.Array:
+		return arrayCodec(t, name, e)
+	case reflect.Map:
+		return mapCodec(t, name, e)
+	}
+	panic("cstruct: unknow type. field name = " + name)
+}
+
+func fixedCodec(n int, put func(b []byte, v reflect.Value) error, get func(b []byte, v reflect.Value)) *codec {
+	return &codec{
+		enc: func(o *Buffer, v reflect.Value) error {
+			if err := put(o.buf[o.index:o.index+n], v); err != nil {
+				return err
+			}
+			o.index += n
+			return nil
+		},
+		dec: func(o *Buffer, v reflect.Value) error {
+			i := o.index + n
+			if i < 0 || i > len(o.buf) {
+				return io.ErrUnexpectedEOF
+			}
+			get(o.buf[o.index:i], v)
+			o.index = i
+			return nil
+		},
+		size: func(o *Buffer, v reflect.Value) int { return n },
+	}
+}
+
+func intCodec(n int) *codec {
+	return fixedCodec(n,
+		func(b []byte, v reflect.Value) error {
+			x := v.Int()
+			switch n {
+			case 1:
+				b[0] = uint8(x)
+			case 2:
+				binary.LittleEndian.PutUint16(b, uint16(x))
+			case 4:
+				if x < math.MinInt32 || x > math.MaxInt32 {
+					return ErrIntOverflow
+				}
+				binary.LittleEndian.PutUint32(b, uint32(x))
+			default:
+				binary.LittleEndian.PutUint64(b, uint64(x))
+			}
+			return nil
+		},
+		func(b []byte, v reflect.Value) {
+			switch n {
+			case 1:
+				v.SetInt(int64(int8(b[0])))
+			case 2:
+				v.SetInt(int64(int16(binary.LittleEndian.Uint16(b))))
+			case 4:
+				v.SetInt(int64(int32(binary.LittleEndian.Uint32(b))))
+			default:
+				v.SetInt(int64(binary.LittleEndian.Uint64(b)))
+			}
+		})
+}
+
+func uintCodec(n int) *codec {
+	return fixedCodec(n,
+		func(b []byte, v reflect.Value) error {
+			x := v.Uint()
+			switch n {
+			case 1:
+				b[0] = uint8(x)
+			case 2:
+				binary.LittleEndian.PutUint16(b, uint16(x))
+			case 4:
+				if x > math.MaxUint32 {
+					return ErrIntOverflow
+				}
+				binary.LittleEndian.PutUint32(b, uint32(x))
+			default:
+				binary.LittleEndian.PutUint64(b, x)
+			}
+			return nil
+		},
+		func(b []byte, v reflect.Value) {
+			switch n {
+			case 1:
+				v.SetUint(uint64(b[0]))
+			case 2:
+				v.SetUint(uint64(binary.LittleEndian.Uint16(b)))
+			case 4:
+				v.SetUint(uint64(binary.LittleEndian.Uint32(b)))
+			default:
+				v.SetUint(binary.LittleEndian.Uint64(b))
+			}
+		})
+}
+
+// zigzag for the signed, bits is the size of the value for the overflow
+func varintCodec(bits int, signed bool) *codec {
+	return &codec{
+		enc: func(o *Buffer, v reflect.Value) error {
+			var u uint64
+			if signed {
+				x := v.Int()
+				if bits < 64 && (x < -1<<(bits-1) || x >= 1<<(bits-1)) {
+					return ErrIntOverflow
+				}
+				u = uint64(x<<1) ^ uint64(x>>63)
+			} else {
+				u = v.Uint()
+				if bits < 64 && u >= 1<<bits {
+					return ErrIntOverflow
+				}
+			}
+			o.index += binary.PutUvarint(o.buf[o.index:], u)
+			return nil
+		},
+		dec: func(o *Buffer, v reflect.Value) error {
+			u, err := o.readUvarint()
+			if err != nil {
+				return err
+			}
+			if signed {
+				x := int64(u>>1) ^ -int64(u&1)
+				if v.OverflowInt(x) {
+					return ErrIntOverflow
+				}
+				v.SetInt(x)
+			} else {
+				if v.OverflowUint(u) {
+					return ErrIntOverflow
+				}
+				v.SetUint(u)
+			}
+			return nil
+		},
+		size: func(o *Buffer, v reflect.Value) int {
+			if signed {
+				x := v.Int()
+				return uvarintSize(uint64(x<<1) ^ uint64(x>>63))
+			}
+			return uvarintSize(v.Uint())
+		},
+	}
+}
+
+func stringCodec(m LenMode) *codec {
+	return &codec{
+		enc: func(o *Buffer, v reflect.Value) error {
+			s := v.String()
+			if err := o.putLen(len(s), m); err != nil {
+				return err
+			}
+			o.index += copy(o.buf[o.index:], s)
+			return nil
+		},
+		dec: func(o *Buffer, v reflect.Value) error {
+			n, err := o.getLen(m)
+			if err != nil {
+				return err
+			}
+			v.SetString(string(o.buf[o.index : o.index+n]))
+			o.index += n
+			return nil
+		},
+		size: func(o *Buffer, v reflect.Value) int { return lenSize(v.Len(), m) + v.Len() },
+	}
+}
+
+// a struct value, which is copied if it is not addressable, e.g. a map value
+func structCodec(t reflect.Type) *codec {
+	if reflect.PtrTo(t).Implements(marshalerType) {
+		return marshalerCodec(t)
+	}
+	sprop := getPropertiesLocked(t)
+	addr := func(v reflect.Value) structPointer {
+		if !v.CanAddr() {
+			c := reflect.New(t)
+			c.Elem().Set(v)
+			v = c.Elem()
+		}
+		return structPointer(unsafe.Pointer(v.UnsafeAddr()))
+	}
+	return &codec{
+		enc: func(o *Buffer, v reflect.Value) error {
+			return o.enc_struct(sprop, addr(v))
+		},
+		dec: func(o *Buffer, v reflect.Value) error {
+			return o.unmarshalType(t, sprop, structPointer(unsafe.Pointer(v.UnsafeAddr())))
+		},
+		size: func(o *Buffer, v reflect.Value) int {
+			return o.size_struct(sprop, addr(v))
+		},
+	}
+}
+
+func marshalerCodec(t reflect.Type) *codec {
+	of := func(v reflect.Value) Marshaler {
+		if !v.CanAddr() {
+			c := reflect.New(t)
+			c.Elem().Set(v)
+			return c.Interface().(Marshaler)
+		}
+		return v.Addr().Interface().(Marshaler)
+	}
+	return &codec{
+		enc: func(o *Buffer, v reflect.Value) error {
+			return of(v).MarshalCStruct(o)
+		},
+		dec: func(o *Buffer, v reflect.Value) error {
+			return v.Addr().Interface().(Marshaler).UnmarshalCStruct(o)
+		},
+		size: func(o *Buffer, v reflect.Value) int {
+			return of(v).SizeCStruct()
+		},
+	}
+}
+
+// a flag byte, 0 for nil, then the value
+func ptrCodec(t reflect.Type, name string, e encoding) *codec {
+	elem := newCodec(t.Elem(), name, e)
+	return &codec{
+		enc: func(o *Buffer, v reflect.Value) error {
+			if v.IsNil() {
+				o.buf[o.index] = 0
+				o.index++
+				return nil
+			}
+			o.buf[o.index] = 1
+			o.index++
+			return elem.enc(o, v.Elem())
+		},
+		dec: func(o *Buffer, v reflect.Value) error {
+			i := o.index + 1
+			if i < 0 || i > len(o.buf) {
+				return io.ErrUnexpectedEOF
+			}
+			o.index = i
+			if o.buf[i-1] == 0 {
+				v.Set(reflect.Zero(t))
+				return nil
+			}
+			if v.IsNil() {
+				v.Set(reflect.New(t.Elem()))
+			}
+			return elem.dec(o, v.Elem())
+		},
+		size: func(o *Buffer, v reflect.Value) int {
+			if v.IsNil() {
+				return 1
+			}
+			return 1 + elem.size(o, v.Elem())
+		},
+	}
+}
+
+// the length then the elements
+func sliceCodec(t reflect.Type, name string, e encoding) *codec {
+	elem := newCodec(t.Elem(), name, e)
+	return &codec{
+		enc: func(o *Buffer, v reflect.Value) error {
+			n := v.Len()
+			if err := o.putLen(n, e.len); err != nil {
+				return err
+			}
+			for i := 0; i < n; i++ {
+				if err := elem.enc(o, v.Index(i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		dec: func(o *Buffer, v reflect.Value) error {
+			n, err := o.getLen(e.len)
+			if err != nil {
+				return err
+			}
+			s := reflect.MakeSlice(t, n, n)
+			for i := 0; i < n; i++ {
+				if err := elem.dec(o, s.Index(i)); err != nil {
+					return err
+				}
+			}
+			v.Set(s)
+			return nil
+		},
+		size: func(o *Buffer, v reflect.Value) int {
+			ret := lenSize(v.Len(), e.len)
+			for i := 0; i < v.Len(); i++ {
+				ret += elem.size(o, v.Index(i))
+			}
+			return ret
+		},
+	}
+}
+
+// the elements, the length is the one of the type
+func arrayCodec(t reflect.Type, name string, e encoding) *codec {
+	elem := newCodec(t.Elem(), name, e)
+	return &codec{
+		enc: func(o *Buffer, v reflect.Value) error {
+			for i := 0; i < v.Len(); i++ {
+				if err := elem.enc(o, v.Index(i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		dec: func(o *Buffer, v reflect.Value) error {
+			for i := 0; i < v.Len(); i++ {
+				if err := elem.dec(o, v.Index(i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		size: func(o *Buffer, v reflect.Value) int {
+			ret := 0
+			for i := 0; i < v.Len(); i++ {
+				ret += elem.size(o, v.Index(i))
+			}
+			return ret
+		},
+	}
+}
+
+// the length then the key value pairs in the order of the keys, so that
+// equal maps are encoded to the same bytes
+func mapCodec(t reflect.Type, name string, e encoding) *codec {
+	key := newCodec(t.Key(), name, e)
+	elem := newCodec(t.Elem(), name, e)
+	return &codec{
+		enc: func(o *Buffer, v reflect.Value) error {
+			keys := sortedKeys(key, v)
+			if err := o.putLen(len(keys), e.len); err != nil {
+				return err
+			}
+			for _, k := range keys {
+				if err := key.enc(o, k); err != nil {
+					return err
+				}
+				if err := elem.enc(o, v.MapIndex(k)); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		dec: func(o *Buffer, v reflect.Value) error {
+			n, err := o.getLen(e.len)
+			if err != nil {
+				return err
+			}
+			m := reflect.MakeMapWithSize(t, n)
+			for i := 0; i < n; i++ {
+				k := reflect.New(t.Key()).Elem()
+				if err := key.dec(o, k); err != nil {
+					return err
+				}
+				e := reflect.New(t.Elem()).Elem()
+				if err := elem.dec(o, e); err != nil {
+					return err
+				}
+				m.SetMapIndex(k, e)
+			}
+			v.Set(m)
+			return nil
+		},
+		size: func(o *Buffer, v reflect.Value) int {
+			ret := lenSize(v.Len(), e.len)
+			iter := v.MapRange()
+			for iter.Next() {
+				ret += key.size(o, iter.Key()) + elem.size(o, iter.Value())
+			}
+			return ret
+		},
+	}
+}
+
+func sortedKeys(c *codec, m reflect.Value) []reflect.Value {
+	keys := m.MapKeys()
+	if len(keys) < 2 {
+		return keys
+	}
+	switch keys[0].Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		sort.Slice(keys, func(i, j int) bool { return keys[i].Int() < keys[j].Int() })
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		sort.Slice(keys, func(i, j int) bool { return keys[i].Uint() < keys[j].Uint() })
+	case reflect.Float32, reflect.Float64:
+		sort.Slice(keys, func(i, j int) bool { return keys[i].Float() < keys[j].Float() })
+	case reflect.String:
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	case reflect.Bool:
+		sort.Slice(keys, func(i, j int) bool { return !keys[i].Bool() && keys[j].Bool() })
+	default:
+		// by the encoded keys
+		encoded := make([][]byte, len(keys))
+		for i, k := range keys {
+			b := new(Buffer)
+			b.buf = make([]byte, c.size(b, k))
+			c.enc(b, k)
+			encoded[i] = b.buf
+		}
+		sort.Sort(byEncoded{keys, encoded})
+	}
+	return keys
+}
+
+type byEncoded struct {
+	keys    []reflect.Value
+	encoded [][]byte
+}
+
+func (s byEncoded) Len() int { return len(s.keys) }
+func (s byEncoded) Less(i, j int) bool {
+	return bytes.Compare(s.encoded[i], s.encoded[j]) < 0
+}
+func (s byEncoded) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.encoded[i], s.encoded[j] = s.encoded[j], s.encoded[i]
+}
+
+func (o *Buffer) putLen(n int, m LenMode) error {
+	switch m {
+	case Len32:
+		if uint64(n) > math.MaxUint32 {
+			return ErrLenOverflow
+		}
+		binary.LittleEndian.PutUint32(o.buf[o.index:], uint32(n))
+		o.index += 4
+		return nil
+	case LenVarint:
+		o.index += binary.PutUvarint(o.buf[o.index:], uint64(n))
+		return nil
+	default:
+		return o.putLen16(n)
+	}
+}
+
+// getLen reads a length, which can not be more than the bytes left as an
+// element takes one byte at least
+func (o *Buffer) getLen(m LenMode) (int, error) {
+	var n uint64
+	switch m {
+	case Len32:
+		i := o.index + 4
+		if i < 0 || i > len(o.buf) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		o.index = i
+		n = uint64(binary.LittleEndian.Uint32(o.buf[i-4:]))
+	case LenVarint:
+		var err error
+		if n, err = o.readUvarint(); err != nil {
+			return 0, err
+		}
+	default:
+		nb, err := o.readUInt16()
+		if err != nil {
+			return 0, err
+		}
+		n = uint64(nb)
+	}
+	if n > uint64(len(o.buf)-o.index) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return int(n), nil
+}
+
+func lenSize(n int, m LenMode) int {
+	switch m {
+	case Len32:
+		return 4
+	case LenVarint:
+		return uvarintSize(uint64(n))
+	default:
+		return 2
+	}
+}
+
+func (o *Buffer) readUvarint() (uint64, error) {
+	u, n := binary.Uvarint(o.buf[o.index:])
+	if n == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if n < 0 {
+		return 0, ErrIntOverflow
+	}
+	o.index += n
+	return u, nil
+}
+
+func uvarintSize(u uint64) int {
+	n := 1
+	for u >= 0x80 {
+		u >>= 7
+		n++
+	}
+	return n
+}
@@ -1,11 +1,27 @@
 package cstruct
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
-	ErrNil = errors.New("cstruct: Marshal called with nil")
+	ErrNil         = errors.New("cstruct: Marshal called with nil")
+	ErrIntOverflow = errors.New("cstruct: integer overflow")
+	ErrLenOverflow = errors.New("cstruct: length overflows the length prefix")
 )
 
+// FieldVerError 编码时字段的 tag ver 大于 struct 版本字段值
+type FieldVerError struct {
+	Field     string
+	Ver       int // tag ver
+	StructVer int // struct 版本字段值
+}
+
+func (e *FieldVerError) Error() string {
+	return fmt.Sprintf("cstruct: field %v ver %v > Ver %v", e.Field, e.Ver, e.StructVer)
+}
+
 type IStruct interface {
 }
 
@@ -14,3 +30,26 @@ var OptionSliceIgnoreNil = false
 
 // OptionSliceStructPointer slice 元素类型为结构体时，是否要求为结构体指针
 var OptionSliceStructPointer = true
+
+// OptionIntSize int uint 编码的字节数，4 或 8，超出范围时编码返回 ErrIntOverflow；
+// 需在第一次编码前设置
+var OptionIntSize = 8
+
+// LenMode 字符串、slice、map 长度前缀的编码
+type LenMode int
+
+const (
+	Len16     LenMode = iota // uint16，默认
+	Len32                    // uint32
+	LenVarint                // varint
+)
+
+// OptionLenMode 长度前缀的编码，超出范围时编码返回 ErrLenOverflow；需在第一次编码前设置
+var OptionLenMode = Len16
+
+// OptionVarint 整数是否用 varint 编码（int8 uint8 除外），有符号数先做 zigzag；需在第一次编码前设置
+var OptionVarint = false
+
+// EncodingTagName 字段编码的 tag 名，单独指定字段的编码，覆盖 OptionLenMode OptionVarint，
+// 例如：Items []uint32 `cstruct:"len32,varint"`，可选 len16 len32 varlen varint fixed
+const EncodingTagName = "cstruct"
@@ -1,5 +1,7 @@
 package cstruct
 
+import "reflect"
+
 func Marshal(obj IStruct) ([]byte, error) {
 	p := NewBuffer(nil)
 	err := p.Marshal(obj)
@@ -9,7 +11,28 @@ func Marshal(obj IStruct) ([]byte, error) {
 	return p.buf, err
 }
 
-func GetSize(obj IStruct) (uint16, error) {
+// AppendMarshal appends the encoding of obj to dst, dst is grown at most once
+func AppendMarshal(dst []byte, obj IStruct) ([]byte, error) {
+	p := bufferPool.Get().(*Buffer)
+	pooled := p.buf
+	p.buf, p.index = dst, len(dst)
+	err := p.Append(obj)
+	b := p.buf[:p.index]
+	p.buf = pooled
+	bufferPool.Put(p)
+	if err != nil {
+		return dst, err
+	}
+	return b, nil
+}
+
+func GetSize(obj IStruct) (int, error) {
+	if m, ok := obj.(Marshaler); ok {
+		if reflect.ValueOf(obj).IsNil() {
+			return 0, ErrNil
+		}
+		return m.SizeCStruct(), nil
+	}
 	p := NewBuffer(nil)
 
 	t, base, err := getbase(obj)
@@ -19,7 +42,7 @@ func GetSize(obj IStruct) (uint16, error) {
 	if err == nil {
 		props := GetProperties(t.Elem())
 		s := p.size_struct(props, base)
-		return uint16(s), nil
+		return s, nil
 	}
 	return 0, err
 }
@@ -0,0 +1,106 @@
+package example_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/CreFire/leaf/util/cstruct-go"
+	"github.com/CreFire/leaf/util/cstruct-go/example"
+)
+
+// moveReflect is Move without the generated methods
+type moveReflect example.Move
+
+func newMove() *example.Move {
+	alias := "runner"
+	return &example.Move{
+		Ver:    2,
+		Seq:    300,
+		X:      1.5,
+		Y:      -2,
+		Path:   []int16{1, -1, 1000},
+		Name:   "move",
+		Target: &example.Item{ID: 7, Count: 1},
+		Bag:    []*example.Item{{ID: 1, Count: 2}, nil, {ID: 3, Count: 4}},
+		Flags:  map[string]int32{"b": 2, "a": 1},
+		Data:   []byte{1, 2, 3},
+		Speed:  -5,
+		Alias:  &alias,
+	}
+}
+
+func Example() {
+	m := newMove()
+	generated, err := cstruct.Marshal(m)
+	reflected, _ := cstruct.Marshal((*moveReflect)(m))
+	size, _ := cstruct.GetSize(m)
+	fmt.Println(err, bytes.Equal(generated, reflected), size == len(reflected))
+
+	out := new(example.Move)
+	err = cstruct.Unmarshal(reflected, out)
+	fmt.Println(err, out.Seq, out.Path, *out.Target, len(out.Bag), out.Flags, out.Speed, *out.Alias)
+
+	// a field newer than the Ver, the same error without the generated code
+	m.Ver = 1
+	_, err = cstruct.Marshal(m)
+	_, err2 := cstruct.Marshal((*moveReflect)(m))
+	fmt.Println(err)
+	fmt.Println(err2)
+
+	// Output:
+	// <nil> true true
+	// <nil> 300 [1 -1 1000] {7 1} 3 map[a:1 b:2] -5 runner
+	// cstruct: field Alias ver 2 > Ver 1
+	// cstruct: field Alias ver 2 > Ver 1
+}
+
+func BenchmarkMarshalReflect(b *testing.B) {
+	m := (*moveReflect)(newMove())
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		cstruct.Marshal(m)
+	}
+}
+
+func BenchmarkMarshalGenerated(b *testing.B) {
+	m := newMove()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		cstruct.Marshal(m)
+	}
+}
+
+func BenchmarkAppendMarshalReflect(b *testing.B) {
+	m := (*moveReflect)(newMove())
+	buf := make([]byte, 0, 256)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf, _ = cstruct.AppendMarshal(buf[:0], m)
+	}
+}
+
+func BenchmarkAppendMarshalGenerated(b *testing.B) {
+	m := newMove()
+	buf := make([]byte, 0, 256)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf, _ = cstruct.AppendMarshal(buf[:0], m)
+	}
+}
+
+func BenchmarkUnmarshalReflect(b *testing.B) {
+	data, _ := cstruct.Marshal(newMove())
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		cstruct.Unmarshal(data, new(moveReflect))
+	}
+}
+
+func BenchmarkUnmarshalGenerated(b *testing.B) {
+	data, _ := cstruct.Marshal(newMove())
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		cstruct.Unmarshal(data, new(example.Move))
+	}
+}
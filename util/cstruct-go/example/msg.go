@@ -0,0 +1,26 @@
+// Package example holds messages with the methods generated by cstructgen.
+package example
+
+//go:generate go run github.com/CreFire/leaf/cmd/cstructgen -o msg_cstruct.go msg.go
+
+type ItemID uint32
+
+type Item struct {
+	ID    ItemID
+	Count uint16
+}
+
+type Move struct {
+	Ver    uint8
+	Seq    uint32 `cstruct:"varint"`
+	X, Y   float32
+	Path   []int16
+	Name   string
+	Target *Item
+	Bag    []*Item
+	Slots  [4]Item
+	Flags  map[string]int32
+	Data   []byte
+	Speed  int     `ver:"1"`
+	Alias  *string `ver:"2"`
+}
@@ -0,0 +1,329 @@
+// Code generated by cstructgen. DO NOT EDIT.
+
+package example
+
+import "github.com/CreFire/leaf/util/cstruct-go"
+
+func (m *Item) SizeCStruct() int {
+	n := 0
+	n += cstruct.SizeUint(uint64(m.ID), 4, cstruct.OptionVarint)
+	n += cstruct.SizeUint(uint64(m.Count), 2, cstruct.OptionVarint)
+	return n
+}
+
+func (m *Item) MarshalCStruct(o *cstruct.Buffer) error {
+	if err := o.WriteUint(uint64(m.ID), 4, cstruct.OptionVarint); err != nil {
+		return err
+	}
+	if err := o.WriteUint(uint64(m.Count), 2, cstruct.OptionVarint); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *Item) UnmarshalCStruct(o *cstruct.Buffer) error {
+	v1, err := o.ReadUint(4, cstruct.OptionVarint)
+	if err != nil {
+		return err
+	}
+	m.ID = ItemID(v1)
+	v2, err := o.ReadUint(2, cstruct.OptionVarint)
+	if err != nil {
+		return err
+	}
+	m.Count = uint16(v2)
+	return nil
+}
+
+func (m *Move) SizeCStruct() int {
+	n := 0
+	n++
+	n += cstruct.SizeUint(uint64(m.Seq), 4, true)
+	n += 4
+	n += 4
+	n += cstruct.LenSize(len(m.Path), cstruct.OptionLenMode)
+	for i1 := range m.Path {
+		n += cstruct.SizeInt(int64(m.Path[i1]), 2, cstruct.OptionVarint)
+	}
+	n += cstruct.LenSize(len(m.Name), cstruct.OptionLenMode) + len(m.Name)
+	n++
+	if m.Target != nil {
+		n += m.Target.SizeCStruct()
+	}
+	if cstruct.IgnoreNil(cstruct.OptionLenMode, cstruct.OptionVarint) {
+		n += 2
+		for _, v2 := range m.Bag {
+			if v2 != nil {
+				n += v2.SizeCStruct()
+			}
+		}
+	} else {
+		n += cstruct.LenSize(len(m.Bag), cstruct.OptionLenMode)
+		for i3 := range m.Bag {
+			n++
+			if m.Bag[i3] != nil {
+				n += m.Bag[i3].SizeCStruct()
+			}
+		}
+	}
+	for i4 := range m.Slots {
+		n += m.Slots[i4].SizeCStruct()
+	}
+	n += cstruct.LenSize(len(m.Flags), cstruct.OptionLenMode)
+	for k5, v6 := range m.Flags {
+		n += cstruct.LenSize(len(k5), cstruct.OptionLenMode) + len(k5)
+		n += cstruct.SizeInt(int64(v6), 4, cstruct.OptionVarint)
+	}
+	n += cstruct.LenSize(len(m.Data), cstruct.OptionLenMode)
+	n += len(m.Data)
+	n += cstruct.SizeInt(int64(m.Speed), cstruct.OptionIntSize, cstruct.OptionVarint)
+	n++
+	if m.Alias != nil {
+		n += cstruct.LenSize(len((*m.Alias)), cstruct.OptionLenMode) + len((*m.Alias))
+	}
+	return n
+}
+
+func (m *Move) MarshalCStruct(o *cstruct.Buffer) error {
+	ver := -2
+	if err := o.WriteUint(uint64(m.Ver), 1, cstruct.OptionVarint); err != nil {
+		return err
+	}
+	ver = int(m.Ver)
+	if err := o.WriteUint(uint64(m.Seq), 4, true); err != nil {
+		return err
+	}
+	o.WriteFloat32(float32(m.X))
+	o.WriteFloat32(float32(m.Y))
+	if err := o.WriteLen(len(m.Path), cstruct.OptionLenMode); err != nil {
+		return err
+	}
+	for i7 := range m.Path {
+		if err := o.WriteInt(int64(m.Path[i7]), 2, cstruct.OptionVarint); err != nil {
+			return err
+		}
+	}
+	if err := o.WriteString(string(m.Name), cstruct.OptionLenMode); err != nil {
+		return err
+	}
+	if m.Target == nil {
+		o.WriteUint8(0)
+	} else {
+		o.WriteUint8(1)
+		if err := m.Target.MarshalCStruct(o); err != nil {
+			return err
+		}
+	}
+	if cstruct.IgnoreNil(cstruct.OptionLenMode, cstruct.OptionVarint) {
+		c8 := 0
+		for _, v9 := range m.Bag {
+			if v9 != nil {
+				c8++
+			}
+		}
+		if err := o.WriteLen(c8, cstruct.OptionLenMode); err != nil {
+			return err
+		}
+		for _, v9 := range m.Bag {
+			if v9 != nil {
+				if err := v9.MarshalCStruct(o); err != nil {
+					return err
+				}
+			}
+		}
+	} else {
+		if err := o.WriteLen(len(m.Bag), cstruct.OptionLenMode); err != nil {
+			return err
+		}
+		for i10 := range m.Bag {
+			if m.Bag[i10] == nil {
+				o.WriteUint8(0)
+			} else {
+				o.WriteUint8(1)
+				if err := m.Bag[i10].MarshalCStruct(o); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for i11 := range m.Slots {
+		if err := m.Slots[i11].MarshalCStruct(o); err != nil {
+			return err
+		}
+	}
+	if err := o.WriteLen(len(m.Flags), cstruct.OptionLenMode); err != nil {
+		return err
+	}
+	var buf14 [8]string
+	for _, k12 := range cstruct.AppendSortedKeys(buf14[:0], m.Flags) {
+		if err := o.WriteString(string(k12), cstruct.OptionLenMode); err != nil {
+			return err
+		}
+		v13 := m.Flags[k12]
+		if err := o.WriteInt(int64(v13), 4, cstruct.OptionVarint); err != nil {
+			return err
+		}
+	}
+	if err := o.WriteBytes(m.Data, cstruct.OptionLenMode); err != nil {
+		return err
+	}
+	if 1 > ver {
+		return &cstruct.FieldVerError{Field: "Speed", Ver: 1, StructVer: ver}
+	}
+	if err := o.WriteInt(int64(m.Speed), cstruct.OptionIntSize, cstruct.OptionVarint); err != nil {
+		return err
+	}
+	if 2 > ver {
+		return &cstruct.FieldVerError{Field: "Alias", Ver: 2, StructVer: ver}
+	}
+	if m.Alias == nil {
+		o.WriteUint8(0)
+	} else {
+		o.WriteUint8(1)
+		if err := o.WriteString(string((*m.Alias)), cstruct.OptionLenMode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Move) UnmarshalCStruct(o *cstruct.Buffer) error {
+	ver := -2
+	v15, err := o.ReadUint(1, cstruct.OptionVarint)
+	if err != nil {
+		return err
+	}
+	m.Ver = uint8(v15)
+	ver = int(m.Ver)
+	v16, err := o.ReadUint(4, true)
+	if err != nil {
+		return err
+	}
+	m.Seq = uint32(v16)
+	v17, err := o.ReadFloat32()
+	if err != nil {
+		return err
+	}
+	m.X = float32(v17)
+	v18, err := o.ReadFloat32()
+	if err != nil {
+		return err
+	}
+	m.Y = float32(v18)
+	n19, err := o.ReadLen(cstruct.OptionLenMode)
+	if err != nil {
+		return err
+	}
+	m.Path = make([]int16, n19)
+	for i20 := range m.Path {
+		v21, err := o.ReadInt(2, cstruct.OptionVarint)
+		if err != nil {
+			return err
+		}
+		m.Path[i20] = int16(v21)
+	}
+	v22, err := o.ReadString(cstruct.OptionLenMode)
+	if err != nil {
+		return err
+	}
+	m.Name = string(v22)
+	f23, err := o.ReadUint8()
+	if err != nil {
+		return err
+	}
+	if f23 == 0 {
+		m.Target = nil
+	} else {
+		m.Target = new(Item)
+		if err := m.Target.UnmarshalCStruct(o); err != nil {
+			return err
+		}
+	}
+	if cstruct.IgnoreNil(cstruct.OptionLenMode, cstruct.OptionVarint) {
+		n24, err := o.ReadLen(cstruct.OptionLenMode)
+		if err != nil {
+			return err
+		}
+		m.Bag = make([]*Item, n24)
+		for i25 := range m.Bag {
+			m.Bag[i25] = new(Item)
+			if err := m.Bag[i25].UnmarshalCStruct(o); err != nil {
+				return err
+			}
+		}
+	} else {
+		n26, err := o.ReadLen(cstruct.OptionLenMode)
+		if err != nil {
+			return err
+		}
+		m.Bag = make([]*Item, n26)
+		for i27 := range m.Bag {
+			f28, err := o.ReadUint8()
+			if err != nil {
+				return err
+			}
+			if f28 == 0 {
+				m.Bag[i27] = nil
+			} else {
+				m.Bag[i27] = new(Item)
+				if err := m.Bag[i27].UnmarshalCStruct(o); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for i29 := range m.Slots {
+		if err := m.Slots[i29].UnmarshalCStruct(o); err != nil {
+			return err
+		}
+	}
+	n30, err := o.ReadLen(cstruct.OptionLenMode)
+	if err != nil {
+		return err
+	}
+	m.Flags = make(map[string]int32, n30)
+	for i31 := 0; i31 < n30; i31++ {
+		var k32 string
+		v34, err := o.ReadString(cstruct.OptionLenMode)
+		if err != nil {
+			return err
+		}
+		k32 = string(v34)
+		var v33 int32
+		v35, err := o.ReadInt(4, cstruct.OptionVarint)
+		if err != nil {
+			return err
+		}
+		v33 = int32(v35)
+		m.Flags[k32] = v33
+	}
+	v36, err := o.ReadBytes(cstruct.OptionLenMode)
+	if err != nil {
+		return err
+	}
+	m.Data = []byte(v36)
+	if 1 <= ver {
+		v37, err := o.ReadInt(cstruct.OptionIntSize, cstruct.OptionVarint)
+		if err != nil {
+			return err
+		}
+		m.Speed = int(v37)
+	}
+	if 2 <= ver {
+		f38, err := o.ReadUint8()
+		if err != nil {
+			return err
+		}
+		if f38 == 0 {
+			m.Alias = nil
+		} else {
+			m.Alias = new(string)
+			v39, err := o.ReadString(cstruct.OptionLenMode)
+			if err != nil {
+				return err
+			}
+			(*m.Alias) = string(v39)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package cstruct_test
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/CreFire/leaf/util/cstruct-go"
+)
+
+func roundTrip(in interface{}, out interface{}) {
+	data, err := cstruct.Marshal(in)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := cstruct.Unmarshal(data, out); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func Example_map() {
+	type Item struct {
+		ID    uint32
+		Count uint16
+	}
+	type Bag struct {
+		Items  map[uint32]*Item
+		Names  map[string]string
+		Groups map[int32][]uint32
+	}
+
+	in := &Bag{
+		Items:  map[uint32]*Item{3: {3, 1}, 1: {1, 10}},
+		Names:  map[string]string{"b": "bow", "a": "axe"},
+		Groups: map[int32][]uint32{-1: {7}, 2: nil},
+	}
+	out := new(Bag)
+	roundTrip(in, out)
+	fmt.Println(*out.Items[1], *out.Items[3], out.Names, out.Groups)
+
+	// the keys are sorted, equal maps have the same bytes
+	for i := 0; i < 2; i++ {
+		a, _ := cstruct.Marshal(in)
+		b, _ := cstruct.Marshal(&Bag{Items: in.Items, Names: map[string]string{"a": "axe", "b": "bow"}, Groups: in.Groups})
+		fmt.Println(bytes.Equal(a, b))
+	}
+
+	// Output:
+	// {1 10} {3 1} map[a:axe b:bow] map[-1:[7] 2:[]]
+	// true
+	// true
+}
+
+func Example_int() {
+	type Player struct {
+		Level int
+		Gold  uint
+		Exp   []int
+		Pos   [2]int
+	}
+
+	in := &Player{Level: -3, Gold: 1 << 40, Exp: []int{1, -2}, Pos: [2]int{5, 6}}
+	out := new(Player)
+	roundTrip(in, out)
+	fmt.Println(*out)
+
+	size, _ := cstruct.GetSize(in)
+	fmt.Println(size)
+
+	// Output:
+	// {-3 1099511627776 [1 -2] [5 6]}
+	// 50
+}
+
+func Example_intSize() {
+	// set before the first use of the types
+	cstruct.OptionIntSize = 4
+	defer func() { cstruct.OptionIntSize = 8 }()
+
+	type Score struct {
+		Value int
+	}
+
+	data, _ := cstruct.Marshal(&Score{Value: -7})
+	out := new(Score)
+	cstruct.Unmarshal(data, out)
+	fmt.Println(len(data), out.Value)
+
+	_, err := cstruct.Marshal(&Score{Value: 1 << 40})
+	fmt.Println(err)
+
+	// Output:
+	// 4 -7
+	// cstruct: integer overflow
+}
+
+func Example_len32() {
+	type Dump struct {
+		Small string
+		Data  []byte `cstruct:"len32"`
+	}
+
+	in := &Dump{Small: "s", Data: make([]byte, 100000)}
+	data, err := cstruct.Marshal(in)
+	size, _ := cstruct.GetSize(in)
+	out := new(Dump)
+	cstruct.Unmarshal(data, out)
+	fmt.Println(err, size, len(out.Data))
+
+	// over 64 KiB in a uint16 length
+	_, err = cstruct.Marshal(&Dump{Small: string(make([]byte, 70000))})
+	fmt.Println(err)
+
+	// Output:
+	// <nil> 100007 100000
+	// cstruct: length overflows the length prefix
+}
+
+func Example_varint() {
+	type Move struct {
+		ID    uint64  `cstruct:"varint"`
+		DX    int32   `cstruct:"varint"`
+		Path  []int16 `cstruct:"varlen,varint"`
+		Fixed uint32
+	}
+
+	in := &Move{ID: 300, DX: -2, Path: []int16{-1, 1, 1000}, Fixed: 1}
+	data, _ := cstruct.Marshal(in)
+	out := new(Move)
+	err := cstruct.Unmarshal(data, out)
+	// 2 + 1 + (1 + 1+1+2) + 4
+	fmt.Println(err, len(data), *out)
+
+	// a value too large for the field
+	type Narrow struct {
+		V int16 `cstruct:"varint"`
+	}
+	type Wide struct {
+		V int64 `cstruct:"varint"`
+	}
+	data, _ = cstruct.Marshal(&Wide{V: 1 << 20})
+	fmt.Println(cstruct.Unmarshal(data, new(Narrow)))
+
+	// Output:
+	// <nil> 12 {300 -2 [-1 1 1000] 1}
+	// cstruct: integer overflow
+}
+
+func Example_append() {
+	type Ping struct {
+		Seq  uint32
+		Name string
+	}
+
+	// a header and the body in one buffer
+	buf := make([]byte, 0, 64)
+	buf = append(buf, 0xff)
+	buf, err := cstruct.AppendMarshal(buf, &Ping{Seq: 1, Name: "a"})
+	fmt.Println(err, buf)
+
+	// the pooled buffers reuse their bytes
+	b := cstruct.GetBuffer()
+	b.Append(&Ping{Seq: 2})
+	b.Append(&Ping{Seq: 3})
+	fmt.Println(b.Bytes())
+	cstruct.PutBuffer(b)
+
+	// Output:
+	// <nil> [255 1 0 0 0 1 0 97]
+	// [2 0 0 0 0 0 3 0 0 0 0 0]
+}
+
+func Example_nested() {
+	type Cell struct {
+		X, Y int16
+	}
+	type Map struct {
+		Heights [][]uint64
+		Tags    [][]string
+		Cells   [][]*Cell
+		Rows    [][3]uint8
+		Layers  [2][]int32
+		Names   [2]string
+		Deep    [][][]uint32
+	}
+
+	in := &Map{
+		Heights: [][]uint64{{1, 2}, {}, {3}},
+		Tags:    [][]string{{"a", "b"}, {"c"}},
+		Cells:   [][]*Cell{{{1, 2}, nil}, {{3, 4}}},
+		Rows:    [][3]uint8{{1, 2, 3}, {4, 5, 6}},
+		Layers:  [2][]int32{{-1}, {2, 3}},
+		Names:   [2]string{"x", "y"},
+		Deep:    [][][]uint32{{{1}, {2, 3}}, {}},
+	}
+	out := new(Map)
+	roundTrip(in, out)
+	fmt.Println(out.Heights, out.Tags, *out.Cells[0][0], out.Cells[0][1], *out.Cells[1][0])
+	fmt.Println(out.Rows, out.Layers, out.Names, out.Deep)
+
+	// Output:
+	// [[1 2] [] [3]] [[a b] [c]] {1 2} <nil> {3 4}
+	// [[1 2 3] [4 5 6]] [[-1] [2 3]] [x y] [[[1] [2 3]] []]
+}
+
+func Example_optional() {
+	type Update struct {
+		HP    *int32
+		Name  *string
+		Alive *bool
+		Speed *float32
+	}
+
+	hp, alive := int32(80), false
+	in := &Update{HP: &hp, Alive: &alive}
+	out := &Update{Name: new(string)}
+	roundTrip(in, out)
+	fmt.Println(*out.HP, out.Name, *out.Alive, out.Speed)
+
+	// Output:
+	// 80 <nil> false <nil>
+}
+
+func Example_unsupported() {
+	type Bad struct {
+		F func()
+	}
+
+	defer func() {
+		fmt.Println(recover())
+	}()
+	cstruct.Marshal(&Bad{})
+
+	// Output:
+	// cstruct: unknow type. field name = F
+}
+
+func ExampleAppendSortedKeys() {
+	m := map[int32]bool{}
+	for _, k := range []int32{9, -3, 14, 0, 7, 21, -8, 5, 11, 2, 30, -1, 17, 4} {
+		m[k] = true
+	}
+	var buf [16]int32
+	fmt.Println(cstruct.AppendSortedKeys(buf[:0], m))
+	fmt.Println(cstruct.SortedKeys(map[string]int{"b": 1, "a": 2, "": 3}))
+
+	// Output:
+	// [-8 -3 -1 0 2 4 5 7 9 11 14 17 21 30]
+	// [ a b]
+}
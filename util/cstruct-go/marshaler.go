@@ -0,0 +1,400 @@
+package cstruct
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"reflect"
+)
+
+// Marshaler is implemented by the methods generated by cmd/cstructgen,
+// which encode in the same layout as the reflection without it. Marshal,
+// Unmarshal, GetSize and the struct fields prefer the methods.
+type Marshaler interface {
+	SizeCStruct() int
+	MarshalCStruct(o *Buffer) error
+	UnmarshalCStruct(o *Buffer) error
+}
+
+var marshalerType = reflect.TypeOf((*Marshaler)(nil)).Elem()
+
+// struct field of a Marshaler
+func (o *Buffer) enc_marshaler(p *Properties, base structPointer) error {
+	return structPointer_NewAt(base, p.field, p.t).Interface().(Marshaler).MarshalCStruct(o)
+}
+
+func (o *Buffer) dec_marshaler(p *Properties, base structPointer) error {
+	return structPointer_NewAt(base, p.field, p.t).Interface().(Marshaler).UnmarshalCStruct(o)
+}
+
+func (o *Buffer) size_marshaler(p *Properties, base structPointer) int {
+	return structPointer_NewAt(base, p.field, p.t).Interface().(Marshaler).SizeCStruct()
+}
+
+// struct ptr field of a Marshaler
+func (o *Buffer) enc_marshaler_ptr(p *Properties, base structPointer) error {
+	v := structPointer_GetStructPointer(base, p.field)
+	if v == nil {
+		o.buf[o.index] = 0
+		o.index++
+		return nil
+	}
+	o.buf[o.index] = 1
+	o.index++
+	return structPointer_Interface(v, p.stype).(Marshaler).MarshalCStruct(o)
+}
+
+func (o *Buffer) dec_marshaler_ptr(p *Properties, base structPointer) error {
+	i := o.index + 1
+	if i < 0 || i > len(o.buf) {
+		return io.ErrUnexpectedEOF
+	}
+	o.index = i
+	if o.buf[i-1] == 0 {
+		return nil
+	}
+	v := structPointer_GetStructPointer(base, p.field)
+	if structPointer_IsNil(v) {
+		v = toStructPointer(reflect.New(p.stype))
+		structPointer_SetStructPointer(base, p.field, v)
+	}
+	return structPointer_Interface(v, p.stype).(Marshaler).UnmarshalCStruct(o)
+}
+
+func (o *Buffer) size_marshaler_ptr(p *Properties, base structPointer) int {
+	v := structPointer_GetStructPointer(base, p.field)
+	if v == nil {
+		return 1
+	}
+	return 1 + structPointer_Interface(v, p.stype).(Marshaler).SizeCStruct()
+}
+
+// The functions below are used by the generated code, the Write functions
+// grow the buffer if needed.
+
+func (o *Buffer) grow(n int) []byte {
+	if o.index+n > len(o.buf) {
+		if o.index+n <= cap(o.buf) {
+			o.buf = o.buf[:o.index+n]
+		} else {
+			buf := make([]byte, o.index+n, 2*cap(o.buf)+n)
+			copy(buf, o.buf)
+			o.buf = buf
+		}
+	}
+	b := o.buf[o.index : o.index+n]
+	o.index += n
+	return b
+}
+
+func (o *Buffer) next(n int) ([]byte, error) {
+	i := o.index + n
+	if i < o.index || i > len(o.buf) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := o.buf[o.index:i]
+	o.index = i
+	return b, nil
+}
+
+// Bytes returns the encoded bytes
+func (o *Buffer) Bytes() []byte {
+	return o.buf[:o.index]
+}
+
+// Index returns the offset of the next read or write
+func (o *Buffer) Index() int {
+	return o.index
+}
+
+func (o *Buffer) WriteBool(v bool) {
+	b := o.grow(1)
+	b[0] = 0
+	if v {
+		b[0] = 1
+	}
+}
+
+func (o *Buffer) WriteUint8(v uint8) {
+	o.grow(1)[0] = v
+}
+
+func (o *Buffer) WriteFloat32(v float32) {
+	binary.LittleEndian.PutUint32(o.grow(4), math.Float32bits(v))
+}
+
+func (o *Buffer) WriteFloat64(v float64) {
+	binary.LittleEndian.PutUint64(o.grow(8), math.Float64bits(v))
+}
+
+// WriteInt writes v in size bytes or as a zigzag varint checked to fit in
+// size bytes, size is 1, 2, 4 or 8
+func (o *Buffer) WriteInt(v int64, size int, varint bool) error {
+	if size < 8 && (v < -1<<(size*8-1) || v >= 1<<(size*8-1)) {
+		return ErrIntOverflow
+	}
+	if varint && size > 1 {
+		o.writeUvarint(uint64(v<<1) ^ uint64(v>>63))
+		return nil
+	}
+	o.writeFixed(uint64(v), size)
+	return nil
+}
+
+// WriteUint is WriteInt of an unsigned integer, without zigzag
+func (o *Buffer) WriteUint(v uint64, size int, varint bool) error {
+	if size < 8 && v >= 1<<(size*8) {
+		return ErrIntOverflow
+	}
+	if varint && size > 1 {
+		o.writeUvarint(v)
+		return nil
+	}
+	o.writeFixed(v, size)
+	return nil
+}
+
+func (o *Buffer) writeFixed(v uint64, size int) {
+	b := o.grow(size)
+	switch size {
+	case 1:
+		b[0] = uint8(v)
+	case 2:
+		binary.LittleEndian.PutUint16(b, uint16(v))
+	case 4:
+		binary.LittleEndian.PutUint32(b, uint32(v))
+	default:
+		binary.LittleEndian.PutUint64(b, v)
+	}
+}
+
+func (o *Buffer) writeUvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	copy(o.grow(n), b[:n])
+}
+
+func (o *Buffer) WriteLen(n int, m LenMode) error {
+	size := lenSize(n, m)
+	o.grow(size)
+	o.index -= size
+	return o.putLen(n, m)
+}
+
+func (o *Buffer) WriteString(s string, m LenMode) error {
+	if err := o.WriteLen(len(s), m); err != nil {
+		return err
+	}
+	copy(o.grow(len(s)), s)
+	return nil
+}
+
+func (o *Buffer) WriteBytes(s []byte, m LenMode) error {
+	if err := o.WriteLen(len(s), m); err != nil {
+		return err
+	}
+	copy(o.grow(len(s)), s)
+	return nil
+}
+
+func (o *Buffer) ReadBool() (bool, error) {
+	b, err := o.next(1)
+	if err != nil {
+		return false, err
+	}
+	return b[0] != 0, nil
+}
+
+func (o *Buffer) ReadUint8() (uint8, error) {
+	b, err := o.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (o *Buffer) ReadFloat32() (float32, error) {
+	b, err := o.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float32frombits(binary.LittleEndian.Uint32(b)), nil
+}
+
+func (o *Buffer) ReadFloat64() (float64, error) {
+	b, err := o.next(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+}
+
+// ReadInt reads an integer written by WriteInt, checked to fit in size bytes
+func (o *Buffer) ReadInt(size int, varint bool) (int64, error) {
+	if varint && size > 1 {
+		u, err := o.readUvarint()
+		if err != nil {
+			return 0, err
+		}
+		v := int64(u>>1) ^ -int64(u&1)
+		if size < 8 && (v < -1<<(size*8-1) || v >= 1<<(size*8-1)) {
+			return 0, ErrIntOverflow
+		}
+		return v, nil
+	}
+	b, err := o.next(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return int64(int8(b[0])), nil
+	case 2:
+		return int64(int16(binary.LittleEndian.Uint16(b))), nil
+	case 4:
+		return int64(int32(binary.LittleEndian.Uint32(b))), nil
+	default:
+		return int64(binary.LittleEndian.Uint64(b)), nil
+	}
+}
+
+// ReadUint reads an integer written by WriteUint
+func (o *Buffer) ReadUint(size int, varint bool) (uint64, error) {
+	if varint && size > 1 {
+		v, err := o.readUvarint()
+		if err != nil {
+			return 0, err
+		}
+		if size < 8 && v >= 1<<(size*8) {
+			return 0, ErrIntOverflow
+		}
+		return v, nil
+	}
+	b, err := o.next(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.LittleEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.LittleEndian.Uint32(b)), nil
+	default:
+		return binary.LittleEndian.Uint64(b), nil
+	}
+}
+
+// ReadLen reads a length, which is not more than the bytes left
+func (o *Buffer) ReadLen(m LenMode) (int, error) {
+	return o.getLen(m)
+}
+
+func (o *Buffer) ReadString(m LenMode) (string, error) {
+	n, err := o.getLen(m)
+	if err != nil {
+		return "", err
+	}
+	b, _ := o.next(n)
+	return string(b), nil
+}
+
+// ReadBytes returns a copy of the bytes
+func (o *Buffer) ReadBytes(m LenMode) ([]byte, error) {
+	n, err := o.getLen(m)
+	if err != nil {
+		return nil, err
+	}
+	b, _ := o.next(n)
+	return append([]byte(nil), b...), nil
+}
+
+// SizeInt is the size of an integer written by WriteInt
+func SizeInt(v int64, size int, varint bool) int {
+	if varint && size > 1 {
+		return uvarintSize(uint64(v<<1) ^ uint64(v>>63))
+	}
+	return size
+}
+
+// SizeUint is the size of an integer written by WriteUint
+func SizeUint(v uint64, size int, varint bool) int {
+	if varint && size > 1 {
+		return uvarintSize(v)
+	}
+	return size
+}
+
+func LenSize(n int, m LenMode) int {
+	return lenSize(n, m)
+}
+
+// IgnoreNil reports whether a []*struct field in the encoding skips the
+// nil elements without flags, see OptionSliceIgnoreNil
+func IgnoreNil(m LenMode, varint bool) bool {
+	return OptionSliceIgnoreNil && m == Len16 && !varint
+}
+
+type ordered interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 | ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 |
+		~float32 | ~float64 | ~string
+}
+
+// SortedKeys returns the keys of a map of ordered keys in order, the order
+// of the map encoding
+func SortedKeys[K ordered, V any](m map[K]V) []K {
+	return AppendSortedKeys(nil, m)
+}
+
+// AppendSortedKeys appends the keys of m in order to dst, which does not
+// allocate if dst has room for them, e.g. a local array
+//
+//	var buf [8]string
+//	for _, k := range cstruct.AppendSortedKeys(buf[:0], m) {
+func AppendSortedKeys[K ordered, V any](dst []K, m map[K]V) []K {
+	n := len(dst)
+	for k := range m {
+		dst = append(dst, k)
+	}
+	sortKeys(dst[n:])
+	return dst
+}
+
+// sortKeys sorts without sort.Interface, which would move keys to the heap
+func sortKeys[K ordered](keys []K) {
+	if len(keys) <= 12 {
+		for i := 1; i < len(keys); i++ {
+			for j := i; j > 0 && keys[j] < keys[j-1]; j-- {
+				keys[j], keys[j-1] = keys[j-1], keys[j]
+			}
+		}
+		return
+	}
+
+	// heap sort
+	for i := len(keys)/2 - 1; i >= 0; i-- {
+		siftDown(keys, i)
+	}
+	for end := len(keys) - 1; end > 0; end-- {
+		keys[0], keys[end] = keys[end], keys[0]
+		siftDown(keys[:end], 0)
+	}
+}
+
+func siftDown[K ordered](keys []K, i int) {
+	for {
+		c := 2*i + 1
+		if c >= len(keys) {
+			return
+		}
+		if c+1 < len(keys) && keys[c] < keys[c+1] {
+			c++
+		}
+		if !(keys[i] < keys[c]) {
+			return
+		}
+		keys[i], keys[c] = keys[c], keys[i]
+		i = c
+	}
+}
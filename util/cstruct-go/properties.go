@@ -16,6 +16,8 @@ type StructProperties struct {
 var (
 	propertiesMu  sync.RWMutex
 	propertiesMap = make(map[reflect.Type]*StructProperties)
+	// the types added by the current getPropertiesLocked
+	building []reflect.Type
 )
 
 func GetProperties(t reflect.Type) *StructProperties {
@@ -31,8 +33,20 @@ func GetProperties(t reflect.Type) *StructProperties {
 	}
 
 	propertiesMu.Lock()
+	defer propertiesMu.Unlock()
+	defer func() {
+		// drop the half built properties of an unknown type, so that the
+		// type panics again instead of being used
+		if r := recover(); r != nil {
+			for _, t := range building {
+				delete(propertiesMap, t)
+			}
+			building = building[:0]
+			panic(r)
+		}
+	}()
 	sprop = getPropertiesLocked(t)
-	propertiesMu.Unlock()
+	building = building[:0]
 	return sprop
 }
 
@@ -43,6 +57,7 @@ func getPropertiesLocked(t reflect.Type) *StructProperties {
 
 	prop := new(StructProperties)
 	propertiesMap[t] = prop
+	building = append(building, t)
 	prop.Prop = make([]*Properties, t.NumField())
 	for i := 0; i < t.NumField(); i++ {
 		f := t.Field(i)
@@ -71,6 +86,29 @@ type Properties struct {
 	t     reflect.Type
 	stype reflect.Type
 	sprop *StructProperties
+	codec *codec
+	encoding
+}
+
+// Type returns the type of the field
+func (p *Properties) Type() reflect.Type {
+	return p.t
+}
+
+// Ver returns the ver tag of the field, -1 for the Ver field and -2 for the
+// fields without a tag
+func (p *Properties) Ver() int {
+	return p.ver
+}
+
+// LenMode returns the length prefix of the field
+func (p *Properties) LenMode() LenMode {
+	return p.len
+}
+
+// Varint reports whether the integers of the field are varints
+func (p *Properties) Varint() bool {
+	return p.varint
 }
 
 func (p *Properties) init(typ reflect.Type, name string, tag *reflect.StructTag, f *reflect.StructField, fixedSize *int) {
@@ -91,6 +129,7 @@ func (p *Properties) init(typ reflect.Type, name string, tag *reflect.StructTag,
 			p.ver = -1
 		}
 	}
+	p.encoding = parseEncoding(*tag)
 	p.setEncAndDec(typ, f, fixedSize)
 }
 
@@ -98,8 +137,15 @@ func (p *Properties) setEncAndDec(typ reflect.Type, f *reflect.StructField, fixe
 	p.enc = nil
 	p.dec = nil
 	p.siz = nil
+	p.codec = nil
 	p.t = typ
 
+	// the fast paths are in the default encoding
+	if p.encoding != (encoding{}) && typ.Kind() != reflect.Struct {
+		p.setCodec(typ)
+		return
+	}
+
 	switch typ.Kind() {
 	case reflect.Bool: // bool
 		p.enc = (*Buffer).enc_bool
@@ -127,23 +173,33 @@ func (p *Properties) setEncAndDec(typ reflect.Type, f *reflect.StructField, fixe
 		p.siz = (*Buffer).size_string
 		*fixedSize += 2
 	case reflect.Ptr: // struct ptr
-		if t2 := typ.Elem(); t2.Kind() == reflect.Struct {
+		if typ.Implements(marshalerType) && typ.Elem().Kind() == reflect.Struct {
+			p.stype = typ.Elem()
+			p.enc = (*Buffer).enc_marshaler_ptr
+			p.dec = (*Buffer).dec_marshaler_ptr
+			p.siz = (*Buffer).size_marshaler_ptr
+		} else if t2 := typ.Elem(); t2.Kind() == reflect.Struct {
 			p.stype = t2
 			p.sprop = getPropertiesLocked(p.stype)
 			p.enc = (*Buffer).enc_substruct_ptr
 			p.dec = (*Buffer).dec_substruct_ptr
 			p.siz = (*Buffer).size_substruct_ptr
-		} else {
-			panic("cstruct: unknow type. field name =" + f.Name)
+		} else { // *int32 *string ...
+			p.setCodec(typ)
 		}
 	case reflect.Struct: // struct
+		if reflect.PtrTo(typ).Implements(marshalerType) {
+			p.enc = (*Buffer).enc_marshaler
+			p.dec = (*Buffer).dec_marshaler
+			p.siz = (*Buffer).size_marshaler
+			break
+		}
 		p.stype = typ
 		p.sprop = getPropertiesLocked(p.stype)
 		p.enc = (*Buffer).enc_substruct
 		p.dec = (*Buffer).dec_substruct
 		p.siz = (*Buffer).size_substruct
 	case reflect.Slice:
-		*fixedSize += 2
 		switch t2 := typ.Elem(); t2.Kind() {
 		case reflect.Uint8, reflect.Int8: // []byte []uint8 []int8
 			p.enc = (*Buffer).enc_slice_byte
@@ -193,7 +249,7 @@ func (p *Properties) setEncAndDec(typ reflect.Type, f *reflect.StructField, fixe
 					p.siz = (*Buffer).size_slice_substruct_ptr_ignore_nil
 				}
 			default:
-				panic("cstruct: unknow type. field name = " + f.Name)
+				p.setCodec(typ)
 			}
 		case reflect.Slice:
 			switch t2.Elem().Kind() {
@@ -210,10 +266,13 @@ func (p *Properties) setEncAndDec(typ reflect.Type, f *reflect.StructField, fixe
 				p.dec = (*Buffer).dec_slice_slice_uint32
 				p.siz = (*Buffer).size_slice_slice_uint32
 			default:
-				panic("cstruct: unknow type. field name = " + f.Name)
+				p.setCodec(typ)
 			}
 		default:
-			panic("cstruct: unknow type. field name = " + f.Name)
+			p.setCodec(typ)
+		}
+		if p.codec == nil {
+			*fixedSize += 2
 		}
 	case reflect.Array:
 		switch t2 := typ.Elem(); t2.Kind() {
@@ -251,12 +310,21 @@ func (p *Properties) setEncAndDec(typ reflect.Type, f *reflect.StructField, fixe
 				p.dec = (*Buffer).dec_array_substruct_ptr
 				p.siz = (*Buffer).size_array_substruct_ptr
 			default:
-				panic("cstruct: unknow type. field name = " + f.Name)
+				p.setCodec(typ)
 			}
 		default:
-			panic("cstruct: unknow type. field name = " + f.Name)
+			p.setCodec(typ)
 		}
 	default:
-		panic("cstruct: unknow type. field name = " + f.Name)
+		p.setCodec(typ)
 	}
 }
+
+// setCodec encodes the field by reflection, for the types without a fast
+// path, it panics if the type is not supported
+func (p *Properties) setCodec(typ reflect.Type) {
+	p.codec = newCodec(typ, p.Name, p.encoding)
+	p.enc = (*Buffer).enc_value
+	p.dec = (*Buffer).dec_value
+	p.siz = (*Buffer).size_value
+}
@@ -0,0 +1,270 @@
+package schema
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Change is a difference between a baseline schema and the current one
+type Change struct {
+	// the message or the struct field, e.g. "[1,2] Move" or "Move.Pos"
+	Path string
+	Msg  string
+	// the current schema can not read the data of the baseline or the
+	// clients of the baseline can not read the data of the current schema
+	Breaking bool
+}
+
+func (c Change) String() string {
+	if c.Breaking {
+		return c.Path + ": " + c.Msg + " (breaking)"
+	}
+	return c.Path + ": " + c.Msg
+}
+
+// Breaking returns the breaking changes
+func Breaking(changes []Change) []Change {
+	var r []Change
+	for _, c := range changes {
+		if c.Breaking {
+			r = append(r, c)
+		}
+	}
+	return r
+}
+
+// Compare compares the schema s with the baseline base. The fields of a
+// struct must keep their order, types and ver tags, the new fields are
+// appended with a ver tag greater than the ones of the baseline.
+func Compare(base *Schema, s *Schema) []Change {
+	c := &comparer{base: base, s: s, done: make(map[[2]string]bool)}
+
+	msgs := make(map[[2]uint16]Message)
+	for _, m := range s.Messages {
+		msgs[[2]uint16{m.Main, m.Sub}] = m
+	}
+	for _, m := range base.Messages {
+		path := fmt.Sprintf("[%v,%v] %v", m.Main, m.Sub, m.Type)
+		m2, ok := msgs[[2]uint16{m.Main, m.Sub}]
+		if !ok {
+			c.add(path, true, "message removed")
+			continue
+		}
+		delete(msgs, [2]uint16{m.Main, m.Sub})
+		if m2.Type != m.Type {
+			c.add(path, false, "type renamed to "+m2.Type)
+		}
+		c.compareStruct(path, m.Type, m2.Type)
+	}
+	for _, m := range s.Messages {
+		if _, ok := msgs[[2]uint16{m.Main, m.Sub}]; ok {
+			c.add(fmt.Sprintf("[%v,%v] %v", m.Main, m.Sub, m.Type), false, "message added")
+		}
+	}
+	return c.changes
+}
+
+type comparer struct {
+	base    *Schema
+	s       *Schema
+	done    map[[2]string]bool
+	changes []Change
+}
+
+func (c *comparer) add(path string, breaking bool, format string, args ...interface{}) {
+	c.changes = append(c.changes, Change{Path: path, Msg: fmt.Sprintf(format, args...), Breaking: breaking})
+}
+
+func (c *comparer) compareStruct(path string, name string, name2 string) {
+	o, n := c.base.Struct(name), c.s.Struct(name2)
+	if o == nil || n == nil {
+		if o != n {
+			c.add(path, true, "struct %v or %v not found", name, name2)
+		}
+		return
+	}
+	if c.done[[2]string{name, name2}] {
+		return
+	}
+	c.done[[2]string{name, name2}] = true
+
+	index := make(map[string]int)
+	for i, f := range n.Fields {
+		index[f.Name] = i
+	}
+	oldIndex := make(map[string]int)
+	for i, f := range o.Fields {
+		oldIndex[f.Name] = i
+	}
+
+	last := -1
+	maxVer := -1
+	for i := range o.Fields {
+		fo := &o.Fields[i]
+		fpath := n.Name + "." + fo.Name
+		if fo.Ver != nil && *fo.Ver > maxVer {
+			maxVer = *fo.Ver
+		}
+		j, ok := index[fo.Name]
+		if !ok {
+			// a field of the same encoding at the same index is renamed
+			if i < len(n.Fields) {
+				fn := &n.Fields[i]
+				if _, ok := oldIndex[fn.Name]; !ok && c.sameField(fo, fn) {
+					c.add(fpath, false, "renamed to %v", fn.Name)
+					last = i
+					continue
+				}
+			}
+			c.add(fpath, true, "field removed")
+			continue
+		}
+		fn := &n.Fields[j]
+		if j < last {
+			c.add(fpath, true, "field reordered")
+		} else {
+			last = j
+		}
+		if fo.Version != fn.Version {
+			if fn.Version {
+				c.add(fpath, true, "became the Ver field")
+			} else {
+				c.add(fpath, true, "is not the Ver field")
+			}
+		}
+		if !sameVer(fo.Ver, fn.Ver) {
+			c.add(fpath, true, "ver tag changed from %v to %v", verString(fo.Ver), verString(fn.Ver))
+		}
+		if !c.sameField(fo, fn) {
+			c.add(fpath, true, "type changed from %v to %v", fieldString(fo), fieldString(fn))
+		}
+	}
+
+	hasVer := false
+	for i := range n.Fields {
+		fn := &n.Fields[i]
+		if fn.Version {
+			hasVer = true
+		}
+		if _, ok := oldIndex[fn.Name]; ok {
+			continue
+		}
+		fpath := n.Name + "." + fn.Name
+		switch {
+		case i < len(o.Fields):
+			if _, ok := index[o.Fields[i].Name]; !ok && c.sameField(&o.Fields[i], fn) {
+				// renamed
+				continue
+			}
+			c.add(fpath, true, "field inserted before the end")
+		case fn.Ver == nil || !hasVer:
+			c.add(fpath, true, "field added without a ver tag")
+		case *fn.Ver <= maxVer:
+			c.add(fpath, true, "ver tag %v of the added field is not greater than %v", *fn.Ver, maxVer)
+		default:
+			c.add(fpath, false, "field added at ver %v", *fn.Ver)
+		}
+	}
+}
+
+// sameField reports whether the fields are encoded in the same way, the
+// structs of the fields are compared too
+func (c *comparer) sameField(fo *Field, fn *Field) bool {
+	if hasLen(fo.Type) && fo.Len != fn.Len {
+		return false
+	}
+	if hasVarint(fo.Type) && fo.Varint != fn.Varint {
+		return false
+	}
+	return fo.IgnoreNil == fn.IgnoreNil && c.sameType(fo.Type, fn.Type)
+}
+
+func (c *comparer) sameType(t *Type, t2 *Type) bool {
+	if t == nil || t2 == nil {
+		return t == t2
+	}
+	if t.Kind != t2.Kind || t.Len != t2.Len {
+		return false
+	}
+	switch t.Kind {
+	case KindStruct:
+		c.compareStruct(t.Name, t.Name, t2.Name)
+		return true
+	case KindPtr, KindSlice, KindArray:
+		return c.sameType(t.Elem, t2.Elem)
+	case KindMap:
+		return c.sameType(t.Key, t2.Key) && c.sameType(t.Elem, t2.Elem)
+	}
+	return true
+}
+
+// hasLen reports whether the length prefix is used by t
+func hasLen(t *Type) bool {
+	if t == nil {
+		return false
+	}
+	switch t.Kind {
+	case KindString, KindSlice, KindMap:
+		return true
+	}
+	return hasLen(t.Elem) || hasLen(t.Key)
+}
+
+// hasVarint reports whether the varint flag is used by t
+func hasVarint(t *Type) bool {
+	if t == nil {
+		return false
+	}
+	switch t.Kind {
+	case KindInt16, KindInt32, KindInt64, KindUint16, KindUint32, KindUint64:
+		return true
+	}
+	return hasVarint(t.Elem) || hasVarint(t.Key)
+}
+
+func sameVer(v *int, v2 *int) bool {
+	if v == nil || v2 == nil {
+		return v == v2
+	}
+	return *v == *v2
+}
+
+func verString(v *int) string {
+	if v == nil {
+		return "none"
+	}
+	return strconv.Itoa(*v)
+}
+
+func fieldString(f *Field) string {
+	s := typeString(f.Type)
+	if hasLen(f.Type) {
+		s += " " + f.Len
+	}
+	if f.Varint && hasVarint(f.Type) {
+		s += " varint"
+	}
+	if f.IgnoreNil {
+		s += " ignorenil"
+	}
+	return s
+}
+
+func typeString(t *Type) string {
+	if t == nil {
+		return "?"
+	}
+	switch t.Kind {
+	case KindStruct:
+		return t.Name
+	case KindPtr:
+		return "*" + typeString(t.Elem)
+	case KindSlice:
+		return "[]" + typeString(t.Elem)
+	case KindArray:
+		return "[" + strconv.Itoa(t.Len) + "]" + typeString(t.Elem)
+	case KindMap:
+		return "map[" + typeString(t.Key) + "]" + typeString(t.Elem)
+	}
+	return t.Kind
+}
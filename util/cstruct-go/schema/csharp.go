@@ -0,0 +1,735 @@
+package schema
+
+import (
+	"fmt"
+	"strings"
+)
+
+const csRuntime = `public enum LenMode { Len16, Len32, LenVarint }
+
+public class CStructException : Exception
+{
+	public CStructException(string message) : base(message) { }
+}
+
+public interface ICStruct
+{
+	void Encode(CStructWriter w);
+	void Decode(CStructReader r);
+}
+
+public static class CStruct
+{
+	public static byte[] Marshal(ICStruct m)
+	{
+		var w = new CStructWriter();
+		m.Encode(w);
+		return w.ToArray();
+	}
+
+	public static T Unmarshal<T>(byte[] data) where T : ICStruct, new()
+	{
+		var m = new T();
+		m.Decode(new CStructReader(data));
+		return m;
+	}
+
+	// the message id of the main and sub command id
+	public static uint CmdId(ushort main, ushort sub)
+	{
+		return main | (uint)sub << 16;
+	}
+
+	// the order of the map keys of cstruct-go, the code points, which is
+	// not the order of the UTF-16 code units of string.CompareOrdinal
+	internal static int CompareCodePoints(string a, string b)
+	{
+		var n = Math.Min(a.Length, b.Length);
+		for (var i = 0; i < n; i++)
+		{
+			if (a[i] != b[i])
+			{
+				return CodePointOrder(a[i]) - CodePointOrder(b[i]);
+			}
+		}
+		return a.Length - b.Length;
+	}
+
+	// the surrogates are after U+E000-U+FFFF
+	static int CodePointOrder(char c)
+	{
+		if (c >= 0xE000)
+		{
+			return c - 0x800;
+		}
+		if (c >= 0xD800)
+		{
+			return c + 0x2000;
+		}
+		return c;
+	}
+
+	internal static T[] NewArray<T>(int n)
+	{
+		return new T[n];
+	}
+
+	internal static T[] NewArray<T>(int n, Func<T> zero)
+	{
+		var a = new T[n];
+		for (var i = 0; i < n; i++)
+		{
+			a[i] = zero();
+		}
+		return a;
+	}
+}
+
+public sealed class CStructWriter
+{
+	byte[] buf;
+	int pos;
+
+	public CStructWriter(int capacity = 256)
+	{
+		buf = new byte[capacity];
+	}
+
+	// the encoded bytes
+	public byte[] ToArray()
+	{
+		var b = new byte[pos];
+		Buffer.BlockCopy(buf, 0, b, 0, pos);
+		return b;
+	}
+
+	int Grow(int n)
+	{
+		if (pos + n > buf.Length)
+		{
+			Array.Resize(ref buf, Math.Max(buf.Length * 2, pos + n));
+		}
+		var p = pos;
+		pos += n;
+		return p;
+	}
+
+	void WriteFixed(ulong v, int size)
+	{
+		var p = Grow(size);
+		for (var i = 0; i < size; i++)
+		{
+			buf[p + i] = (byte)(v >> (8 * i));
+		}
+	}
+
+	void WriteUvarint(ulong v)
+	{
+		while (v >= 0x80)
+		{
+			buf[Grow(1)] = (byte)(v | 0x80);
+			v >>= 7;
+		}
+		buf[Grow(1)] = (byte)v;
+	}
+
+	public void WriteBool(bool v)
+	{
+		buf[Grow(1)] = (byte)(v ? 1 : 0);
+	}
+
+	public void WriteFloat32(float v)
+	{
+		WriteFixed((uint)BitConverter.SingleToInt32Bits(v), 4);
+	}
+
+	public void WriteFloat64(double v)
+	{
+		WriteFixed((ulong)BitConverter.DoubleToInt64Bits(v), 8);
+	}
+
+	// an integer of size 1, 2, 4 or 8 bytes
+	public void WriteInt(long v, int size, bool varint)
+	{
+		if (size < 8 && (v < -(1L << (size * 8 - 1)) || v >= 1L << (size * 8 - 1)))
+		{
+			throw new CStructException("cstruct: integer overflow");
+		}
+		if (varint && size > 1)
+		{
+			WriteUvarint((ulong)((v << 1) ^ (v >> 63)));
+			return;
+		}
+		WriteFixed((ulong)v, size);
+	}
+
+	public void WriteUInt(ulong v, int size, bool varint)
+	{
+		if (size < 8 && v >= 1UL << (size * 8))
+		{
+			throw new CStructException("cstruct: integer overflow");
+		}
+		if (varint && size > 1)
+		{
+			WriteUvarint(v);
+			return;
+		}
+		WriteFixed(v, size);
+	}
+
+	public void WriteLen(int n, LenMode mode)
+	{
+		switch (mode)
+		{
+			case LenMode.LenVarint:
+				WriteUvarint((ulong)n);
+				break;
+			case LenMode.Len32:
+				WriteFixed((ulong)n, 4);
+				break;
+			default:
+				if (n > ushort.MaxValue)
+				{
+					throw new CStructException("cstruct: length overflows the length prefix");
+				}
+				WriteFixed((ulong)n, 2);
+				break;
+		}
+	}
+
+	public void WriteString(string s, LenMode mode)
+	{
+		WriteBytes(Encoding.UTF8.GetBytes(s ?? ""), mode);
+	}
+
+	public void WriteBytes(byte[] b, LenMode mode)
+	{
+		var n = b == null ? 0 : b.Length;
+		WriteLen(n, mode);
+		var p = Grow(n);
+		if (n > 0)
+		{
+			Buffer.BlockCopy(b, 0, buf, p, n);
+		}
+	}
+}
+
+public sealed class CStructReader
+{
+	readonly byte[] buf;
+	int pos;
+
+	public CStructReader(byte[] data)
+	{
+		buf = data;
+	}
+
+	int Next(int n)
+	{
+		if (n < 0 || n > buf.Length - pos)
+		{
+			throw new CStructException("cstruct: unexpected EOF");
+		}
+		var p = pos;
+		pos += n;
+		return p;
+	}
+
+	ulong ReadFixed(int size)
+	{
+		var p = Next(size);
+		ulong v = 0;
+		for (var i = 0; i < size; i++)
+		{
+			v |= (ulong)buf[p + i] << (8 * i);
+		}
+		return v;
+	}
+
+	ulong ReadUvarint()
+	{
+		ulong v = 0;
+		for (var shift = 0; shift < 64; shift += 7)
+		{
+			var b = buf[Next(1)];
+			v |= (ulong)(b & 0x7f) << shift;
+			if (b < 0x80)
+			{
+				if (shift == 63 && b > 1)
+				{
+					break;
+				}
+				return v;
+			}
+		}
+		throw new CStructException("cstruct: integer overflow");
+	}
+
+	public bool ReadBool()
+	{
+		return buf[Next(1)] != 0;
+	}
+
+	public float ReadFloat32()
+	{
+		return BitConverter.Int32BitsToSingle((int)ReadFixed(4));
+	}
+
+	public double ReadFloat64()
+	{
+		return BitConverter.Int64BitsToDouble((long)ReadFixed(8));
+	}
+
+	public long ReadInt(int size, bool varint)
+	{
+		if (varint && size > 1)
+		{
+			var u = ReadUvarint();
+			var v = (long)(u >> 1) ^ -(long)(u & 1);
+			if (size < 8 && (v < -(1L << (size * 8 - 1)) || v >= 1L << (size * 8 - 1)))
+			{
+				throw new CStructException("cstruct: integer overflow");
+			}
+			return v;
+		}
+		var x = ReadFixed(size);
+		var shift = 64 - size * 8;
+		return (long)(x << shift) >> shift;
+	}
+
+	public ulong ReadUInt(int size, bool varint)
+	{
+		if (varint && size > 1)
+		{
+			var v = ReadUvarint();
+			if (size < 8 && v >= 1UL << (size * 8))
+			{
+				throw new CStructException("cstruct: integer overflow");
+			}
+			return v;
+		}
+		return ReadFixed(size);
+	}
+
+	// a length, which is not more than the bytes left
+	public int ReadLen(LenMode mode)
+	{
+		ulong n;
+		switch (mode)
+		{
+			case LenMode.LenVarint:
+				n = ReadUvarint();
+				break;
+			case LenMode.Len32:
+				n = ReadFixed(4);
+				break;
+			default:
+				n = ReadFixed(2);
+				break;
+		}
+		if (n > (ulong)(buf.Length - pos))
+		{
+			throw new CStructException("cstruct: unexpected EOF");
+		}
+		return (int)n;
+	}
+
+	public string ReadString(LenMode mode)
+	{
+		var n = ReadLen(mode);
+		return Encoding.UTF8.GetString(buf, Next(n), n);
+	}
+
+	public byte[] ReadBytes(LenMode mode)
+	{
+		var n = ReadLen(mode);
+		var b = new byte[n];
+		Buffer.BlockCopy(buf, Next(n), b, 0, n);
+		return b;
+	}
+}
+`
+
+var csLenModes = []string{"LenMode.Len16", "LenMode.Len32", "LenMode.LenVarint"}
+
+// CSharp generates a C# file of the structs of s in namespace, a class
+// implementing ICStruct for each struct, and the runtime.
+func CSharp(s *Schema, namespace string) ([]byte, error) {
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	for _, st := range s.Structs {
+		for _, f := range st.Fields {
+			for t := f.Type; t != nil; t = t.Elem {
+				if t.Kind == KindPtr && t.Elem.Kind == KindPtr {
+					return nil, fmt.Errorf("%v.%v: pointer to pointer", st.Name, f.Name)
+				}
+			}
+		}
+	}
+
+	g := new(printer)
+	g.p("// Code generated by cstructschema. DO NOT EDIT.")
+	g.p("")
+	g.p("#nullable disable")
+	g.p("")
+	g.p("using System;")
+	g.p("using System.Collections.Generic;")
+	g.p("using System.Text;")
+	g.p("")
+	if namespace != "" {
+		g.p("namespace %v", namespace)
+		g.p("{")
+	}
+	for _, line := range strings.SplitAfter(csRuntime, "\n") {
+		if namespace != "" && line != "\n" && line != "" {
+			g.b.WriteByte('\t')
+		}
+		g.b.WriteString(line)
+	}
+	for i := range s.Structs {
+		g.p("")
+		csStruct(g, &s.Structs[i])
+	}
+	if len(s.Messages) > 0 {
+		g.p("")
+		g.p("public static class Messages")
+		g.p("{")
+		g.p("public static readonly Dictionary<uint, Type> Types = new Dictionary<uint, Type>")
+		g.p("{")
+		for _, m := range s.Messages {
+			g.p("{ CStruct.CmdId(%v, %v), typeof(%v) },", m.Main, m.Sub, m.Type)
+		}
+		g.p("};")
+		g.p("}")
+	}
+	if namespace != "" {
+		g.p("}")
+	}
+	return g.b.Bytes(), nil
+}
+
+func csType(t *Type) string {
+	switch t.Kind {
+	case KindBool:
+		return "bool"
+	case KindInt8:
+		return "sbyte"
+	case KindInt16:
+		return "short"
+	case KindInt32:
+		return "int"
+	case KindInt64:
+		return "long"
+	case KindUint8:
+		return "byte"
+	case KindUint16:
+		return "ushort"
+	case KindUint32:
+		return "uint"
+	case KindUint64:
+		return "ulong"
+	case KindFloat32:
+		return "float"
+	case KindFloat64:
+		return "double"
+	case KindString:
+		return "string"
+	case KindStruct:
+		return t.Name
+	case KindPtr:
+		if csValueType(t.Elem) {
+			return csType(t.Elem) + "?"
+		}
+		return csType(t.Elem)
+	case KindSlice:
+		if isBytes(t) {
+			return "byte[]"
+		}
+		return "List<" + csType(t.Elem) + ">"
+	case KindArray:
+		return csType(t.Elem) + "[]"
+	case KindMap:
+		return "Dictionary<" + csType(t.Key) + ", " + csType(t.Elem) + ">"
+	}
+	return "object"
+}
+
+func csValueType(t *Type) bool {
+	switch t.Kind {
+	case KindString, KindStruct, KindPtr, KindSlice, KindArray, KindMap:
+		return false
+	}
+	return true
+}
+
+func csZero(t *Type) string {
+	switch t.Kind {
+	case KindString:
+		return `""`
+	case KindStruct:
+		return "new " + t.Name + "()"
+	case KindPtr:
+		return "null"
+	case KindSlice:
+		if isBytes(t) {
+			return "new byte[0]"
+		}
+		return "new " + csType(t) + "()"
+	case KindArray:
+		if z := csZero(t.Elem); z != "" && z != "null" {
+			return fmt.Sprintf("CStruct.NewArray<%v>(%v, () => %v)", csType(t.Elem), t.Len, z)
+		}
+		return fmt.Sprintf("CStruct.NewArray<%v>(%v)", csType(t.Elem), t.Len)
+	case KindMap:
+		return "new " + csType(t) + "()"
+	}
+	return ""
+}
+
+func csStruct(g *printer, s *Struct) {
+	g.tmp = 0
+	g.p("public partial class %v : ICStruct", s.Name)
+	g.p("{")
+	for _, f := range s.Fields {
+		if z := csZero(f.Type); z != "" && z != "null" {
+			g.p("public %v %v = %v;", csType(f.Type), f.Name, z)
+		} else {
+			g.p("public %v %v;", csType(f.Type), f.Name)
+		}
+	}
+
+	ver := versioned(s)
+	g.p("")
+	g.p("public void Encode(CStructWriter w)")
+	g.p("{")
+	if ver {
+		g.p("long ver = -2;")
+	}
+	for i := range s.Fields {
+		f := &s.Fields[i]
+		if f.Ver != nil {
+			g.p("if (%v > ver)", *f.Ver)
+			g.p("{")
+			g.p(`throw new CStructException("cstruct: field %v ver %v > Ver " + ver);`, f.Name, *f.Ver)
+			g.p("}")
+		}
+		csEnc(g, f.Name, f.Type, f, true)
+		if f.Version && ver {
+			g.p("ver = (long)%v;", f.Name)
+		}
+	}
+	g.p("}")
+
+	g.p("")
+	g.p("public void Decode(CStructReader r)")
+	g.p("{")
+	if ver {
+		g.p("long ver = -2;")
+	}
+	for i := range s.Fields {
+		f := &s.Fields[i]
+		if f.Ver != nil {
+			g.p("if (%v <= ver)", *f.Ver)
+			g.p("{")
+		}
+		csDec(g, f.Name, f.Type, f, true)
+		if f.Ver != nil {
+			g.p("}")
+		}
+		if f.Version && ver {
+			g.p("ver = (long)%v;", f.Name)
+		}
+	}
+	g.p("}")
+	g.p("}")
+}
+
+func csEnc(g *printer, x string, t *Type, f *Field, top bool) {
+	varint := "false"
+	if f.Varint {
+		varint = "true"
+	}
+	mode := csLenModes[lenMode(f)]
+	switch t.Kind {
+	case KindBool:
+		g.p("w.WriteBool(%v);", x)
+	case KindInt8, KindInt16, KindInt32, KindInt64:
+		g.p("w.WriteInt(%v, %v, %v);", x, intSize(t.Kind), varint)
+	case KindUint8, KindUint16, KindUint32, KindUint64:
+		g.p("w.WriteUInt(%v, %v, %v);", x, intSize(t.Kind), varint)
+	case KindFloat32:
+		g.p("w.WriteFloat32(%v);", x)
+	case KindFloat64:
+		g.p("w.WriteFloat64(%v);", x)
+	case KindString:
+		g.p("w.WriteString(%v, %v);", x, mode)
+	case KindStruct:
+		g.p("%v.Encode(w);", x)
+	case KindPtr:
+		v := g.v("v")
+		g.p("var %v = %v;", v, x)
+		g.p("w.WriteBool(%v != null);", v)
+		g.p("if (%v != null)", v)
+		g.p("{")
+		if csValueType(t.Elem) {
+			csEnc(g, v+".Value", t.Elem, f, false)
+		} else {
+			csEnc(g, v, t.Elem, f, false)
+		}
+		g.p("}")
+	case KindSlice:
+		if isBytes(t) {
+			g.p("w.WriteBytes(%v, %v);", x, mode)
+			return
+		}
+		s, n, e := g.v("s"), g.v("n"), g.v("e")
+		g.p("var %v = %v;", s, x)
+		if top && f.IgnoreNil {
+			g.p("var %v = 0;", n)
+			g.p("if (%v != null)", s)
+			g.p("{")
+			g.p("foreach (var %v in %v)", e, s)
+			g.p("{")
+			g.p("if (%v != null)", e)
+			g.p("{")
+			g.p("%v++;", n)
+			g.p("}")
+			g.p("}")
+			g.p("}")
+			g.p("w.WriteLen(%v, %v);", n, mode)
+			g.p("if (%v != null)", s)
+			g.p("{")
+			g.p("foreach (var %v in %v)", e, s)
+			g.p("{")
+			g.p("%v?.Encode(w);", e)
+			g.p("}")
+			g.p("}")
+			return
+		}
+		g.p("w.WriteLen(%v == null ? 0 : %v.Count, %v);", s, s, mode)
+		g.p("if (%v != null)", s)
+		g.p("{")
+		g.p("foreach (var %v in %v)", e, s)
+		g.p("{")
+		csEnc(g, e, t.Elem, f, false)
+		g.p("}")
+		g.p("}")
+	case KindArray:
+		a, e := g.v("a"), g.v("e")
+		g.p("var %v = %v;", a, x)
+		g.p("if (%v == null || %v.Length != %v)", a, a, t.Len)
+		g.p("{")
+		g.p(`throw new CStructException("cstruct: %v length != %v");`, x, t.Len)
+		g.p("}")
+		g.p("foreach (var %v in %v)", e, a)
+		g.p("{")
+		csEnc(g, e, t.Elem, f, false)
+		g.p("}")
+	case KindMap:
+		m, k := g.v("m"), g.v("k")
+		g.p("var %v = %v;", m, x)
+		g.p("w.WriteLen(%v == null ? 0 : %v.Count, %v);", m, m, mode)
+		g.p("if (%v != null)", m)
+		g.p("{")
+		g.p("var %v = new List<%v>(%v.Keys);", k, csType(t.Key), m)
+		if t.Key.Kind == KindString {
+			g.p("%v.Sort(CStruct.CompareCodePoints);", k)
+		} else {
+			g.p("%v.Sort();", k)
+		}
+		e := g.v("e")
+		g.p("foreach (var %v in %v)", e, k)
+		g.p("{")
+		csEnc(g, e, t.Key, f, false)
+		csEnc(g, m+"["+e+"]", t.Elem, f, false)
+		g.p("}")
+		g.p("}")
+	}
+}
+
+// csDec decodes into x
+func csDec(g *printer, x string, t *Type, f *Field, top bool) {
+	varint := "false"
+	if f.Varint {
+		varint = "true"
+	}
+	mode := csLenModes[lenMode(f)]
+	switch t.Kind {
+	case KindBool:
+		g.p("%v = r.ReadBool();", x)
+	case KindInt8, KindInt16, KindInt32, KindInt64:
+		g.p("%v = (%v)r.ReadInt(%v, %v);", x, csType(t), intSize(t.Kind), varint)
+	case KindUint8, KindUint16, KindUint32, KindUint64:
+		g.p("%v = (%v)r.ReadUInt(%v, %v);", x, csType(t), intSize(t.Kind), varint)
+	case KindFloat32:
+		g.p("%v = r.ReadFloat32();", x)
+	case KindFloat64:
+		g.p("%v = r.ReadFloat64();", x)
+	case KindString:
+		g.p("%v = r.ReadString(%v);", x, mode)
+	case KindStruct:
+		v := g.v("v")
+		g.p("var %v = new %v();", v, t.Name)
+		g.p("%v.Decode(r);", v)
+		g.p("%v = %v;", x, v)
+	case KindPtr:
+		g.p("if (r.ReadBool())")
+		g.p("{")
+		if csValueType(t.Elem) {
+			v := g.v("v")
+			g.p("%v %v;", csType(t.Elem), v)
+			csDec(g, v, t.Elem, f, false)
+			g.p("%v = %v;", x, v)
+		} else {
+			csDec(g, x, t.Elem, f, false)
+		}
+		g.p("}")
+		g.p("else")
+		g.p("{")
+		g.p("%v = null;", x)
+		g.p("}")
+	case KindSlice:
+		if isBytes(t) {
+			g.p("%v = r.ReadBytes(%v);", x, mode)
+			return
+		}
+		n, a, i := g.v("n"), g.v("a"), g.v("i")
+		g.p("var %v = r.ReadLen(%v);", n, mode)
+		g.p("var %v = new %v(%v);", a, csType(t), n)
+		g.p("for (var %v = 0; %v < %v; %v++)", i, i, n, i)
+		g.p("{")
+		e := g.v("e")
+		g.p("%v %v;", csType(t.Elem), e)
+		if top && f.IgnoreNil {
+			g.p("%v = new %v();", e, t.Elem.Elem.Name)
+			g.p("%v.Decode(r);", e)
+		} else {
+			csDec(g, e, t.Elem, f, false)
+		}
+		g.p("%v.Add(%v);", a, e)
+		g.p("}")
+		g.p("%v = %v;", x, a)
+	case KindArray:
+		a, i := g.v("a"), g.v("i")
+		g.p("var %v = CStruct.NewArray<%v>(%v);", a, csType(t.Elem), t.Len)
+		g.p("for (var %v = 0; %v < %v; %v++)", i, i, t.Len, i)
+		g.p("{")
+		csDec(g, a+"["+i+"]", t.Elem, f, false)
+		g.p("}")
+		g.p("%v = %v;", x, a)
+	case KindMap:
+		n, m, i, k, v := g.v("n"), g.v("m"), g.v("i"), g.v("k"), g.v("v")
+		g.p("var %v = r.ReadLen(%v);", n, mode)
+		g.p("var %v = new %v(%v);", m, csType(t), n)
+		g.p("for (var %v = 0; %v < %v; %v++)", i, i, n, i)
+		g.p("{")
+		g.p("%v %v;", csType(t.Key), k)
+		csDec(g, k, t.Key, f, false)
+		g.p("%v %v;", csType(t.Elem), v)
+		csDec(g, v, t.Elem, f, false)
+		g.p("%v[%v] = %v;", m, k, v)
+		g.p("}")
+		g.p("%v = %v;", x, m)
+	}
+}
@@ -0,0 +1,158 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/CreFire/leaf/util/cstruct-go"
+)
+
+// Object is a decoded struct or map, which keeps the order of the fields
+type Object []Member
+
+type Member struct {
+	Name  string
+	Value interface{}
+}
+
+func (o Object) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, m := range o {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, _ := json.Marshal(m.Name)
+		b.Write(name)
+		b.WriteByte(':')
+		v, err := json.Marshal(m.Value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// Decode decodes the struct of name from b without the Go types, e.g. to
+// dump the frames in the tools. On error the fields decoded are returned,
+// and b.Index() is the offset where the decoding stopped.
+func (s *Schema) Decode(name string, b *cstruct.Buffer) (Object, error) {
+	st := s.Struct(name)
+	if st == nil {
+		return nil, fmt.Errorf("struct %v not found", name)
+	}
+	o := make(Object, 0, len(st.Fields))
+	ver := -2
+	for i := range st.Fields {
+		f := &st.Fields[i]
+		if f.Ver != nil && *f.Ver > ver {
+			continue
+		}
+		v, err := s.decode(b, f.Type, f, true)
+		if v != nil || err == nil {
+			o = append(o, Member{f.Name, v})
+		}
+		if err != nil {
+			return o, fmt.Errorf("%v.%v: %w", name, f.Name, err)
+		}
+		if f.Version && ver == -2 {
+			switch n := v.(type) {
+			case int64:
+				ver = int(n)
+			case uint64:
+				ver = int(n)
+			}
+		}
+	}
+	return o, nil
+}
+
+func (s *Schema) decode(b *cstruct.Buffer, t *Type, f *Field, top bool) (interface{}, error) {
+	lm := cstruct.LenMode(lenMode(f))
+	switch t.Kind {
+	case KindBool:
+		return value(b.ReadBool())
+	case KindInt8, KindInt16, KindInt32, KindInt64:
+		return value(b.ReadInt(intSize(t.Kind), f.Varint))
+	case KindUint8, KindUint16, KindUint32, KindUint64:
+		return value(b.ReadUint(intSize(t.Kind), f.Varint))
+	case KindFloat32:
+		return value(b.ReadFloat32())
+	case KindFloat64:
+		return value(b.ReadFloat64())
+	case KindString:
+		return value(b.ReadString(lm))
+	case KindStruct:
+		o, err := s.Decode(t.Name, b)
+		if o == nil {
+			return nil, err
+		}
+		return o, err
+	case KindPtr:
+		ok, err := b.ReadBool()
+		if err != nil || !ok {
+			return nil, err
+		}
+		return s.decode(b, t.Elem, f, false)
+	case KindSlice, KindArray:
+		if t.Kind == KindSlice && isBytes(t) {
+			return value(b.ReadBytes(lm))
+		}
+		n := t.Len
+		if t.Kind == KindSlice {
+			var err error
+			if n, err = b.ReadLen(lm); err != nil {
+				return nil, err
+			}
+		}
+		a := make([]interface{}, 0)
+		for i := 0; i < n; i++ {
+			var v interface{}
+			var err error
+			if top && f.IgnoreNil {
+				v, err = s.decode(b, t.Elem.Elem, f, false)
+			} else {
+				v, err = s.decode(b, t.Elem, f, false)
+			}
+			if v != nil || err == nil {
+				a = append(a, v)
+			}
+			if err != nil {
+				return a, fmt.Errorf("[%v]: %w", i, err)
+			}
+		}
+		return a, nil
+	case KindMap:
+		n, err := b.ReadLen(lm)
+		if err != nil {
+			return nil, err
+		}
+		m := make(Object, 0)
+		for i := 0; i < n; i++ {
+			k, err := s.decode(b, t.Key, f, false)
+			if err != nil {
+				return m, fmt.Errorf("key %v: %w", i, err)
+			}
+			v, err := s.decode(b, t.Elem, f, false)
+			if v != nil || err == nil {
+				m = append(m, Member{fmt.Sprint(k), v})
+			}
+			if err != nil {
+				return m, fmt.Errorf("[%v]: %w", k, err)
+			}
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("unknown kind %v", t.Kind)
+}
+
+// value drops the zero value on error
+func value(v interface{}, err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
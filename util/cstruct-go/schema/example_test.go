@@ -0,0 +1,101 @@
+package schema_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/CreFire/leaf/util/cstruct-go/schema"
+)
+
+type Item struct {
+	ID    uint32
+	Count uint16 `cstruct:"varint"`
+}
+
+type Bag struct {
+	Ver   uint8
+	Items []*Item
+	Owner string `ver:"1"`
+}
+
+func Example() {
+	s := schema.New()
+	if err := s.Add(1, 2, reflect.TypeOf(&Bag{})); err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, st := range s.Structs {
+		data, _ := json.Marshal(st)
+		fmt.Println(string(data))
+	}
+
+	// Output:
+	// {"name":"Bag","fields":[{"name":"Ver","type":{"kind":"uint8"},"version":true,"len":"len16"},{"name":"Items","type":{"kind":"slice","elem":{"kind":"ptr","elem":{"kind":"struct","name":"Item"}}},"len":"len16"},{"name":"Owner","type":{"kind":"string"},"ver":1,"len":"len16"}]}
+	// {"name":"Item","fields":[{"name":"ID","type":{"kind":"uint32"},"len":"len16"},{"name":"Count","type":{"kind":"uint16"},"len":"len16","varint":true}]}
+}
+
+func ExampleTypeScript() {
+	s := schema.New()
+	s.Add(1, 3, reflect.TypeOf(&Item{}))
+	src, err := schema.TypeScript(s)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	// the runtime is before the classes
+	fmt.Print(string(src[bytes.Index(src, []byte("export class Item")):]))
+
+	// Output:
+	// export class Item {
+	// 	ID: number = 0;
+	// 	Count: number = 0;
+	//
+	// 	encode(w: Writer): void {
+	// 		w.writeUint(this.ID, 4, false);
+	// 		w.writeUint(this.Count, 2, true);
+	// 	}
+	//
+	// 	decode(r: Reader): this {
+	// 		this.ID = r.readUint(4, false);
+	// 		this.Count = r.readUint(2, true);
+	// 		return this;
+	// 	}
+	// }
+	//
+	// export const Messages = [
+	// 	{ main: 1, sub: 3, type: Item },
+	// ];
+}
+
+func ExampleCompare() {
+	type Item2 struct {
+		ID    uint32
+		Count uint32 `cstruct:"varint"`
+	}
+	type Bag2 struct {
+		Ver   uint8
+		Gold  int32
+		Items []*Item2
+		Owner string `ver:"1"`
+		Level int16  `ver:"2"`
+		Title string
+	}
+
+	base, s := schema.New(), schema.New()
+	base.Add(1, 2, reflect.TypeOf(&Bag{}))
+	base.Add(1, 3, reflect.TypeOf(&Item{}))
+	s.Add(1, 2, reflect.TypeOf(&Bag2{}))
+	for _, c := range schema.Compare(base, s) {
+		fmt.Println(c)
+	}
+
+	// Output:
+	// [1,2] Bag: type renamed to Bag2
+	// Item2.Count: type changed from uint16 varint to uint32 varint (breaking)
+	// Bag2.Gold: field inserted before the end (breaking)
+	// Bag2.Level: field added at ver 2
+	// Bag2.Title: field added without a ver tag (breaking)
+	// [1,3] Item: message removed (breaking)
+}
@@ -0,0 +1,125 @@
+package schema
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// printer indents the lines by the braces
+type printer struct {
+	b      bytes.Buffer
+	indent int
+	tmp    int
+}
+
+func (p *printer) p(format string, args ...interface{}) {
+	line := fmt.Sprintf(format, args...)
+	if strings.HasPrefix(line, "}") || strings.HasPrefix(line, "]") {
+		p.indent--
+	}
+	if line != "" {
+		p.b.WriteString(strings.Repeat("\t", p.indent))
+	}
+	p.b.WriteString(line)
+	p.b.WriteByte('\n')
+	if strings.HasSuffix(line, "{") || strings.HasSuffix(line, "[") {
+		p.indent++
+	}
+}
+
+func (p *printer) v(prefix string) string {
+	p.tmp++
+	return prefix + strconv.Itoa(p.tmp)
+}
+
+func intSize(kind string) int {
+	switch kind {
+	case KindInt8, KindUint8:
+		return 1
+	case KindInt16, KindUint16:
+		return 2
+	case KindInt32, KindUint32:
+		return 4
+	}
+	return 8
+}
+
+func lenMode(f *Field) int {
+	switch f.Len {
+	case Len32:
+		return 1
+	case LenVarint:
+		return 2
+	}
+	return 0
+}
+
+func isBytes(t *Type) bool {
+	return t.Kind == KindSlice && t.Elem.Kind == KindUint8
+}
+
+// versioned reports whether a field of s has a ver tag
+func versioned(s *Struct) bool {
+	for _, f := range s.Fields {
+		if f.Ver != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// check checks the names of the structs and the fields of ver tags are
+// after the Ver field
+func (s *Schema) check() error {
+	for _, st := range s.Structs {
+		hasVer := false
+		for _, f := range st.Fields {
+			if f.Version {
+				hasVer = true
+			}
+			if f.Ver != nil && !hasVer {
+				return fmt.Errorf("%v.%v: ver tag before the Ver field", st.Name, f.Name)
+			}
+			if err := s.checkType(f.Type); err != nil {
+				return fmt.Errorf("%v.%v: %v", st.Name, f.Name, err)
+			}
+		}
+	}
+	for _, m := range s.Messages {
+		if s.Struct(m.Type) == nil {
+			return fmt.Errorf("message [%v,%v]: struct %v not found", m.Main, m.Sub, m.Type)
+		}
+	}
+	return nil
+}
+
+func (s *Schema) checkType(t *Type) error {
+	if t == nil {
+		return fmt.Errorf("no type")
+	}
+	switch t.Kind {
+	case KindBool, KindInt8, KindInt16, KindInt32, KindInt64,
+		KindUint8, KindUint16, KindUint32, KindUint64,
+		KindFloat32, KindFloat64, KindString:
+		return nil
+	case KindStruct:
+		if s.Struct(t.Name) == nil {
+			return fmt.Errorf("struct %v not found", t.Name)
+		}
+		return nil
+	case KindPtr, KindSlice, KindArray:
+		return s.checkType(t.Elem)
+	case KindMap:
+		if t.Key == nil {
+			return fmt.Errorf("no map key")
+		}
+		switch t.Key.Kind {
+		case KindBool, KindStruct, KindPtr, KindSlice, KindArray, KindMap:
+			return fmt.Errorf("unsupported map key %v", t.Key.Kind)
+		}
+		return s.checkType(t.Elem)
+	}
+	return fmt.Errorf("unknown kind %v", t.Kind)
+}
@@ -0,0 +1,166 @@
+package schema_test
+
+import (
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	cstruct "github.com/CreFire/leaf/util/cstruct-go"
+	"github.com/CreFire/leaf/util/cstruct-go/schema"
+)
+
+// the map keys are sorted by the code points in cstruct-go, "！" is
+// before "\U0001F600" which is a surrogate pair in UTF-16
+type Keys struct {
+	Names map[string]uint8
+	IDs   map[int32]string
+	Items []*Item
+}
+
+func keys() *Keys {
+	return &Keys{
+		Names: map[string]uint8{"！": 1, "\U0001F600": 2, "a": 3, "": 4},
+		IDs:   map[int32]string{-1: "x", 2: "y"},
+		Items: []*Item{{ID: 1, Count: 300}},
+	}
+}
+
+func keysSchema(t *testing.T) *schema.Schema {
+	s := schema.New()
+	if err := s.Add(1, 1, reflect.TypeOf(&Keys{})); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func marshalKeys(t *testing.T) string {
+	data, err := cstruct.Marshal(keys())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(data)
+}
+
+// run runs the command in dir and returns the trimmed output
+func run(t *testing.T, dir string, name string, args ...string) string {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v %v: %v\n%s", name, strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestCSharpRoundTrip(t *testing.T) {
+	dotnet, err := exec.LookPath("dotnet")
+	if err != nil {
+		t.Skip("dotnet not found")
+	}
+	src, err := schema.CSharp(keysSchema(t), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"Messages.cs": string(src),
+		"roundtrip.csproj": `<Project Sdk="Microsoft.NET.Sdk">
+  <PropertyGroup>
+    <OutputType>Exe</OutputType>
+    <TargetFramework>net8.0</TargetFramework>
+  </PropertyGroup>
+</Project>
+`,
+		"Program.cs": `using System;
+using System.Collections.Generic;
+
+public static class Program
+{
+	public static void Main()
+	{
+		var k = new Keys();
+		k.Names["！"] = 1;
+		k.Names["\U0001F600"] = 2;
+		k.Names["a"] = 3;
+		k.Names[""] = 4;
+		k.IDs[-1] = "x";
+		k.IDs[2] = "y";
+		k.Items.Add(new Item { ID = 1, Count = 300 });
+		var data = CStruct.Marshal(k);
+		Console.WriteLine(Convert.ToHexString(data).ToLowerInvariant());
+		var k2 = CStruct.Unmarshal<Keys>(data);
+		Console.WriteLine(Convert.ToHexString(CStruct.Marshal(k2)).ToLowerInvariant());
+	}
+}
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := marshalKeys(t)
+	out := strings.Split(run(t, dir, dotnet, "run"), "\n")
+	if len(out) != 2 || out[0] != want || out[1] != want {
+		t.Fatalf("C# %q, want %v", out, want)
+	}
+}
+
+func TestTypeScriptRoundTrip(t *testing.T) {
+	tsc, err := exec.LookPath("tsc")
+	if err != nil {
+		t.Skip("tsc not found")
+	}
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node not found")
+	}
+	src, err := schema.TypeScript(keysSchema(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"messages.ts": string(src),
+		"main.ts": `import { Keys, Item, marshal, unmarshal } from "./messages";
+
+function hex(b: Uint8Array): string {
+	return Array.from(b, (x) => x.toString(16).padStart(2, "0")).join("");
+}
+
+const k = new Keys();
+k.Names.set("！", 1);
+k.Names.set("\u{1F600}", 2);
+k.Names.set("a", 3);
+k.Names.set("", 4);
+k.IDs.set(-1, "x");
+k.IDs.set(2, "y");
+const item = new Item();
+item.ID = 1;
+item.Count = 300;
+k.Items.push(item);
+const data = marshal(k);
+console.log(hex(data));
+console.log(hex(marshal(unmarshal(data, new Keys()))));
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := marshalKeys(t)
+	run(t, dir, tsc, "--target", "es2020", "--module", "commonjs", "main.ts", "messages.ts")
+	out := strings.Split(run(t, dir, node, "main.js"), "\n")
+	if len(out) != 2 || out[0] != want || out[1] != want {
+		t.Fatalf("TypeScript %q, want %v", out, want)
+	}
+}
@@ -0,0 +1,256 @@
+// Package schema exports the byte layout of cstruct messages as a neutral
+// JSON schema, and generates the C# and TypeScript encoders and decoders of
+// the clients from it, which are byte compatible with cstruct-go.
+//
+// The schema is exported in the options of cstruct-go at the time of the
+// export (OptionIntSize, OptionLenMode, OptionVarint, OptionSliceIgnoreNil),
+// every field has its resolved encoding.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+
+	"github.com/CreFire/leaf/util/cstruct-go"
+)
+
+// kinds of Type
+const (
+	KindBool    = "bool"
+	KindInt8    = "int8"
+	KindInt16   = "int16"
+	KindInt32   = "int32"
+	KindInt64   = "int64"
+	KindUint8   = "uint8"
+	KindUint16  = "uint16"
+	KindUint32  = "uint32"
+	KindUint64  = "uint64"
+	KindFloat32 = "float32"
+	KindFloat64 = "float64"
+	KindString  = "string"
+	KindStruct  = "struct" // inlined
+	KindPtr     = "ptr"    // a flag byte, 0 for nil, and the elem
+	KindSlice   = "slice"  // the length and the elems
+	KindArray   = "array"  // the elems
+	KindMap     = "map"    // the length and the pairs sorted by the key
+)
+
+// length prefixes of Field
+const (
+	Len16     = "len16"
+	Len32     = "len32"
+	LenVarint = "varlen"
+)
+
+type Schema struct {
+	Messages []Message `json:"messages"`
+	Structs  []Struct  `json:"structs"`
+
+	types map[string]reflect.Type
+}
+
+type Message struct {
+	Main uint16 `json:"main"`
+	Sub  uint16 `json:"sub"`
+	Type string `json:"type"`
+}
+
+type Struct struct {
+	Name   string  `json:"name"`
+	Fields []Field `json:"fields"`
+}
+
+type Field struct {
+	Name string `json:"name"`
+	Type *Type  `json:"type"`
+	// the Ver field of the struct
+	Version bool `json:"version,omitempty"`
+	// the ver tag, the field is in the data if Ver >= *Ver
+	Ver *int `json:"ver,omitempty"`
+	// the length prefix of the strings, slices and maps in the field
+	Len string `json:"len"`
+	// the integers in the field except int8 uint8 are varints, zigzag for
+	// the signed ones
+	Varint bool `json:"varint,omitempty"`
+	// the []*struct field is the count of the non nil elems and the elems
+	// without flags
+	IgnoreNil bool `json:"ignoreNil,omitempty"`
+}
+
+type Type struct {
+	Kind string `json:"kind"`
+	// the name of a struct
+	Name string `json:"name,omitempty"`
+	Elem *Type  `json:"elem,omitempty"`
+	Key  *Type  `json:"key,omitempty"`
+	// the length of an array
+	Len int `json:"len,omitempty"`
+}
+
+func New() *Schema {
+	return &Schema{types: make(map[string]reflect.Type)}
+}
+
+// Read reads a schema written by Write
+func Read(name string) (*Schema, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	s := New()
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("%v: %v", name, err)
+	}
+	return s, nil
+}
+
+func (s *Schema) Write(name string) error {
+	data, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(data, '\n'), 0644)
+}
+
+// Add adds the message of mainCmdID and subCmdID, t is a struct or a
+// pointer to it. The structs used by the fields are added too.
+func (s *Schema) Add(mainCmdID uint16, subCmdID uint16, t reflect.Type) error {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if err := s.addStruct(t); err != nil {
+		return err
+	}
+	s.Messages = append(s.Messages, Message{Main: mainCmdID, Sub: subCmdID, Type: t.Name()})
+	return nil
+}
+
+// Struct returns the struct of name, nil if not found
+func (s *Schema) Struct(name string) *Struct {
+	for i := range s.Structs {
+		if s.Structs[i].Name == name {
+			return &s.Structs[i]
+		}
+	}
+	return nil
+}
+
+func (s *Schema) addStruct(t reflect.Type) (err error) {
+	if t.Kind() != reflect.Struct {
+		return fmt.Errorf("%v is not a struct", t)
+	}
+	if t.Name() == "" {
+		return fmt.Errorf("anonymous struct %v", t)
+	}
+	if t2, ok := s.types[t.Name()]; ok {
+		if t2 != t {
+			return fmt.Errorf("struct name %v of %v and %v", t.Name(), t2, t)
+		}
+		return nil
+	}
+	s.types[t.Name()] = t
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v: %v", t, r)
+		}
+	}()
+	props := cstruct.GetProperties(t)
+
+	i := len(s.Structs)
+	s.Structs = append(s.Structs, Struct{Name: t.Name()})
+	fields := make([]Field, 0, len(props.Prop))
+	for _, p := range props.Prop {
+		f := Field{Name: p.Name, Varint: p.Varint()}
+		switch p.LenMode() {
+		case cstruct.Len32:
+			f.Len = Len32
+		case cstruct.LenVarint:
+			f.Len = LenVarint
+		default:
+			f.Len = Len16
+		}
+		switch v := p.Ver(); v {
+		case -1:
+			f.Version = true
+		case -2:
+		default:
+			f.Ver = &v
+		}
+		ft := p.Type()
+		if ft.Kind() == reflect.Slice && ft.Elem().Kind() == reflect.Ptr && ft.Elem().Elem().Kind() == reflect.Struct {
+			f.IgnoreNil = cstruct.IgnoreNil(p.LenMode(), p.Varint())
+		}
+		if f.Type, err = s.typeOf(ft); err != nil {
+			return fmt.Errorf("%v.%v: %v", t.Name(), p.Name, err)
+		}
+		fields = append(fields, f)
+	}
+	s.Structs[i].Fields = fields
+	return nil
+}
+
+func (s *Schema) typeOf(t reflect.Type) (*Type, error) {
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Type{Kind: KindBool}, nil
+	case reflect.Int8:
+		return &Type{Kind: KindInt8}, nil
+	case reflect.Int16:
+		return &Type{Kind: KindInt16}, nil
+	case reflect.Int32:
+		return &Type{Kind: KindInt32}, nil
+	case reflect.Int64:
+		return &Type{Kind: KindInt64}, nil
+	case reflect.Int:
+		if cstruct.OptionIntSize == 4 {
+			return &Type{Kind: KindInt32}, nil
+		}
+		return &Type{Kind: KindInt64}, nil
+	case reflect.Uint8:
+		return &Type{Kind: KindUint8}, nil
+	case reflect.Uint16:
+		return &Type{Kind: KindUint16}, nil
+	case reflect.Uint32:
+		return &Type{Kind: KindUint32}, nil
+	case reflect.Uint64:
+		return &Type{Kind: KindUint64}, nil
+	case reflect.Uint:
+		if cstruct.OptionIntSize == 4 {
+			return &Type{Kind: KindUint32}, nil
+		}
+		return &Type{Kind: KindUint64}, nil
+	case reflect.Float32:
+		return &Type{Kind: KindFloat32}, nil
+	case reflect.Float64:
+		return &Type{Kind: KindFloat64}, nil
+	case reflect.String:
+		return &Type{Kind: KindString}, nil
+	case reflect.Struct:
+		if err := s.addStruct(t); err != nil {
+			return nil, err
+		}
+		return &Type{Kind: KindStruct, Name: t.Name()}, nil
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		elem, err := s.typeOf(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		switch t.Kind() {
+		case reflect.Ptr:
+			return &Type{Kind: KindPtr, Elem: elem}, nil
+		case reflect.Slice:
+			return &Type{Kind: KindSlice, Elem: elem}, nil
+		case reflect.Array:
+			return &Type{Kind: KindArray, Elem: elem, Len: t.Len()}, nil
+		}
+		key, err := s.typeOf(t.Key())
+		if err != nil {
+			return nil, err
+		}
+		return &Type{Kind: KindMap, Key: key, Elem: elem}, nil
+	}
+	return nil, fmt.Errorf("unsupported type %v", t)
+}
@@ -0,0 +1,603 @@
+package schema
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const tsRuntime = `export const Len16 = 0;
+export const Len32 = 1;
+export const LenVarint = 2;
+
+const textEncoder = new TextEncoder();
+const textDecoder = new TextDecoder();
+
+function overflow(): never {
+	throw new RangeError("cstruct: integer overflow");
+}
+
+export class Writer {
+	private buf = new Uint8Array(256);
+	private view = new DataView(this.buf.buffer);
+	private pos = 0;
+
+	// the encoded bytes
+	finish(): Uint8Array {
+		return this.buf.slice(0, this.pos);
+	}
+
+	private grow(n: number): number {
+		if (this.pos + n > this.buf.length) {
+			const buf = new Uint8Array(Math.max(this.buf.length * 2, this.pos + n));
+			buf.set(this.buf);
+			this.buf = buf;
+			this.view = new DataView(buf.buffer);
+		}
+		const p = this.pos;
+		this.pos += n;
+		return p;
+	}
+
+	writeBool(v: boolean): void {
+		this.buf[this.grow(1)] = v ? 1 : 0;
+	}
+
+	writeFloat32(v: number): void {
+		this.view.setFloat32(this.grow(4), v, true);
+	}
+
+	writeFloat64(v: number): void {
+		this.view.setFloat64(this.grow(8), v, true);
+	}
+
+	// an integer of size 1, 2 or 4 bytes
+	writeInt(v: number, size: number, varint: boolean): void {
+		const max = 2 ** (size * 8 - 1);
+		if (!Number.isInteger(v) || v < -max || v >= max) {
+			overflow();
+		}
+		if (varint && size > 1) {
+			this.uvarint(v < 0 ? -2 * v - 1 : 2 * v);
+			return;
+		}
+		const p = this.grow(size);
+		if (size === 1) {
+			this.view.setInt8(p, v);
+		} else if (size === 2) {
+			this.view.setInt16(p, v, true);
+		} else {
+			this.view.setInt32(p, v, true);
+		}
+	}
+
+	writeUint(v: number, size: number, varint: boolean): void {
+		if (!Number.isInteger(v) || v < 0 || v >= 2 ** (size * 8)) {
+			overflow();
+		}
+		if (varint && size > 1) {
+			this.uvarint(v);
+			return;
+		}
+		const p = this.grow(size);
+		if (size === 1) {
+			this.view.setUint8(p, v);
+		} else if (size === 2) {
+			this.view.setUint16(p, v, true);
+		} else {
+			this.view.setUint32(p, v, true);
+		}
+	}
+
+	writeInt64(v: bigint, varint: boolean): void {
+		if (v < -(2n ** 63n) || v >= 2n ** 63n) {
+			overflow();
+		}
+		if (varint) {
+			this.bigUvarint(v < 0n ? -2n * v - 1n : 2n * v);
+			return;
+		}
+		this.view.setBigInt64(this.grow(8), v, true);
+	}
+
+	writeUint64(v: bigint, varint: boolean): void {
+		if (v < 0n || v >= 2n ** 64n) {
+			overflow();
+		}
+		if (varint) {
+			this.bigUvarint(v);
+			return;
+		}
+		this.view.setBigUint64(this.grow(8), v, true);
+	}
+
+	writeLen(n: number, mode: number): void {
+		if (mode === LenVarint) {
+			this.uvarint(n);
+		} else if (mode === Len32) {
+			if (n > 0xffffffff) {
+				throw new RangeError("cstruct: length overflows the length prefix");
+			}
+			this.view.setUint32(this.grow(4), n, true);
+		} else {
+			if (n > 0xffff) {
+				throw new RangeError("cstruct: length overflows the length prefix");
+			}
+			this.view.setUint16(this.grow(2), n, true);
+		}
+	}
+
+	writeString(s: string, mode: number): void {
+		this.writeBytes(textEncoder.encode(s), mode);
+	}
+
+	writeBytes(b: Uint8Array, mode: number): void {
+		this.writeLen(b.length, mode);
+		this.buf.set(b, this.grow(b.length));
+	}
+
+	private uvarint(v: number): void {
+		while (v >= 0x80) {
+			this.buf[this.grow(1)] = (v % 0x80) | 0x80;
+			v = Math.floor(v / 0x80);
+		}
+		this.buf[this.grow(1)] = v;
+	}
+
+	private bigUvarint(v: bigint): void {
+		while (v >= 0x80n) {
+			this.buf[this.grow(1)] = Number(v & 0x7fn) | 0x80;
+			v >>= 7n;
+		}
+		this.buf[this.grow(1)] = Number(v);
+	}
+}
+
+export class Reader {
+	private view: DataView;
+	private pos = 0;
+
+	constructor(private buf: Uint8Array) {
+		this.view = new DataView(buf.buffer, buf.byteOffset, buf.byteLength);
+	}
+
+	private next(n: number): number {
+		if (this.pos + n > this.buf.length) {
+			throw new RangeError("cstruct: unexpected EOF");
+		}
+		const p = this.pos;
+		this.pos += n;
+		return p;
+	}
+
+	readBool(): boolean {
+		return this.buf[this.next(1)] !== 0;
+	}
+
+	readFloat32(): number {
+		return this.view.getFloat32(this.next(4), true);
+	}
+
+	readFloat64(): number {
+		return this.view.getFloat64(this.next(8), true);
+	}
+
+	readInt(size: number, varint: boolean): number {
+		if (varint && size > 1) {
+			const u = this.uvarint();
+			const v = u % 2 === 1 ? -(u + 1) / 2 : u / 2;
+			if (v < -(2 ** (size * 8 - 1)) || v >= 2 ** (size * 8 - 1)) {
+				overflow();
+			}
+			return v;
+		}
+		const p = this.next(size);
+		if (size === 1) {
+			return this.view.getInt8(p);
+		} else if (size === 2) {
+			return this.view.getInt16(p, true);
+		}
+		return this.view.getInt32(p, true);
+	}
+
+	readUint(size: number, varint: boolean): number {
+		if (varint && size > 1) {
+			const v = this.uvarint();
+			if (v >= 2 ** (size * 8)) {
+				overflow();
+			}
+			return v;
+		}
+		const p = this.next(size);
+		if (size === 1) {
+			return this.view.getUint8(p);
+		} else if (size === 2) {
+			return this.view.getUint16(p, true);
+		}
+		return this.view.getUint32(p, true);
+	}
+
+	readInt64(varint: boolean): bigint {
+		if (varint) {
+			const u = this.bigUvarint();
+			return (u >> 1n) ^ -(u & 1n);
+		}
+		return this.view.getBigInt64(this.next(8), true);
+	}
+
+	readUint64(varint: boolean): bigint {
+		if (varint) {
+			return this.bigUvarint();
+		}
+		return this.view.getBigUint64(this.next(8), true);
+	}
+
+	// a length, which is not more than the bytes left
+	readLen(mode: number): number {
+		let n: number;
+		if (mode === LenVarint) {
+			n = this.uvarint();
+		} else if (mode === Len32) {
+			n = this.view.getUint32(this.next(4), true);
+		} else {
+			n = this.view.getUint16(this.next(2), true);
+		}
+		if (n > this.buf.length - this.pos) {
+			throw new RangeError("cstruct: unexpected EOF");
+		}
+		return n;
+	}
+
+	readString(mode: number): string {
+		return textDecoder.decode(this.readBytes(mode));
+	}
+
+	readBytes(mode: number): Uint8Array {
+		const n = this.readLen(mode);
+		const p = this.next(n);
+		return this.buf.slice(p, p + n);
+	}
+
+	private uvarint(): number {
+		let v = 0;
+		for (let shift = 0; shift < 64; shift += 7) {
+			const b = this.buf[this.next(1)];
+			v += (b & 0x7f) * 2 ** shift;
+			if (b < 0x80) {
+				return v;
+			}
+		}
+		overflow();
+	}
+
+	private bigUvarint(): bigint {
+		let v = 0n;
+		for (let shift = 0n; shift < 64n; shift += 7n) {
+			const b = this.buf[this.next(1)];
+			v |= BigInt(b & 0x7f) << shift;
+			if (b < 0x80) {
+				if (v >= 2n ** 64n) {
+					overflow();
+				}
+				return v;
+			}
+		}
+		overflow();
+	}
+}
+
+export interface Message {
+	encode(w: Writer): void;
+	decode(r: Reader): this;
+}
+
+export function marshal(m: Message): Uint8Array {
+	const w = new Writer();
+	m.encode(w);
+	return w.finish();
+}
+
+export function unmarshal<T extends Message>(data: Uint8Array, m: T): T {
+	return m.decode(new Reader(data));
+}
+
+// the message id of the main and sub command id
+export function cmdId(main: number, sub: number): number {
+	return (main | (sub << 16)) >>> 0;
+}
+
+// the order of the map keys of cstruct-go, the code points for the
+// strings, which is not the order of the UTF-16 code units of <
+function sortKeys<K extends number | bigint | string>(m: Map<K, unknown>): K[] {
+	const keys = [...m.keys()];
+	if (keys.length > 0 && typeof keys[0] === "string") {
+		return keys.sort((a, b) => compareCodePoints(a as string, b as string));
+	}
+	return keys.sort((a, b) => (a < b ? -1 : a > b ? 1 : 0));
+}
+
+function compareCodePoints(a: string, b: string): number {
+	const n = Math.min(a.length, b.length);
+	for (let i = 0; i < n; i++) {
+		const x = a.charCodeAt(i);
+		const y = b.charCodeAt(i);
+		if (x !== y) {
+			return codePointOrder(x) - codePointOrder(y);
+		}
+	}
+	return a.length - b.length;
+}
+
+// the surrogates are after U+E000-U+FFFF
+function codePointOrder(c: number): number {
+	if (c >= 0xe000) {
+		return c - 0x800;
+	}
+	if (c >= 0xd800) {
+		return c + 0x2000;
+	}
+	return c;
+}
+`
+
+// TypeScript generates a TypeScript module of the structs of s, a class
+// with encode and decode methods for each struct, and the runtime.
+func TypeScript(s *Schema) ([]byte, error) {
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+
+	g := new(printer)
+	g.p("// Code generated by cstructschema. DO NOT EDIT.")
+	g.p("")
+	g.b.WriteString(tsRuntime)
+	for i := range s.Structs {
+		g.p("")
+		tsStruct(g, &s.Structs[i])
+	}
+	if len(s.Messages) > 0 {
+		g.p("")
+		g.p("export const Messages = [")
+		for _, m := range s.Messages {
+			g.p("{ main: %v, sub: %v, type: %v },", m.Main, m.Sub, m.Type)
+		}
+		g.p("];")
+	}
+	return g.b.Bytes(), nil
+}
+
+func tsType(t *Type) string {
+	switch t.Kind {
+	case KindBool:
+		return "boolean"
+	case KindInt64, KindUint64:
+		return "bigint"
+	case KindString:
+		return "string"
+	case KindStruct:
+		return t.Name
+	case KindPtr:
+		return tsType(t.Elem) + " | null"
+	case KindSlice, KindArray:
+		if isBytes(t) {
+			return "Uint8Array"
+		}
+		if t.Elem.Kind == KindPtr {
+			return "(" + tsType(t.Elem) + ")[]"
+		}
+		return tsType(t.Elem) + "[]"
+	case KindMap:
+		return "Map<" + tsType(t.Key) + ", " + tsType(t.Elem) + ">"
+	}
+	return "number"
+}
+
+func tsZero(t *Type) string {
+	switch t.Kind {
+	case KindBool:
+		return "false"
+	case KindInt64, KindUint64:
+		return "0n"
+	case KindString:
+		return `""`
+	case KindStruct:
+		return "new " + t.Name + "()"
+	case KindPtr:
+		return "null"
+	case KindSlice:
+		if isBytes(t) {
+			return "new Uint8Array(0)"
+		}
+		return "[]"
+	case KindArray:
+		return fmt.Sprintf("Array.from({ length: %v }, () => %v)", t.Len, tsZero(t.Elem))
+	case KindMap:
+		return "new Map<" + tsType(t.Key) + ", " + tsType(t.Elem) + ">()"
+	}
+	return "0"
+}
+
+func tsStruct(g *printer, s *Struct) {
+	g.tmp = 0
+	g.p("export class %v {", s.Name)
+	for _, f := range s.Fields {
+		g.p("%v: %v = %v;", f.Name, tsType(f.Type), tsZero(f.Type))
+	}
+
+	ver := versioned(s)
+	g.p("")
+	g.p("encode(w: Writer): void {")
+	if ver {
+		g.p("let ver = -2;")
+	}
+	for i := range s.Fields {
+		f := &s.Fields[i]
+		if f.Ver != nil {
+			g.p("if (%v > ver) {", *f.Ver)
+			g.p(`throw new RangeError("cstruct: field %v ver %v > Ver " + ver);`, f.Name, *f.Ver)
+			g.p("}")
+		}
+		tsEnc(g, "this."+f.Name, f.Type, f, true)
+		if f.Version && ver {
+			g.p("ver = Number(this.%v);", f.Name)
+		}
+	}
+	g.p("}")
+
+	g.p("")
+	g.p("decode(r: Reader): this {")
+	if ver {
+		g.p("let ver = -2;")
+	}
+	for i := range s.Fields {
+		f := &s.Fields[i]
+		if f.Ver != nil {
+			g.p("if (%v <= ver) {", *f.Ver)
+		}
+		tsDec(g, "this."+f.Name, f.Type, f, true)
+		if f.Ver != nil {
+			g.p("}")
+		}
+		if f.Version && ver {
+			g.p("ver = Number(this.%v);", f.Name)
+		}
+	}
+	g.p("return this;")
+	g.p("}")
+	g.p("}")
+}
+
+func tsEnc(g *printer, x string, t *Type, f *Field, top bool) {
+	varint := strconv.FormatBool(f.Varint)
+	switch t.Kind {
+	case KindBool:
+		g.p("w.writeBool(%v);", x)
+	case KindInt8, KindInt16, KindInt32:
+		g.p("w.writeInt(%v, %v, %v);", x, intSize(t.Kind), varint)
+	case KindUint8, KindUint16, KindUint32:
+		g.p("w.writeUint(%v, %v, %v);", x, intSize(t.Kind), varint)
+	case KindInt64:
+		g.p("w.writeInt64(%v, %v);", x, varint)
+	case KindUint64:
+		g.p("w.writeUint64(%v, %v);", x, varint)
+	case KindFloat32:
+		g.p("w.writeFloat32(%v);", x)
+	case KindFloat64:
+		g.p("w.writeFloat64(%v);", x)
+	case KindString:
+		g.p("w.writeString(%v, %v);", x, lenMode(f))
+	case KindStruct:
+		g.p("%v.encode(w);", x)
+	case KindPtr:
+		v := g.v("v")
+		g.p("const %v = %v;", v, x)
+		g.p("w.writeBool(%v !== null);", v)
+		g.p("if (%v !== null) {", v)
+		tsEnc(g, v, t.Elem, f, false)
+		g.p("}")
+	case KindSlice:
+		if isBytes(t) {
+			g.p("w.writeBytes(%v, %v);", x, lenMode(f))
+			return
+		}
+		e := g.v("e")
+		if top && f.IgnoreNil {
+			g.p("w.writeLen(%v.filter((%v) => %v !== null).length, %v);", x, e, e, lenMode(f))
+			g.p("for (const %v of %v) {", e, x)
+			g.p("%v?.encode(w);", e)
+			g.p("}")
+			return
+		}
+		g.p("w.writeLen(%v.length, %v);", x, lenMode(f))
+		g.p("for (const %v of %v) {", e, x)
+		tsEnc(g, e, t.Elem, f, false)
+		g.p("}")
+	case KindArray:
+		g.p("if (%v.length !== %v) {", x, t.Len)
+		g.p(`throw new RangeError("cstruct: array length " + %v.length + " != %v");`, x, t.Len)
+		g.p("}")
+		e := g.v("e")
+		g.p("for (const %v of %v) {", e, x)
+		tsEnc(g, e, t.Elem, f, false)
+		g.p("}")
+	case KindMap:
+		m, k, v := g.v("m"), g.v("k"), g.v("v")
+		g.p("const %v = %v;", m, x)
+		g.p("w.writeLen(%v.size, %v);", m, lenMode(f))
+		g.p("for (const %v of sortKeys(%v)) {", k, m)
+		tsEnc(g, k, t.Key, f, false)
+		g.p("const %v = %v.get(%v) as %v;", v, m, k, tsType(t.Elem))
+		tsEnc(g, v, t.Elem, f, false)
+		g.p("}")
+	}
+}
+
+// tsDec decodes into x
+func tsDec(g *printer, x string, t *Type, f *Field, top bool) {
+	varint := strconv.FormatBool(f.Varint)
+	switch t.Kind {
+	case KindBool:
+		g.p("%v = r.readBool();", x)
+	case KindInt8, KindInt16, KindInt32:
+		g.p("%v = r.readInt(%v, %v);", x, intSize(t.Kind), varint)
+	case KindUint8, KindUint16, KindUint32:
+		g.p("%v = r.readUint(%v, %v);", x, intSize(t.Kind), varint)
+	case KindInt64:
+		g.p("%v = r.readInt64(%v);", x, varint)
+	case KindUint64:
+		g.p("%v = r.readUint64(%v);", x, varint)
+	case KindFloat32:
+		g.p("%v = r.readFloat32();", x)
+	case KindFloat64:
+		g.p("%v = r.readFloat64();", x)
+	case KindString:
+		g.p("%v = r.readString(%v);", x, lenMode(f))
+	case KindStruct:
+		g.p("%v = new %v().decode(r);", x, t.Name)
+	case KindPtr:
+		g.p("if (r.readBool()) {")
+		tsDec(g, x, t.Elem, f, false)
+		g.p("} else {")
+		g.p("%v = null;", x)
+		g.p("}")
+	case KindSlice:
+		if isBytes(t) {
+			g.p("%v = r.readBytes(%v);", x, lenMode(f))
+			return
+		}
+		n, a, i := g.v("n"), g.v("a"), g.v("i")
+		g.p("const %v = r.readLen(%v);", n, lenMode(f))
+		g.p("const %v: %v = [];", a, tsType(t))
+		g.p("for (let %v = 0; %v < %v; %v++) {", i, i, n, i)
+		if top && f.IgnoreNil {
+			g.p("%v.push(new %v().decode(r));", a, t.Elem.Elem.Name)
+		} else {
+			e := g.v("e")
+			g.p("let %v: %v;", e, tsType(t.Elem))
+			tsDec(g, e, t.Elem, f, false)
+			g.p("%v.push(%v);", a, e)
+		}
+		g.p("}")
+		g.p("%v = %v;", x, a)
+	case KindArray:
+		a, i, e := g.v("a"), g.v("i"), g.v("e")
+		g.p("const %v: %v = [];", a, tsType(t))
+		g.p("for (let %v = 0; %v < %v; %v++) {", i, i, t.Len, i)
+		g.p("let %v: %v;", e, tsType(t.Elem))
+		tsDec(g, e, t.Elem, f, false)
+		g.p("%v.push(%v);", a, e)
+		g.p("}")
+		g.p("%v = %v;", x, a)
+	case KindMap:
+		n, m, i, k, v := g.v("n"), g.v("m"), g.v("i"), g.v("k"), g.v("v")
+		g.p("const %v = r.readLen(%v);", n, lenMode(f))
+		g.p("const %v = %v;", m, tsZero(t))
+		g.p("for (let %v = 0; %v < %v; %v++) {", i, i, n, i)
+		g.p("let %v: %v;", k, tsType(t.Key))
+		tsDec(g, k, t.Key, f, false)
+		g.p("let %v: %v;", v, tsType(t.Elem))
+		tsDec(g, v, t.Elem, f, false)
+		g.p("%v.set(%v, %v);", m, k, v)
+		g.p("}")
+		g.p("%v = %v;", x, m)
+	}
+}